@@ -3,14 +3,16 @@ package v1
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/config"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/handlers"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/middleware/skip"
 )
 
 func parseIntEnv(key string, def int) int {
@@ -25,58 +27,174 @@ func parseIntEnv(key string, def int) int {
 	return i
 }
 
-func RegisterRoutes(app *fiber.App, appCfg config.AppConfig, storage service.StorageService, users repo.Users, refresh repo.RefreshTokens) {
+const tusRoutes = "/api/v1/me/files/uploads"
+
+func isTusRoute(c *fiber.Ctx) bool {
+	p := c.Path()
+	return p == tusRoutes || strings.HasPrefix(p, tusRoutes+"/")
+}
+
+func RegisterRoutes(
+	app *fiber.App,
+	appCfg config.AppConfig,
+	limitStore fiber.Storage,
+	storage service.StorageService,
+	folders *service.FolderService,
+	shares *service.ShareService,
+	quotas *service.QuotaPolicy,
+	apiTokens *service.APITokenService,
+	mfa *service.MFAService,
+	guard *service.LoginGuard,
+	passwords service.PasswordHasher,
+	passwordPolicy *service.PasswordPolicy,
+	accounts *service.AccountService,
+	oidc *service.OIDCService,
+	jwtKeys *service.JWTKeys,
+	denylist *service.AccessTokenDenylist,
+	users repo.Users,
+	refresh repo.RefreshTokens,
+	events repo.SecurityEvents,
+) {
 	v1 := app.Group("/api/v1")
 	v1.Get("/health", handlers.HealthCheck)
+	app.Get("/.well-known/jwks.json", handlers.JWKSHandler(jwtKeys))
 
-	secret := os.Getenv("AUTH_JWT_SECRET")
 	accessTTL := time.Duration(parseIntEnv("AUTH_ACCESS_TTL_MIN", 10)) * time.Minute
 	refreshTTL := time.Duration(parseIntEnv("AUTH_REFRESH_TTL_MIN", 0)) * time.Minute
 	if refreshTTL == 0 {
 		refreshTTL = time.Duration(parseIntEnv("AUTH_REFRESH_TTL_DAYS", 7)) * 24 * time.Hour
 	}
 
-	authMW := handlers.RequireAuth(secret)
-	authHandlers := handlers.NewAuthHandler(users, refresh, secret, accessTTL, refreshTTL)
+	authMW := handlers.RequireAuth(jwtKeys, apiTokens, denylist)
+	// account management needs a real login, not an API token
+	sessionOnly := handlers.RequireSession
+	authHandlers := handlers.NewAuthHandler(users, refresh, events, mfa, guard, passwords, jwtKeys, denylist, accessTTL, refreshTTL)
 
-	sensitive := v1.Group("/auth", limiter.New(limiter.Config{
-		Max:        appCfg.RateLimitAuthMax,
-		Expiration: time.Duration(appCfg.RateLimitAuthExpire) * time.Second,
-		LimitReached: func(c *fiber.Ctx) error {
-			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests slow down son")
-		},
-	}))
+	sensitive := v1.Group("/auth", handlers.RateLimit(limitStore, "auth", appCfg.RateLimitAuthMax, time.Duration(appCfg.RateLimitAuthExpire)*time.Second, "too many requests slow down son"))
 	sensitive.Post("/login", authHandlers.LoginHandler)
+	sensitive.Post("/login/mfa", authHandlers.MFALoginHandler)
 	sensitive.Post("/refresh", authHandlers.RefreshHandler)
-	sensitive.Post("/logout", authMW, authHandlers.LogoutHandler)
-
-	userHandlers := handlers.NewUserHandler(users)
-	userLimiter := v1.Group("/users", limiter.New(limiter.Config{
-		Max:        appCfg.RateLimitUserMax,
-		Expiration: time.Duration(appCfg.RateLimitUserExpire) * time.Second,
-		LimitReached: func(c *fiber.Ctx) error {
-			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests guy")
-		},
-	}))
+	sensitive.Post("/logout", authMW, sessionOnly, authHandlers.LogoutHandler)
+	accountHandlers := handlers.NewAccountHandler(accounts)
+	sensitive.Post("/email/verify", accountHandlers.VerifyEmailHandler)
+	sensitive.Post("/password/forgot", accountHandlers.ForgotPasswordHandler)
+	sensitive.Post("/password/reset", accountHandlers.ResetPasswordHandler)
+	if oidc != nil {
+		oidcHandlers := handlers.NewOIDCHandler(oidc, authHandlers)
+		sensitive.Get("/oidc/login", oidcHandlers.OIDCLoginHandler)
+		sensitive.Get("/oidc/callback", oidcHandlers.OIDCCallbackHandler)
+	}
+
+	userHandlers := handlers.NewUserHandler(users, refresh, passwords, passwordPolicy, denylist)
+	userLimiter := v1.Group("/users", handlers.RateLimit(limitStore, "users", appCfg.RateLimitUserMax, time.Duration(appCfg.RateLimitUserExpire)*time.Second, "too many requests guy"))
+	adminOnly := handlers.RequireRole(models.RoleAdmin)
+	// sign up stays open; everything else is limited to the user themselves or an admin
 	userLimiter.Post("", userHandlers.CreateUserHandler)
-	userLimiter.Get("/:id", userHandlers.GetUserByIDHandler)
-	userLimiter.Patch("/:id", userHandlers.UpdateUserHandler)
-	userLimiter.Delete("/:id", userHandlers.DeleteUserHandler)
-	userLimiter.Get("", userHandlers.GetAllUsersHandler)
+	userLimiter.Get("/:id", authMW, sessionOnly, userHandlers.GetUserByIDHandler)
+	userLimiter.Patch("/:id", authMW, sessionOnly, handlers.RequireRole(models.RoleAdmin, models.RoleMember), userHandlers.UpdateUserHandler)
+	userLimiter.Delete("/:id", authMW, sessionOnly, adminOnly, userHandlers.DeleteUserHandler)
+	userLimiter.Get("", authMW, sessionOnly, adminOnly, userHandlers.GetAllUsersHandler)
+	mfaHandlers := handlers.NewMFAHandler(mfa)
+	userLimiter.Delete("/:id/mfa", authMW, sessionOnly, adminOnly, mfaHandlers.ResetUserMFAHandler)
+	userLimiter.Delete("/:id/lockout", authMW, sessionOnly, adminOnly, authHandlers.UnlockUserHandler)
+
+	// sessions and MFA are registered ahead of the /me group so read-only
+	// users, whom it stops from writing, can still secure their own account
+	sessionHandlers := handlers.NewSessionHandler(refresh, denylist)
+	sessions := v1.Group("/me/sessions", authMW, sessionOnly, handlers.RateLimit(limitStore, "sessions", appCfg.RateLimitUserMax, time.Duration(appCfg.RateLimitUserExpire)*time.Second, "too many requests guy"))
+	sessions.Get("", sessionHandlers.ListSessionsHandler)
+	sessions.Post("/revoke-all", sessionHandlers.RevokeAllSessionsHandler)
+	sessions.Delete("/:sessionID", sessionHandlers.RevokeSessionHandler)
+
+	mfaRoutes := v1.Group("/me/mfa", authMW, sessionOnly, handlers.RateLimit(limitStore, "mfa", appCfg.RateLimitAuthMax, time.Duration(appCfg.RateLimitAuthExpire)*time.Second, "too many requests slow down son"))
+	mfaRoutes.Get("", mfaHandlers.GetMFAStatusHandler)
+	mfaRoutes.Post("/totp", mfaHandlers.EnrollTOTPHandler)
+	mfaRoutes.Post("/totp/confirm", mfaHandlers.ConfirmTOTPHandler)
+	mfaRoutes.Delete("/totp", mfaHandlers.DisableTOTPHandler)
+	mfaRoutes.Post("/recovery-codes", mfaHandlers.RegenerateRecoveryCodesHandler)
+
+	// sends mail, so it shares the tighter auth limit
+	emailRoutes := v1.Group("/me/email", authMW, sessionOnly, handlers.RateLimit(limitStore, "email", appCfg.RateLimitAuthMax, time.Duration(appCfg.RateLimitAuthExpire)*time.Second, "too many requests slow down son"))
+	emailRoutes.Post("/verification", accountHandlers.RequestEmailVerificationHandler)
 
 	fileHandlers := handlers.NewFileHandler(storage)
-	me := v1.Group("/me", authMW)
-	filesLimiter := me.Group("/files", limiter.New(limiter.Config{
-		Max:        appCfg.RateLimitFileMax,
-		Expiration: time.Duration(appCfg.RateLimitFileExpire) * time.Second,
-		LimitReached: func(c *fiber.Ctx) error {
-			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests guy")
-		},
-	}))
+	folderHandlers := handlers.NewFolderHandler(folders)
+	// read-only users can browse and download but not change anything
+	me := v1.Group("/me", authMW, handlers.RequireRoleToWrite(models.RoleAdmin, models.RoleMember))
+	// API tokens only reach what their scopes cover
+	filesScope := handlers.RequireScope(models.ScopeFilesRead, models.ScopeFilesWrite)
+	me.Use("/files", filesScope)
+	me.Use("/folders", filesScope)
+	me.Use("/usage", filesScope)
+	me.Use("/shares", handlers.RequireScope(models.ScopeSharesRead, models.ScopeSharesWrite))
+	me.Use("/tokens", sessionOnly)
+	// a tus upload sends a HEAD and a PATCH per chunk, which would use up the
+	// files budget within one large upload, so its routes are limited on their own
+	filesLimiter := me.Group("/files", skip.New(
+		handlers.RateLimit(limitStore, "files", appCfg.RateLimitFileMax, time.Duration(appCfg.RateLimitFileExpire)*time.Second, "too many requests guy"),
+		isTusRoute,
+	))
 	me.Get("/files", fileHandlers.GetUserFilesHandler)
-	me.Get("/files/:fileID", fileHandlers.GetUserFileByIDHandler)
+	// trash and by-path routes must be registered before /:fileID so they aren't taken as an ID
+	me.Get("/files/trash", fileHandlers.ListTrashHandler)
+	me.Get("/files/by-path", folderHandlers.FileByPathHandler)
+	filesLimiter.Delete("/trash", fileHandlers.EmptyTrashHandler)
+	filesLimiter.Post("/:fileID/restore", fileHandlers.RestoreFileHandler)
+	me.Get("/files/:fileID", fileHandlers.GetUserFileByIDHandler) // fiber also routes HEAD here
 	filesLimiter.Delete("/:fileID", fileHandlers.DeleteUserFileByIDHandler)
 	filesLimiter.Post("/upload", fileHandlers.UploadFileHandler)
 	// me.Get("/files/search", fileHandlers.SearchFilesHandler) @@@@@@@@@ v2 @@@@@@@@@
 	filesLimiter.Patch("/:fileID/rename", fileHandlers.RenameFileHandler)
+	filesLimiter.Patch("/:fileID/move", folderHandlers.MoveFileHandler)
+	me.Get("/files/:fileID/versions", fileHandlers.ListVersionsHandler)
+	me.Get("/files/:fileID/versions/:version", fileHandlers.GetVersionHandler)
+	filesLimiter.Post("/:fileID/versions/:version/promote", fileHandlers.PromoteVersionHandler)
+
+	foldersLimiter := me.Group("/folders", handlers.RateLimit(limitStore, "folders", appCfg.RateLimitFileMax, time.Duration(appCfg.RateLimitFileExpire)*time.Second, "too many requests guy"))
+	me.Get("/folders", folderHandlers.ListFolderHandler)
+	me.Get("/folders/:folderID", folderHandlers.ListFolderHandler)
+	foldersLimiter.Post("", folderHandlers.CreateFolderHandler)
+	foldersLimiter.Patch("/:folderID", folderHandlers.UpdateFolderHandler)
+	foldersLimiter.Delete("/:folderID", folderHandlers.DeleteFolderHandler)
+
+	usageHandlers := handlers.NewUsageHandler(quotas)
+	me.Get("/usage", usageHandlers.GetUsageHandler)
+
+	shareHandlers := handlers.NewShareHandler(shares)
+	sharesLimiter := me.Group("/shares", handlers.RateLimit(limitStore, "shares", appCfg.RateLimitFileMax, time.Duration(appCfg.RateLimitFileExpire)*time.Second, "too many requests guy"))
+	sharesLimiter.Get("", shareHandlers.ListSharesHandler)
+	sharesLimiter.Post("", shareHandlers.CreateShareHandler)
+	sharesLimiter.Delete("/:shareID", shareHandlers.RevokeShareHandler)
+
+	tokenHandlers := handlers.NewAPITokenHandler(apiTokens)
+	tokensLimiter := me.Group("/tokens", handlers.RateLimit(limitStore, "tokens", appCfg.RateLimitUserMax, time.Duration(appCfg.RateLimitUserExpire)*time.Second, "too many requests guy"))
+	tokensLimiter.Get("", tokenHandlers.ListAPITokensHandler)
+	tokensLimiter.Post("", tokenHandlers.CreateAPITokenHandler)
+	tokensLimiter.Delete("/:tokenID", tokenHandlers.RevokeAPITokenHandler)
+
+	// public share links live outside /api/v1 and need no token, so they get the
+	// stricter auth limits to slow down password guessing
+	public := app.Group("/s", handlers.RateLimit(limitStore, "public-shares", appCfg.RateLimitAuthMax, time.Duration(appCfg.RateLimitAuthExpire)*time.Second, "too many requests slow down son"))
+	public.Get("/:token", shareHandlers.PublicShareHandler)
+	public.Get("/:token/files/:fileID", shareHandlers.PublicSharedFileHandler)
+
+	// presigned URLs, only offered by backends that support them
+	if presigner, ok := storage.(service.Presigner); ok {
+		presignHandlers := handlers.NewPresignHandler(presigner)
+		filesLimiter.Get("/:fileID/download-url", presignHandlers.DownloadURLHandler)
+		filesLimiter.Post("/presigned", presignHandlers.UploadURLHandler)
+		filesLimiter.Post("/presigned/:fileID/confirm", presignHandlers.ConfirmUploadHandler)
+	}
+
+	// tus resumable uploads, only offered by backends that support them
+	if resumable, ok := storage.(service.ResumableUploads); ok {
+		uploadHandlers := handlers.NewUploadHandler(resumable)
+		tus := me.Group("/files/uploads", handlers.RequireTusResumable)
+		tus.Options("", uploadHandlers.OptionsHandler)
+		// only starting an upload counts, its chunks don't
+		tus.Post("", handlers.RateLimit(limitStore, "uploads", appCfg.RateLimitFileMax, time.Duration(appCfg.RateLimitFileExpire)*time.Second, "too many requests guy"), uploadHandlers.CreateUploadHandler)
+		tus.Head("/:uploadID", uploadHandlers.HeadUploadHandler)
+		tus.Patch("/:uploadID", uploadHandlers.PatchUploadHandler)
+		tus.Delete("/:uploadID", uploadHandlers.TerminateUploadHandler)
+	}
 }
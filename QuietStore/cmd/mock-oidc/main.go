@@ -0,0 +1,38 @@
+// Command mock-oidc runs the oidctest provider for trying OIDC logins
+// locally. It logs every login in as the user given by its flags, without a
+// login page. Point the server at it with
+//
+//	OIDC_ISSUER_URL=http://localhost:9090 OIDC_CLIENT_ID=quietstore OIDC_CLIENT_SECRET=secret
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL, as the server reaches it")
+	clientID := flag.String("client-id", "quietstore", "client id")
+	clientSecret := flag.String("client-secret", "secret", "client secret, empty for a public client")
+	subject := flag.String("sub", "mock-user", "subject of the user who logs in")
+	email := flag.String("email", "mock-user@example.com", "email of the user who logs in")
+	verified := flag.Bool("email-verified", true, "whether the email is reported as verified")
+	username := flag.String("username", "", "preferred_username of the user who logs in")
+	flag.Parse()
+
+	p, err := oidctest.New(*issuer, *clientID, *clientSecret, oidctest.User{
+		Subject:           *subject,
+		Email:             *email,
+		EmailVerified:     *verified,
+		PreferredUsername: *username,
+	})
+	if err != nil {
+		log.Fatalf("[mock-oidc] %v", err)
+	}
+	log.Printf("[mock-oidc] issuer %s listening on %s, logging in %s", *issuer, *addr, *email)
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
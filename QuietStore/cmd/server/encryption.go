@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/config"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func decodeMasterKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+// loadKeyring builds the keyring from config, or returns nil when encryption
+// at rest is off.
+func loadKeyring(cfg config.EncryptionConfig) (*service.Keyring, error) {
+	raw := cfg.MasterKey
+	if cfg.MasterKeyFile != "" {
+		b, err := os.ReadFile(cfg.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		raw = string(b)
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	active, err := decodeMasterKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, p := range strings.Split(cfg.PreviousKeys, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		key, err := decodeMasterKey(p)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		previous = append(previous, key)
+	}
+	return service.NewKeyring(active, previous...)
+}
+
+// rotateMasterKey is the "rotate-master-key" command: it rewraps every stored
+// data key with the configured master key, so the previous keys can be dropped
+// from the config once it has finished.
+func rotateMasterKey(pool *pgxpool.Pool, keys *service.Keyring) {
+	if keys == nil {
+		log.Fatal("rotate-master-key: no encryption master key configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	n, err := service.RotateMasterKey(ctx, repo.NewDataKeysPGX(pool), keys)
+	if err != nil {
+		log.Fatalf("rotate-master-key: rewrapped %d data keys, then failed: %v", n, err)
+	}
+	log.Printf("rotate-master-key: rewrapped %d data keys", n)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/config"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+)
+
+// loadJWTKeys reads every <kid>.pem in the keys directory. The active key is
+// the configured one, or the only private key when there is just one.
+func loadJWTKeys(cfg config.AuthConfig) (*service.JWTKeys, error) {
+	if cfg.KeysDir == "" {
+		key, err := service.GenerateJWTKey("dev")
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("no jwt keys directory configured; signing with a throwaway key, so tokens won't survive a restart")
+		return service.NewJWTKeys(cfg.Issuer, cfg.Audience, key)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.KeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		active  *service.JWTKey
+		others  []*service.JWTKey
+		signers []*service.JWTKey
+	)
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read jwt key: %w", err)
+		}
+		key, err := service.ParseJWTKey(strings.TrimSuffix(filepath.Base(p), ".pem"), data)
+		if err != nil {
+			return nil, err
+		}
+		if key.CanSign() {
+			signers = append(signers, key)
+		}
+		if key.KID() == cfg.ActiveKID {
+			active = key
+			continue
+		}
+		others = append(others, key)
+	}
+
+	switch {
+	case cfg.ActiveKID != "" && active == nil:
+		return nil, fmt.Errorf("active jwt key %q not found in %s", cfg.ActiveKID, cfg.KeysDir)
+	case active != nil:
+	case len(signers) == 1:
+		active = signers[0]
+		others = removeJWTKey(others, active)
+	case len(signers) == 0:
+		return nil, errors.New("no jwt private keys found in " + cfg.KeysDir)
+	default:
+		return nil, errors.New("several jwt private keys found; set AUTH_JWT_ACTIVE_KID to pick one")
+	}
+	log.Printf("signing access tokens with jwt key %q, %d more key(s) verify only", active.KID(), len(others))
+	return service.NewJWTKeys(cfg.Issuer, cfg.Audience, active, others...)
+}
+
+func removeJWTKey(keys []*service.JWTKey, key *service.JWTKey) []*service.JWTKey {
+	out := keys[:0]
+	for _, k := range keys {
+		if k != key {
+			out = append(out, k)
+		}
+	}
+	return out
+}
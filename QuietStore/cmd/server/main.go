@@ -30,6 +30,7 @@ import (
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/handlers"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
@@ -59,6 +60,16 @@ func mustConnectDB(dsn string) *pgxpool.Pool {
 	}
 }
 
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -77,33 +88,107 @@ func main() {
 		log.Fatalf("DB migrate failed: %v", err)
 	}
 
+	keyring, err := loadKeyring(cfg.Encryption)
+	if err != nil {
+		log.Fatalf("encryption config: %v", err)
+	}
+
 	usersRepo := repo.NewUsersPGX(pool)
+
+	// one-off admin commands run against the same config and database
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-master-key":
+			rotateMasterKey(pool, keyring)
+		case "set-role":
+			setRole(usersRepo, os.Args[2:])
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	jwtKeys, err := loadJWTKeys(cfg.Auth)
+	if err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+
 	filesRepo := repo.NewFilesPGX(pool)
 	refreshRepo := repo.NewRefreshPGX(pool)
+	uploadsRepo := repo.NewUploadsPGX(pool)
+	presignedRepo := repo.NewPresignedPGX(pool)
+	versionsRepo := repo.NewVersionsPGX(pool)
+	foldersRepo := repo.NewFoldersPGX(pool)
+	sharesRepo := repo.NewSharesPGX(pool)
+	usageRepo := repo.NewUsagePGX(pool)
+	blobsRepo := repo.NewBlobsPGX(pool)
 
-	// minio
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	ak := os.Getenv("MINIO_ACCESS_KEY")
-	sk := os.Getenv("MINIO_SECRET_KEY")
-	bucket := os.Getenv("MINIO_BUCKET")
-	useSSL := os.Getenv("MINIO_USE_SSL") == "true"
+	quotas := service.NewQuotaPolicy(usersRepo, usageRepo, cfg.App.MaxFileSize, cfg.App.DefaultQuota)
 
-	s3c := newMinIOS3Client(endpoint, ak, sk, useSSL)
-	ensureBucket(context.Background(), s3c, bucket)
-	storage := service.NewMinIOStorageService(s3c, bucket, filesRepo)
+	var (
+		storage service.StorageService
+		s3c     *s3.Client
+		bucket  string
+	)
+	switch cfg.Storage.Backend {
+	case "local":
+		local, err := service.NewLocalStorageService(cfg.Storage.BasePath, filesRepo, versionsRepo, usersRepo, blobsRepo,
+			service.LocalOptions{
+				Fsync:       service.FsyncMode(cfg.Storage.Fsync),
+				MaxVersions: cfg.Storage.MaxVersions,
+				Quota:       quotas,
+				Dedup:       cfg.Storage.Dedup,
+			})
+		if err != nil {
+			log.Fatalf("local storage init failed: %v", err)
+		}
+		if keyring != nil {
+			log.Fatalf("encryption at rest is only supported by the minio backend, unset the encryption master key or use minio")
+		}
+		storage = local
+	default:
+		// minio
+		endpoint := os.Getenv("MINIO_ENDPOINT")
+		ak := os.Getenv("MINIO_ACCESS_KEY")
+		sk := os.Getenv("MINIO_SECRET_KEY")
+		bucket = os.Getenv("MINIO_BUCKET")
+		useSSL := os.Getenv("MINIO_USE_SSL") == "true"
+
+		s3c = newMinIOS3Client(endpoint, ak, sk, useSSL)
+		ensureBucket(context.Background(), s3c, bucket)
+
+		opts := service.MinIOOptions{
+			UploadTTL:   time.Duration(cfg.Storage.UploadSessionTTL) * time.Second,
+			PresignTTL:  time.Duration(cfg.Storage.PresignTTL) * time.Second,
+			MaxVersions: cfg.Storage.MaxVersions,
+			Quota:       quotas,
+			Dedup:       cfg.Storage.Dedup,
+			Keyring:     keyring,
+		}
+		// presigned URLs must point at an address clients can reach
+		if public := os.Getenv("MINIO_PUBLIC_ENDPOINT"); public != "" {
+			opts.PresignClient = newMinIOS3Client(public, ak, sk, useSSL)
+		}
+		storage = service.NewMinIOStorageService(s3c, bucket, filesRepo, versionsRepo, usersRepo, uploadsRepo, presignedRepo, blobsRepo, opts)
+	}
 
 	requireHTTPS := os.Getenv("REQUIRE_HTTPS") == "true"
 
 	app := fiber.New(fiber.Config{
-		ServerHeader:            "QuietStore/1.0",
-		ReadTimeout:             cfg.Server.ReadTimeout,
-		WriteTimeout:            cfg.Server.WriteTimeout,
-		BodyLimit:               cfg.Server.BodyLimit,
-		EnableTrustedProxyCheck: true,
-		TrustedProxies:          []string{"0.0.0.0/0"},
+		ServerHeader:                 "QuietStore/1.0",
+		ReadTimeout:                  cfg.Server.ReadTimeout,
+		WriteTimeout:                 cfg.Server.WriteTimeout,
+		BodyLimit:                    cfg.Server.BodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		EnableTrustedProxyCheck:      true,
+		TrustedProxies:               splitList(cfg.Server.TrustedProxies),
+		ProxyHeader:                  cfg.Server.ProxyHeader,
 	})
 
 	app.Use(helmet.New())
+	// multipart uploads and tus PATCHes stream their bodies to storage
+	app.Use(handlers.LimitBody(cfg.Server.BodyLimit, "/api/v1/me/files/upload", "/api/v1/me/files/uploads/*"))
 
 	if requireHTTPS {
 		app.Use(func(c *fiber.Ctx) error {
@@ -122,7 +207,61 @@ func main() {
 	app.Use(logger.New())
 	app.Use(recover.New())
 
-	v1.RegisterRoutes(app, cfg.App, storage, usersRepo, refreshRepo)
+	folders := service.NewFolderService(foldersRepo, filesRepo)
+	argon := service.Argon2idParams{
+		Memory:  uint32(cfg.Auth.Argon2Memory),
+		Time:    uint32(cfg.Auth.Argon2Time),
+		Threads: uint8(cfg.Auth.Argon2Threads),
+	}
+	passwords, err := service.NewPasswordHasher(cfg.Auth.PasswordAlgorithm, argon, cfg.Auth.BcryptCost)
+	if err != nil {
+		log.Fatalf("password hasher: %v", err)
+	}
+	var breached service.BreachedPasswords
+	if cfg.Auth.BreachedPasswordsPath != "" {
+		if breached, err = service.LoadBreachedPasswords(cfg.Auth.BreachedPasswordsPath); err != nil {
+			log.Fatalf("breached passwords: %v", err)
+		}
+	}
+	passwordPolicy := service.NewPasswordPolicy(cfg.Auth.PasswordMinLength, cfg.Auth.PasswordMaxLength, cfg.Auth.PasswordMinClasses, breached)
+	denylist := service.NewAccessTokenDenylist(repo.NewRevokedAccessTokensPGX(pool))
+	if err := denylist.Load(context.Background()); err != nil {
+		log.Fatalf("access token denylist: %v", err)
+	}
+	go denylist.Run(context.Background())
+	shares := service.NewShareService(sharesRepo, storage, folders, passwords)
+	apiTokens := service.NewAPITokenService(repo.NewAPITokensPGX(pool), usersRepo)
+	var mailer service.Mailer
+	switch cfg.Mail.Backend {
+	case "smtp":
+		mailer = service.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword, cfg.Mail.From)
+	default:
+		logMailer, err := service.NewLogMailer(cfg.Mail.Dir, cfg.Mail.From)
+		if err != nil {
+			log.Fatalf("mail dir: %v", err)
+		}
+		mailer = logMailer
+	}
+	accounts := service.NewAccountService(usersRepo, repo.NewAccountTokensPGX(pool), refreshRepo, jwtKeys, passwords, passwordPolicy, denylist, mailer, cfg.Mail.LinkBaseURL)
+	mfa := service.NewMFAService(repo.NewMFAPGX(pool), usersRepo, cfg.Auth.TOTPIssuer)
+	guard, err := service.NewLoginGuard(repo.NewLoginFailuresPGX(pool), passwords, cfg.Auth.LockoutThreshold, time.Duration(cfg.Auth.LockoutDuration)*time.Second,
+		service.NewArgon2idHasher(argon), service.NewBcryptHasher(cfg.Auth.BcryptCost))
+	if err != nil {
+		log.Fatalf("login guard: %v", err)
+	}
+	var oidc *service.OIDCService
+	if cfg.OIDC.IssuerURL != "" {
+		provider := service.NewOIDCProvider(cfg.OIDC.IssuerURL, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, cfg.OIDC.RedirectURL, strings.Fields(cfg.OIDC.Scopes))
+		oidc = service.NewOIDCService(provider, repo.NewOIDCPGX(pool), usersRepo, passwords, cfg.OIDC.AutoProvision)
+		log.Printf("OIDC login enabled with %s", cfg.OIDC.IssuerURL)
+	}
+	var limitStore fiber.Storage
+	var rateLimits *repo.RateLimitStorePGX
+	if cfg.App.RateLimitStore == "postgres" {
+		rateLimits = repo.NewRateLimitStorePGX(pool)
+		limitStore = rateLimits
+	}
+	v1.RegisterRoutes(app, cfg.App, limitStore, storage, folders, shares, quotas, apiTokens, mfa, guard, passwords, passwordPolicy, accounts, oidc, jwtKeys, denylist, usersRepo, refreshRepo, repo.NewSecurityEventsPGX(pool))
 
 	app.Get("/openapi.json", func(c *fiber.Ctx) error {
 		c.Type("json")
@@ -161,6 +300,80 @@ func main() {
 		}
 	}()
 
+	if rateLimits != nil {
+		go func() {
+			ticker := time.NewTicker(10 * time.Minute)
+			defer ticker.Stop()
+
+			for t := range ticker.C {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				swept, err := rateLimits.Sweep(ctx)
+				cancel()
+
+				if err != nil {
+					log.Printf("[ratelimit-sweep] ran at %s UTC, swept=%d, ERROR: %v",
+						t.UTC().Format(time.RFC3339), swept, err)
+					continue
+				}
+				log.Printf("[ratelimit-sweep] ran at %s UTC, swept=%d", t.UTC().Format(time.RFC3339), swept)
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		retention := time.Duration(cfg.Storage.TrashRetention) * time.Second
+
+		for t := range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			now := t.UTC()
+			purged, err := storage.PurgeTrash(ctx, now.Add(-retention))
+			cancel()
+
+			if err != nil {
+				log.Printf("[trash-purge] ran at %s UTC, retention=%s, purged=%d, ERROR: %v",
+					now.Format(time.RFC3339), retention, purged, err)
+				continue
+			}
+			log.Printf("[trash-purge] ran at %s UTC, retention=%s, purged=%d",
+				now.Format(time.RFC3339), retention, purged)
+		}
+	}()
+
+	resumable, hasResumable := storage.(service.ResumableUploads)
+	presigner, hasPresigner := storage.(service.Presigner)
+	if hasResumable || hasPresigner {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+
+			for t := range ticker.C {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+				now := t.UTC()
+
+				var sessions, presigned int
+				var err error
+				if hasResumable {
+					sessions, err = resumable.PurgeExpiredUploads(ctx, now)
+				}
+				if err == nil && hasPresigner {
+					presigned, err = presigner.PurgeExpiredPresignedUploads(ctx, now)
+				}
+				cancel()
+
+				if err != nil {
+					log.Printf("[upload-purge] ran at %s UTC, sessions=%d, presigned=%d, ERROR: %v",
+						now.Format(time.RFC3339), sessions, presigned, err)
+					continue
+				}
+				log.Printf("[upload-purge] ran at %s UTC, sessions=%d, presigned=%d",
+					now.Format(time.RFC3339), sessions, presigned)
+			}
+		}()
+	}
+
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	log.Fatal(app.Listen(addr))
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+// setRole is the "set-role <username> <role>" command. It is how the first
+// admin gets appointed, since only admins can change roles through the API.
+func setRole(users repo.Users, args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: server set-role <username> <admin|member|read-only>")
+	}
+	username, role := args[0], args[1]
+	if !models.ValidRole(role) {
+		log.Fatalf("set-role: unknown role %q", role)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	u, err := users.ByUsername(ctx, username)
+	if err != nil {
+		log.Fatalf("set-role: %v", err)
+	}
+	if u == nil {
+		log.Fatalf("set-role: no user named %q", username)
+	}
+	u.Role = role
+	if err := users.Update(ctx, u); err != nil {
+		log.Fatalf("set-role: %v", err)
+	}
+	log.Printf("set-role: %s is now %s", username, role)
+}
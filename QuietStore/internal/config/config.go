@@ -5,9 +5,13 @@ import (
 )
 
 type Config struct {
-	Server  ServerConfig
-	App     AppConfig
-	Storage StorageConfig
+	Server     ServerConfig
+	App        AppConfig
+	Storage    StorageConfig
+	Encryption EncryptionConfig
+	Auth       AuthConfig
+	Mail       MailConfig
+	OIDC       OIDCConfig
 }
 
 type ServerConfig struct {
@@ -16,20 +20,123 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `env:"SERVER_READ_TIMEOUT" default:"10000"`
 	WriteTimeout time.Duration `env:"SERVER_WRITE_TIMEOUT" default:"10000"`
 	BodyLimit    int           `env:"SERVER_BODY_LIMIT" default:"41943040"`
+	// ProxyHeader is where a reverse proxy puts the client IP. It is only
+	// believed on requests from TrustedProxies, a comma separated list of IPs
+	// and CIDR ranges, so clients can't spoof it.
+	ProxyHeader    string `env:"SERVER_PROXY_HEADER" default:"X-Forwarded-For"`
+	TrustedProxies string `env:"SERVER_TRUSTED_PROXIES" default:"127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"`
 }
 
 type AppConfig struct {
-	Environment         string `env:"APP_ENVIRONMENT" default:"development"`
-	LogLevel            string `env:"APP_LOG_LEVEL" default:"info"`
-	MaxFileSize         int64
+	Environment         string        `env:"APP_ENVIRONMENT" default:"development"`
+	LogLevel            string        `env:"APP_LOG_LEVEL" default:"info"`
+	MaxFileSize         int64         `env:"APP_MAX_FILE_SIZE" default:"5368709120"`
+	DefaultQuota        int64         `env:"APP_DEFAULT_QUOTA" default:"10737418240"`
 	RateLimitAuthMax    int           `env:"RATE_LIMIT_AUTH_MAX" default:"5"`
 	RateLimitAuthExpire time.Duration `env:"RATE_LIMIT_AUTH_EXPIRATION" default:"60"`
 	RateLimitUserMax    int           `env:"RATE_LIMIT_USER_MAX" default:"3"`
 	RateLimitUserExpire time.Duration `env:"RATE_LIMIT_USER_EXPIRATION" default:"60"`
 	RateLimitFileMax    int           `env:"RATE_LIMIT_FILE_MAX" default:"15"`
 	RateLimitFileExpire time.Duration `env:"RATE_LIMIT_FILE_EXPIRATION" default:"60"`
+	// RateLimitStore is where limiter counters live: "postgres" shares them
+	// between replicas at the cost of a read and a write per limited
+	// request, "memory" keeps them per process.
+	RateLimitStore string `env:"RATE_LIMIT_STORE" default:"postgres"`
 }
 
 type StorageConfig struct {
+	Backend  string `env:"STORAGE_BACKEND" default:"minio"`
 	BasePath string `env:"STORAGE_BASE_PATH" default:"./data"`
+	Fsync    string `env:"STORAGE_FSYNC" default:"full"`
+
+	UploadSessionTTL time.Duration `env:"STORAGE_UPLOAD_TTL" default:"86400"`
+	PresignTTL       time.Duration `env:"STORAGE_PRESIGN_TTL" default:"900"`
+	TrashRetention   time.Duration `env:"STORAGE_TRASH_RETENTION" default:"2592000"`
+	MaxVersions      int           `env:"STORAGE_MAX_VERSIONS" default:"10"`
+	// Dedup stores identical uploads once and reference counts them.
+	Dedup bool `env:"STORAGE_DEDUP" default:"false"`
+}
+
+// EncryptionConfig turns on encryption at rest for the MinIO backend, the
+// local backend refuses to start with a key set. The master key is 32 bytes,
+// base64 encoded, given directly or in a file. To rotate it, make the new key
+// the master key, list the old one in ENCRYPTION_PREVIOUS_KEYS and run
+// "server rotate-master-key".
+type EncryptionConfig struct {
+	MasterKey     string `env:"ENCRYPTION_MASTER_KEY"`
+	MasterKeyFile string `env:"ENCRYPTION_MASTER_KEY_FILE"`
+	// PreviousKeys is a comma separated list of retired master keys that may
+	// still wrap stored data keys.
+	PreviousKeys string `env:"ENCRYPTION_PREVIOUS_KEYS"`
+}
+
+// AuthConfig controls how access tokens are signed. KeysDir holds one PEM file
+// per key, named <kid>.pem: an RSA (RS256) or Ed25519 (EdDSA) private key, or
+// just the public key of a retired one. Tokens are signed with ActiveKID and
+// verified with any key in the directory. To rotate, add the new key and
+// deploy, switch ActiveKID to it, then drop the old key once the tokens it
+// signed have expired. Without KeysDir a throwaway key is generated at startup,
+// which is only allowed outside production.
+type AuthConfig struct {
+	Issuer    string `env:"AUTH_ISSUER" default:"quietstore"`
+	Audience  string `env:"AUTH_AUDIENCE" default:"quietstore-api"`
+	KeysDir   string `env:"AUTH_JWT_KEYS_DIR"`
+	ActiveKID string `env:"AUTH_JWT_ACTIVE_KID"`
+	// TOTPIssuer labels accounts in authenticator apps.
+	TOTPIssuer string `env:"AUTH_TOTP_ISSUER" default:"QuietStore"`
+	// LockoutThreshold failed logins in a row lock a username for
+	// LockoutDuration seconds. Failures before that are slowed down.
+	LockoutThreshold int           `env:"AUTH_LOCKOUT_THRESHOLD" default:"10"`
+	LockoutDuration  time.Duration `env:"AUTH_LOCKOUT_DURATION" default:"900"`
+	// PasswordAlgorithm hashes new passwords, "argon2id" or "bcrypt". Hashes
+	// made with the other algorithm or older parameters keep working and are
+	// replaced when their user next logs in. Argon2Memory is in KiB, the
+	// defaults are the second recommended option of RFC 9106.
+	PasswordAlgorithm string `env:"AUTH_PASSWORD_ALGORITHM" default:"argon2id"`
+	Argon2Memory      int    `env:"AUTH_ARGON2_MEMORY" default:"65536"`
+	Argon2Time        int    `env:"AUTH_ARGON2_TIME" default:"3"`
+	Argon2Threads     int    `env:"AUTH_ARGON2_THREADS" default:"4"`
+	BcryptCost        int    `env:"AUTH_BCRYPT_COST" default:"10"`
+	// New passwords need PasswordMinLength characters, at most
+	// PasswordMaxLength bytes and PasswordMinClasses of lower case, upper
+	// case, digits and symbols. bcrypt ignores anything past 72 bytes, so
+	// the maximum may only go higher with argon2id.
+	PasswordMinLength  int `env:"AUTH_PASSWORD_MIN_LENGTH" default:"10"`
+	PasswordMaxLength  int `env:"AUTH_PASSWORD_MAX_LENGTH" default:"72"`
+	PasswordMinClasses int `env:"AUTH_PASSWORD_MIN_CLASSES" default:"1"`
+	// BreachedPasswordsPath rejects passwords from Have I Been Pwned style
+	// lists: a file of SHA-1 hashes, or a directory of k-anonymity range
+	// files named by hash prefix. Empty skips the check.
+	BreachedPasswordsPath string `env:"AUTH_BREACHED_PASSWORDS_PATH"`
+}
+
+// MailConfig selects how account emails go out. The "log" backend writes them
+// to Dir, or to the log without one, for local testing.
+type MailConfig struct {
+	Backend string `env:"MAIL_BACKEND" default:"log"`
+	From    string `env:"MAIL_FROM" default:"QuietStore <no-reply@localhost>"`
+	Dir     string `env:"MAIL_DIR"`
+	// LinkBaseURL is where the web app serving the links in emails lives.
+	LinkBaseURL string `env:"MAIL_LINK_BASE_URL" default:"http://localhost:8080"`
+
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     int    `env:"SMTP_PORT" default:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+}
+
+// OIDCConfig adds login through an OpenID Connect provider, which is off while
+// IssuerURL is empty. RedirectURL must point at /api/v1/auth/oidc/callback and
+// be registered with the provider. Without a client secret the client is a
+// public one and relies on PKCE alone.
+type OIDCConfig struct {
+	IssuerURL    string `env:"OIDC_ISSUER_URL"`
+	ClientID     string `env:"OIDC_CLIENT_ID"`
+	ClientSecret string `env:"OIDC_CLIENT_SECRET"`
+	RedirectURL  string `env:"OIDC_REDIRECT_URL" default:"http://localhost:8080/api/v1/auth/oidc/callback"`
+	// Scopes is a space separated list and must include openid.
+	Scopes string `env:"OIDC_SCOPES" default:"openid email profile"`
+	// AutoProvision creates accounts for identities whose verified email
+	// matches no user. Without it only existing users can log in.
+	AutoProvision bool `env:"OIDC_AUTO_PROVISION" default:"true"`
 }
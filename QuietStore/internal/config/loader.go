@@ -17,6 +17,21 @@ func Load() (*Config, error) {
 	if err := loadStruct(&cfg.App, ""); err != nil {
 		return nil, fmt.Errorf("loading app config: %w", err)
 	}
+	if err := loadStruct(&cfg.Storage, ""); err != nil {
+		return nil, fmt.Errorf("loading storage config: %w", err)
+	}
+	if err := loadStruct(&cfg.Encryption, ""); err != nil {
+		return nil, fmt.Errorf("loading encryption config: %w", err)
+	}
+	if err := loadStruct(&cfg.Auth, ""); err != nil {
+		return nil, fmt.Errorf("loading auth config: %w", err)
+	}
+	if err := loadStruct(&cfg.Mail, ""); err != nil {
+		return nil, fmt.Errorf("loading mail config: %w", err)
+	}
+	if err := loadStruct(&cfg.OIDC, ""); err != nil {
+		return nil, fmt.Errorf("loading oidc config: %w", err)
+	}
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
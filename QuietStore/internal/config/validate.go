@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -18,6 +19,100 @@ func (config *Config) Validate() error {
 		errs = append(errs, fmt.Sprintf("invalid environment: %s", config.App.Environment))
 	}
 
+	if config.App.MaxFileSize < 0 || config.App.DefaultQuota < 0 {
+		errs = append(errs, "max file size and default quota must be zero (unlimited) or more")
+	}
+
+	validLimitStores := []string{"postgres", "memory"}
+	if !contains(validLimitStores, config.App.RateLimitStore) {
+		errs = append(errs, fmt.Sprintf("invalid rate limit store: %s", config.App.RateLimitStore))
+	}
+
+	validBackends := []string{"minio", "local"}
+	if !contains(validBackends, config.Storage.Backend) {
+		errs = append(errs, fmt.Sprintf("invalid storage backend: %s", config.Storage.Backend))
+	}
+	if config.Storage.Backend == "local" && config.Storage.BasePath == "" {
+		errs = append(errs, "storage base path is required for the local backend")
+	}
+
+	validFsync := []string{"none", "file", "full"}
+	if !contains(validFsync, config.Storage.Fsync) {
+		errs = append(errs, fmt.Sprintf("invalid storage fsync mode: %s", config.Storage.Fsync))
+	}
+
+	if config.Encryption.MasterKey != "" && config.Encryption.MasterKeyFile != "" {
+		errs = append(errs, "set only one of the encryption master key and master key file")
+	}
+	if config.Encryption.PreviousKeys != "" && config.Encryption.MasterKey == "" && config.Encryption.MasterKeyFile == "" {
+		errs = append(errs, "previous encryption keys need a master key")
+	}
+	// the local backend can't encrypt, and storing plaintext while a key is set
+	// would leave operators believing files are protected
+	if config.Storage.Backend == "local" && (config.Encryption.MasterKey != "" || config.Encryption.MasterKeyFile != "") {
+		errs = append(errs, "encryption at rest is only supported by the minio storage backend")
+	}
+
+	if config.Auth.KeysDir == "" && config.App.Environment == "production" {
+		errs = append(errs, "a jwt keys directory is required in production")
+	}
+	if config.Auth.ActiveKID != "" && config.Auth.KeysDir == "" {
+		errs = append(errs, "the active jwt key id needs a jwt keys directory")
+	}
+	if config.Auth.LockoutThreshold < 1 || config.Auth.LockoutDuration < 1 {
+		errs = append(errs, "login lockout threshold and duration must be at least 1")
+	}
+	validHashes := []string{"argon2id", "bcrypt"}
+	if !contains(validHashes, config.Auth.PasswordAlgorithm) {
+		errs = append(errs, fmt.Sprintf("invalid password algorithm: %s", config.Auth.PasswordAlgorithm))
+	}
+	if config.Auth.Argon2Threads < 1 || config.Auth.Argon2Threads > 255 {
+		errs = append(errs, "argon2 threads must be between 1 and 255")
+	}
+	if config.Auth.Argon2Time < 1 || config.Auth.Argon2Memory < 8*config.Auth.Argon2Threads || config.Auth.Argon2Memory > 1<<22 {
+		errs = append(errs, "argon2 time must be at least 1 and memory between 8 KiB per thread and 4 GiB")
+	}
+	if config.Auth.BcryptCost < 4 || config.Auth.BcryptCost > 31 {
+		errs = append(errs, "bcrypt cost must be between 4 and 31")
+	}
+	if config.Auth.PasswordMinLength < 1 || config.Auth.PasswordMaxLength < config.Auth.PasswordMinLength {
+		errs = append(errs, "password min length must be at least 1 and max length at least the min length")
+	}
+	if config.Auth.PasswordAlgorithm == "bcrypt" && config.Auth.PasswordMaxLength > 72 {
+		errs = append(errs, "password max length can't be over 72 bytes with bcrypt")
+	}
+	if config.Auth.PasswordMinClasses < 1 || config.Auth.PasswordMinClasses > 4 {
+		errs = append(errs, "password min classes must be between 1 and 4")
+	}
+
+	validMailers := []string{"smtp", "log"}
+	if !contains(validMailers, config.Mail.Backend) {
+		errs = append(errs, fmt.Sprintf("invalid mail backend: %s", config.Mail.Backend))
+	}
+	if config.Mail.Backend == "smtp" && config.Mail.SMTPHost == "" {
+		errs = append(errs, "smtp host is required for the smtp mail backend")
+	}
+
+	if config.OIDC.IssuerURL != "" {
+		issuer, err := url.Parse(config.OIDC.IssuerURL)
+		switch {
+		case err != nil || issuer.Host == "" || (issuer.Scheme != "https" && issuer.Scheme != "http"):
+			errs = append(errs, "oidc issuer url must be an http(s) url")
+		case issuer.Scheme != "https" && config.App.Environment == "production":
+			// plain http is for local mock providers only
+			errs = append(errs, "oidc issuer url must use https in production")
+		}
+		if config.OIDC.ClientID == "" {
+			errs = append(errs, "oidc client id is required")
+		}
+		if config.OIDC.RedirectURL == "" {
+			errs = append(errs, "oidc redirect url is required")
+		}
+		if !contains(strings.Fields(config.OIDC.Scopes), "openid") {
+			errs = append(errs, "oidc scopes must include openid")
+		}
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, "; "))
 	}
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS upload_sessions (
+  id             TEXT PRIMARY KEY,
+  owner_user_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  file_id        TEXT NOT NULL,
+  object_key     TEXT NOT NULL,
+  s3_upload_id   TEXT NOT NULL,
+  original_name  TEXT NOT NULL,
+  content_type   TEXT,
+  upload_length  BIGINT NOT NULL,
+  upload_offset  BIGINT NOT NULL DEFAULT 0,
+  hash_state     BYTEA,
+  metadata       TEXT,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires
+  ON upload_sessions(expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_parts (
+  upload_id    TEXT NOT NULL REFERENCES upload_sessions(id) ON DELETE CASCADE,
+  part_number  INT NOT NULL,
+  etag         TEXT NOT NULL,
+  size_bytes   BIGINT NOT NULL,
+  PRIMARY KEY (upload_id, part_number)
+);
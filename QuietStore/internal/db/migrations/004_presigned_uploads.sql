@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS presigned_uploads (
+  id             TEXT PRIMARY KEY,
+  owner_user_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  object_key     TEXT NOT NULL,
+  original_name  TEXT NOT NULL,
+  content_type   TEXT,
+  size_bytes     BIGINT NOT NULL,
+  sha256         TEXT NOT NULL,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_presigned_uploads_expires
+  ON presigned_uploads(expires_at);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_files_deleted
+  ON files(deleted_at)
+  WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,23 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS current_version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS file_versions (
+  id            TEXT PRIMARY KEY,
+  file_id       TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+  version       INT NOT NULL,
+  object_key    TEXT NOT NULL,
+  size_bytes    BIGINT NOT NULL,
+  content_type  TEXT,
+  sha256        TEXT,
+  uploaded_by   TEXT NOT NULL,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (file_id, version)
+);
+
+-- files uploaded before versioning existed get their object as version 1
+INSERT INTO file_versions (id, file_id, version, object_key, size_bytes, content_type, sha256, uploaded_by, created_at)
+SELECT 'Version_' || gen_random_uuid()::text, f.id, 1, f.object_key, f.size_bytes, f.content_type, f.sha256, f.owner_user_id, f.created_at
+FROM files f
+WHERE NOT EXISTS (SELECT 1 FROM file_versions v WHERE v.file_id = f.id);
+
+-- per-user override of STORAGE_MAX_VERSIONS; NULL means use the default
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_versions INT;
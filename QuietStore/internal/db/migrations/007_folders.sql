@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS folders (
+  id             TEXT PRIMARY KEY,
+  owner_user_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  parent_id      TEXT REFERENCES folders(id) ON DELETE CASCADE,
+  name           TEXT NOT NULL,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_folders_owner_parent
+  ON folders(owner_user_id, parent_id);
+
+-- NULL means the file sits at the root. Files in a deleted folder fall back to the root.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS folder_id TEXT REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_files_owner_folder
+  ON files(owner_user_id, folder_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS shares (
+  id              TEXT PRIMARY KEY,
+  owner_user_id   TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash      TEXT NOT NULL UNIQUE,
+  file_id         TEXT REFERENCES files(id) ON DELETE CASCADE,
+  folder_id       TEXT REFERENCES folders(id) ON DELETE CASCADE,
+  password_hash   TEXT,
+  expires_at      TIMESTAMPTZ,
+  max_downloads   INT,
+  download_count  INT NOT NULL DEFAULT 0,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  revoked_at      TIMESTAMPTZ,
+  CHECK ((file_id IS NULL) <> (folder_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_shares_owner_created
+  ON shares(owner_user_id, created_at DESC);
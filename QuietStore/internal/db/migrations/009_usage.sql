@@ -0,0 +1,16 @@
+-- per-user override of APP_DEFAULT_QUOTA in bytes; NULL means use the default, 0 means unlimited
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
+
+-- running totals over every stored version, trashed files included until purged
+CREATE TABLE IF NOT EXISTS user_usage (
+  user_id     TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  used_bytes  BIGINT NOT NULL DEFAULT 0,
+  file_count  BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO user_usage (user_id, used_bytes, file_count)
+SELECT u.id,
+       COALESCE((SELECT SUM(v.size_bytes) FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.owner_user_id = u.id), 0),
+       (SELECT COUNT(*) FROM files f WHERE f.owner_user_id = u.id)
+FROM users u
+ON CONFLICT (user_id) DO NOTHING;
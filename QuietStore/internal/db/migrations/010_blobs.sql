@@ -0,0 +1,12 @@
+-- content-addressed objects shared by every file version with the same bytes (STORAGE_DEDUP)
+CREATE TABLE IF NOT EXISTS blobs (
+  sha256      TEXT PRIMARY KEY,
+  object_key  TEXT NOT NULL,
+  size_bytes  BIGINT NOT NULL,
+  ref_count   BIGINT NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- NULL for objects stored per file, i.e. uploaded without dedup or through tus/presigned URLs
+ALTER TABLE files ADD COLUMN IF NOT EXISTS blob_sha256 TEXT REFERENCES blobs(sha256) ON DELETE SET NULL;
+ALTER TABLE file_versions ADD COLUMN IF NOT EXISTS blob_sha256 TEXT REFERENCES blobs(sha256) ON DELETE SET NULL;
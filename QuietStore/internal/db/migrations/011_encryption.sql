@@ -0,0 +1,5 @@
+-- data keys for objects encrypted at rest, wrapped with the master key; NULL means plaintext
+ALTER TABLE files ADD COLUMN IF NOT EXISTS wrapped_key BYTEA;
+ALTER TABLE file_versions ADD COLUMN IF NOT EXISTS wrapped_key BYTEA;
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS wrapped_key BYTEA;
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS wrapped_key BYTEA;
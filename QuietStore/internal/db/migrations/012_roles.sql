@@ -0,0 +1,3 @@
+-- admin manages every account, member manages itself and its files, read-only can only read
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+  CHECK (role IN ('admin', 'member', 'read-only'));
@@ -0,0 +1,21 @@
+-- every refresh token belongs to the family started by a login. Rotation adds a
+-- child, and replaying a rotated token revokes the whole family
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS parent_id TEXT REFERENCES refresh_tokens(id) ON DELETE SET NULL;
+UPDATE refresh_tokens SET family_id = id WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_family ON refresh_tokens(family_id);
+
+-- audit trail of suspicious account activity
+CREATE TABLE IF NOT EXISTS security_events (
+  id          TEXT PRIMARY KEY,
+  user_id     TEXT REFERENCES users(id) ON DELETE CASCADE,
+  kind        TEXT NOT NULL,
+  detail      TEXT NOT NULL DEFAULT '',
+  ip          TEXT NOT NULL DEFAULT '',
+  user_agent  TEXT NOT NULL DEFAULT '',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at DESC);
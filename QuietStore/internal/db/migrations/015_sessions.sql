@@ -0,0 +1,19 @@
+-- a session is a refresh token family, i.e. one logged in device
+CREATE TABLE IF NOT EXISTS sessions (
+  id            TEXT PRIMARY KEY,
+  user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  user_agent    TEXT NOT NULL DEFAULT '',
+  ip            TEXT NOT NULL DEFAULT '',
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_used_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  revoked_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, last_used_at DESC);
+
+-- families from before sessions were tracked
+INSERT INTO sessions (id, user_id, created_at, last_used_at)
+SELECT family_id, MIN(user_id), MIN(issued_at), MAX(issued_at)
+FROM refresh_tokens
+GROUP BY family_id
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,31 @@
+-- TOTP second factor. enabled_at stays NULL until enrollment is confirmed with
+-- a code, and last_step stops a code from being used twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id     TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  secret      BYTEA NOT NULL,
+  enabled_at  TIMESTAMPTZ,
+  last_step   BIGINT NOT NULL DEFAULT 0,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- single-use recovery codes, hashed like API tokens
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id          TEXT PRIMARY KEY,
+  user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash   TEXT NOT NULL,
+  used_at     TIMESTAMPTZ,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, code_hash)
+);
+
+-- the second half of a login pending for a user with MFA on
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  id          TEXT PRIMARY KEY,
+  user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash  TEXT NOT NULL UNIQUE,
+  attempts    INT NOT NULL DEFAULT 0,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires ON mfa_challenges(expires_at);
@@ -0,0 +1,19 @@
+-- NULL until the user proves they receive mail at their address, and reset
+-- whenever the address changes
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- single-use tokens mailed to users for email verification and password
+-- resets. Only a hash of the token is kept.
+CREATE TABLE IF NOT EXISTS account_tokens (
+  id          TEXT PRIMARY KEY,
+  user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  purpose     TEXT NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+  token_hash  TEXT NOT NULL UNIQUE,
+  email       TEXT NOT NULL,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  used_at     TIMESTAMPTZ,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user ON account_tokens(user_id, purpose);
+CREATE INDEX IF NOT EXISTS idx_account_tokens_expires ON account_tokens(expires_at);
@@ -0,0 +1,27 @@
+-- accounts at external OpenID Connect providers, keyed by the provider's
+-- issuer and its stable subject identifier
+CREATE TABLE IF NOT EXISTS linked_identities (
+  id             TEXT PRIMARY KEY,
+  user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider       TEXT NOT NULL,
+  subject        TEXT NOT NULL,
+  email          TEXT NOT NULL DEFAULT '',
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_login_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_linked_identities_user ON linked_identities(user_id);
+
+-- OIDC logins between the redirect to the provider and its callback. The
+-- state is stored hashed, the PKCE verifier and nonce never leave the server.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+  id             TEXT PRIMARY KEY,
+  state_hash     TEXT NOT NULL UNIQUE,
+  nonce          TEXT NOT NULL,
+  code_verifier  TEXT NOT NULL,
+  expires_at     TIMESTAMPTZ NOT NULL,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_logins_expires ON oidc_logins(expires_at);
@@ -0,0 +1,11 @@
+-- failed password logins per username, whether or not the user exists, so
+-- lockouts say nothing about which accounts there are. A row goes away on
+-- a successful login or an admin unlock.
+CREATE TABLE IF NOT EXISTS login_failures (
+  username        TEXT PRIMARY KEY,
+  failures        INT NOT NULL DEFAULT 0,
+  last_failed_at  TIMESTAMPTZ NOT NULL,
+  locked_until    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last ON login_failures(last_failed_at);
@@ -0,0 +1,9 @@
+-- rate limiter counters shared by every replica. UNLOGGED skips the WAL, so
+-- the table is fast but emptied after a crash, which only resets the limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+  key         TEXT PRIMARY KEY,
+  hits        INTEGER NOT NULL,
+  expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires ON rate_limits(expires_at);
@@ -0,0 +1,13 @@
+-- an access token's jti is the id of the refresh token minted with it, so
+-- revoking a session can find its access tokens that are still live
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS access_expires_at TIMESTAMPTZ;
+
+-- denylisted access tokens, kept until they would have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+  jti         TEXT PRIMARY KEY,
+  user_id     TEXT NOT NULL,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  revoked_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_access_tokens_expires ON revoked_access_tokens(expires_at);
@@ -0,0 +1,12 @@
+-- when the current contents of a file last changed, by a new version or a
+-- promoted one. Existing files take the time of their current version.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+
+UPDATE files f
+   SET updated_at = COALESCE(
+         (SELECT v.created_at FROM file_versions v WHERE v.file_id = f.id AND v.version = f.current_version),
+         f.created_at)
+ WHERE f.updated_at IS NULL;
+
+ALTER TABLE files ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE files ALTER COLUMN updated_at SET NOT NULL;
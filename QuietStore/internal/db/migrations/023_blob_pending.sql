@@ -0,0 +1,4 @@
+-- a blob row is inserted pending and committed before its object is copied
+-- into place, so the copy holds no row lock. It stops being pending once the
+-- object is there.
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS pending BOOLEAN NOT NULL DEFAULT FALSE;
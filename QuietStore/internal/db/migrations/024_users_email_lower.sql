@@ -0,0 +1,8 @@
+-- email addresses are unique regardless of case, and are stored lowercased.
+-- Addresses that only differ by case from another account's are left as they
+-- are, and the index below fails until an admin resolves them by hand.
+UPDATE users SET email = lower(email)
+ WHERE email <> lower(email)
+   AND NOT EXISTS (SELECT 1 FROM users o WHERE o.id <> users.id AND lower(o.email) = lower(users.email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
@@ -0,0 +1,6 @@
+-- limiter entries are the encoded state fiber's limiter keeps per key, in
+-- place of a bare hit count. Counters are disposable, so rows from before
+-- simply start over.
+ALTER TABLE rate_limits DROP COLUMN IF EXISTS hits;
+ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS value BYTEA NOT NULL DEFAULT '';
+ALTER TABLE rate_limits ALTER COLUMN expires_at DROP NOT NULL;
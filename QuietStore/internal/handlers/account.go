@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AccountHandler struct {
+	accounts *service.AccountService
+}
+
+func NewAccountHandler(accounts *service.AccountService) *AccountHandler {
+	return &AccountHandler{accounts: accounts}
+}
+
+func accountError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidAccountToken):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrNoEmail), errors.Is(err, service.ErrEmailAlreadyVerified):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrUserNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "account request failed: "+err.Error())
+	}
+}
+
+// RequestEmailVerificationHandler godoc
+//
+//	@Summary		Send an email verification link
+//	@Description	Mails a single-use link to the account's email address
+//	@Tags			account
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		202	{object}	map[string]string
+//	@Failure		401,403,409,500	{object}	map[string]string
+//	@Router			/me/email/verification [post]
+func (h *AccountHandler) RequestEmailVerificationHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.accounts.RequestEmailVerification(c.Context(), userID); err != nil {
+		return accountError(err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "verification email sent"})
+}
+
+// VerifyEmailHandler godoc
+//
+//	@Summary		Verify an email address
+//	@Description	Redeems the token from a verification email
+//	@Tags			account
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.VerifyEmailRequest	true	"token"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400,500	{object}	map[string]string
+//	@Router			/auth/email/verify [post]
+func (h *AccountHandler) VerifyEmailHandler(c *fiber.Ctx) error {
+	var req models.VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "token is required")
+	}
+
+	if err := h.accounts.VerifyEmail(c.Context(), req.Token); err != nil {
+		return accountError(err)
+	}
+	return c.JSON(fiber.Map{"message": "email verified"})
+}
+
+// ForgotPasswordHandler godoc
+//
+//	@Summary		Request a password reset
+//	@Description	Mails a reset link if an account has this address. The response is the same either way.
+//	@Tags			account
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.ForgotPasswordRequest	true	"email"
+//	@Success		202		{object}	map[string]string
+//	@Failure		400,500	{object}	map[string]string
+//	@Router			/auth/password/forgot [post]
+func (h *AccountHandler) ForgotPasswordHandler(c *fiber.Ctx) error {
+	var req models.ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "email is required")
+	}
+
+	if err := h.accounts.RequestPasswordReset(c.Context(), req.Email); err != nil {
+		return accountError(err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "if an account uses this address, a reset link is on its way"})
+}
+
+// ResetPasswordHandler godoc
+//
+//	@Summary		Reset a password
+//	@Description	Sets a new password with the token from a reset email and logs out every session
+//	@Tags			account
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.ResetPasswordRequest	true	"token and new password"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	models.ValidationErrorResponse	"password breaks the policy"
+//	@Failure		500		{object}	map[string]string
+//	@Router			/auth/password/reset [post]
+func (h *AccountHandler) ResetPasswordHandler(c *fiber.Ctx) error {
+	var req models.ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "token is required")
+	}
+
+	if err := h.accounts.ResetPassword(c.Context(), req.Token, req.Password); err != nil {
+		var perr *service.PasswordPolicyError
+		if errors.As(err, &perr) {
+			return passwordPolicyError(c, err)
+		}
+		return accountError(err)
+	}
+	return c.JSON(fiber.Map{"message": "password changed, please log in again"})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type APITokenHandler struct {
+	tokens *service.APITokenService
+}
+
+func NewAPITokenHandler(tokens *service.APITokenService) *APITokenHandler {
+	return &APITokenHandler{tokens: tokens}
+}
+
+func apiTokenError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrAPITokenNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "api token not found")
+	case errors.Is(err, service.ErrInvalidName):
+		return fiber.NewError(fiber.StatusBadRequest, "name is required")
+	case errors.Is(err, service.ErrInvalidScopes):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "api token failed: "+err.Error())
+	}
+}
+
+// CreateAPITokenHandler godoc
+//
+//	@Summary		Create an API token
+//	@Description	Creates a scoped personal access token for scripts and CI. Send it as "Authorization: Bearer <token>". The token is only returned here.
+//	@Tags			tokens
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.CreateAPITokenRequest	true	"token"
+//	@Success		201		{object}	models.CreateAPITokenResponse
+//	@Failure		400,401,403,500	{object}	map[string]string
+//	@Router			/me/tokens [post]
+func (h *APITokenHandler) CreateAPITokenHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.CreateAPITokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return fiber.NewError(fiber.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	t, token, err := h.tokens.CreateToken(c.Context(), userID, req)
+	if err != nil {
+		return apiTokenError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(models.CreateAPITokenResponse{APIToken: *t, Token: token})
+}
+
+// ListAPITokensHandler godoc
+//
+//	@Summary		List my API tokens
+//	@Tags			tokens
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		models.APIToken
+//	@Failure		401,403,500	{object}	map[string]string
+//	@Router			/me/tokens [get]
+func (h *APITokenHandler) ListAPITokensHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := h.tokens.ListTokens(c.Context(), userID)
+	if err != nil {
+		return apiTokenError(err)
+	}
+	if list == nil {
+		list = []*models.APIToken{}
+	}
+	return c.JSON(list)
+}
+
+// RevokeAPITokenHandler godoc
+//
+//	@Summary		Revoke an API token
+//	@Tags			tokens
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			tokenID	path		string	true	"Token ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401,403,404,500	{object}	map[string]string
+//	@Router			/me/tokens/{tokenID} [delete]
+func (h *APITokenHandler) RevokeAPITokenHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.tokens.RevokeToken(c.Context(), userID, c.Params("tokenID")); err != nil {
+		return apiTokenError(err)
+	}
+	return c.JSON(fiber.Map{"message": "api token revoked"})
+}
@@ -3,54 +3,69 @@ package handlers
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
-	"os"
+	"errors"
+	"log"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
 	users      repo.Users
 	refresh    repo.RefreshTokens
-	jwtSecret  []byte
+	events     repo.SecurityEvents
+	mfa        *service.MFAService
+	guard      *service.LoginGuard
+	passwords  service.PasswordHasher
+	keys       *service.JWTKeys
+	denylist   *service.AccessTokenDenylist
 	accessTTL  time.Duration
 	refreshTTL time.Duration
-	issuer     string
-	audience   string
 }
 
-func NewAuthHandler(users repo.Users, refresh repo.RefreshTokens, secret string, accessTTL, refreshTTL time.Duration) *AuthHandler {
-	iss := os.Getenv("AUTH_ISSUER")
-	if iss == "" {
-		iss = "quietstore"
-	}
-	aud := os.Getenv("AUTH_AUDIENCE")
-	if aud == "" {
-		aud = "quietstore-api"
-	}
-
+func NewAuthHandler(users repo.Users, refresh repo.RefreshTokens, events repo.SecurityEvents, mfa *service.MFAService, guard *service.LoginGuard, passwords service.PasswordHasher, keys *service.JWTKeys, denylist *service.AccessTokenDenylist, accessTTL, refreshTTL time.Duration) *AuthHandler {
 	return &AuthHandler{
 		users:      users,
 		refresh:    refresh,
-		jwtSecret:  []byte(secret),
+		events:     events,
+		mfa:        mfa,
+		guard:      guard,
+		passwords:  passwords,
+		keys:       keys,
+		denylist:   denylist,
 		accessTTL:  accessTTL,
 		refreshTTL: refreshTTL,
-		issuer:     iss,
-		audience:   aud,
+	}
+}
+
+// JWKSHandler godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Public keys that verify access tokens, looked up by the token's kid
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	models.JWKSet
+//	@Router			/.well-known/jwks.json [get]
+func JWKSHandler(keys *service.JWTKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// short enough that verifiers pick up a newly added key before it signs
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(keys.JWKS())
 	}
 }
 
 // LoginHandler godoc
 //
 //	@Summary		Login
-//	@Description	Exchange username/password for access & refresh tokens
+//	@Description	Exchange username/password for access & refresh tokens. Users with two-factor authentication get an mfa_token instead, to redeem at /auth/login/mfa.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
@@ -58,6 +73,7 @@ func NewAuthHandler(users repo.Users, refresh repo.RefreshTokens, secret string,
 //	@Success		200		{object}	models.TokenPairResponse
 //	@Failure		400		{object}	map[string]string
 //	@Failure		401		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string	"too many failed logins, see Retry-After"
 //	@Router			/auth/login [post]
 func (h *AuthHandler) LoginHandler(c *fiber.Ctx) error {
 	var in models.LoginRequest
@@ -65,36 +81,143 @@ func (h *AuthHandler) LoginHandler(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "invalid credentials payload")
 	}
 
+	wait, err := h.guard.Wait(c.Context(), in.Username)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to check login attempts")
+	}
+	if wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many failed logins, try again later")
+	}
+
 	u, err := h.users.ByUsername(c.Context(), in.Username)
-	if err != nil || u == nil {
-		return fiber.NewError(fiber.StatusUnauthorized, "invalid credentials")
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to look up user")
+	}
+	// unknown usernames go through the same steps, so they can't be told apart
+	ok, rehash, err := h.guard.CheckPassword(u, in.Password)
+	if err != nil {
+		log.Printf("[auth] password hash of %s unreadable: %v", u.ID, err)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(in.Password)); err != nil {
+	if !ok {
+		locked, err := h.guard.Fail(c.Context(), in.Username)
+		if err != nil {
+			log.Printf("[auth] failed login for %q not recorded: %v", in.Username, err)
+		}
+		if locked && u != nil {
+			h.recordEvent(c, u.ID, models.SecurityEventAccountLocked, "locked after too many failed logins")
+		}
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid credentials")
 	}
+	if err := h.guard.Succeed(c.Context(), in.Username); err != nil {
+		log.Printf("[auth] failed logins for %q not cleared: %v", in.Username, err)
+	}
+	if rehash {
+		h.upgradePassword(c, u, in.Password)
+	}
+
+	return h.completeLogin(c, u)
+}
+
+// upgradePassword rehashes the password of a user whose stored hash uses an
+// older algorithm or parameters. The login goes ahead if it fails, and the
+// next one tries again.
+func (h *AuthHandler) upgradePassword(c *fiber.Ctx, u *models.User, password string) {
+	hash, err := h.passwords.Hash(password)
+	if err == nil {
+		err = h.users.SetPassword(c.Context(), u.ID, hash)
+	}
+	if err != nil {
+		log.Printf("[auth] password hash of %s not upgraded: %v", u.ID, err)
+	}
+}
+
+// completeLogin follows a successful first factor: users with two-factor
+// authentication get a challenge, everyone else a session.
+func (h *AuthHandler) completeLogin(c *fiber.Ctx, u *models.User) error {
+	enabled, err := h.mfa.Enabled(c.Context(), u.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to check two-factor status")
+	}
+	if enabled {
+		token, ttl, err := h.mfa.StartChallenge(c.Context(), u.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to start two-factor challenge")
+		}
+		return c.JSON(models.MFAChallengeResponse{MFARequired: true, MFAToken: token, ExpiresIn: int(ttl.Seconds())})
+	}
+
+	return h.startSession(c, u)
+}
+
+// MFALoginHandler godoc
+//
+//	@Summary		Login, second step
+//	@Description	Completes a login that returned mfa_required, with a TOTP code or a recovery code
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.MFALoginRequest	true	"challenge and code"
+//	@Success		200		{object}	models.TokenPairResponse
+//	@Failure		400,401,500	{object}	map[string]string
+//	@Router			/auth/login/mfa [post]
+func (h *AuthHandler) MFALoginHandler(c *fiber.Ctx) error {
+	var in models.MFALoginRequest
+	if err := c.BodyParser(&in); err != nil || in.MFAToken == "" || in.Code == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid mfa payload")
+	}
+
+	userID, err := h.mfa.CompleteChallenge(c.Context(), in.MFAToken, in.Code)
+	switch {
+	case errors.Is(err, service.ErrInvalidMFAChallenge), errors.Is(err, service.ErrMFANotEnabled):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired mfa token")
+	case errors.Is(err, service.ErrInvalidMFACode):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid two-factor code")
+	case err != nil:
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to verify two-factor code")
+	}
+
+	u, err := h.users.ByID(c.Context(), userID)
+	if err != nil || u == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired mfa token")
+	}
+	return h.startSession(c, u)
+}
 
-	// Access JWT
+// startSession finishes a login: it starts a new session, i.e. a new refresh
+// token family, and responds with the token pair.
+func (h *AuthHandler) startSession(c *fiber.Ctx, u *models.User) error {
 	now := time.Now().UTC()
+	refreshRaw, rt := h.newRefreshToken(u.ID, nil, now)
+	session := &models.Session{
+		ID:         rt.FamilyID,
+		UserID:     u.ID,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	// Access JWT, its jti names the refresh token so revoking the session finds it
 	accessExp := now.Add(h.accessTTL)
+	rt.AccessExpiresAt = &accessExp
 	claims := jwt.MapClaims{
 		"sub":      u.ID,
 		"user_id":  u.ID,
 		"username": u.Username,
-		"iss":      h.issuer,
-		"aud":      h.audience,
+		"role":     u.Role,
+		"sid":      session.ID,
+		"jti":      rt.ID,
 		"iat":      now.Unix(),
 		"nbf":      now.Unix(),
 		"exp":      accessExp.Unix(),
 	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessStr, err := tok.SignedString(h.jwtSecret)
+	accessStr, err := h.keys.Sign(claims)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "failed to sign token")
 	}
 
-	refreshRaw := uuid.NewString()
-	refreshHash := sha256.Sum256([]byte(refreshRaw))
-	if err := h.refresh.Insert(c.Context(), u.ID, hex.EncodeToString(refreshHash[:]), now.Add(h.refreshTTL)); err != nil {
+	if err := h.refresh.StartSession(c.Context(), session, rt); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "failed to persist refresh token")
 	}
 
@@ -127,33 +250,57 @@ func (h *AuthHandler) RefreshHandler(c *fiber.Ctx) error {
 
 	now := time.Now().UTC()
 	hash := sha256.Sum256([]byte(in.RefreshToken))
-	valid, err := h.refresh.FindValid(c.Context(), in.UserID, hex.EncodeToString(hash[:]), now)
-	if err != nil || !valid {
+	rt, err := h.refresh.ByHash(c.Context(), in.UserID, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to look up refresh token")
+	}
+	if rt == nil || !rt.ExpiresAt.After(now) {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired refresh token")
+	}
+	if rt.RevokedAt != nil {
+		// tokens of a session that was logged out or revoked are just dead
+		session, err := h.refresh.SessionByID(c.Context(), rt.FamilyID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to look up session")
+		}
+		if session != nil && session.RevokedAt != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired refresh token")
+		}
+		return h.refreshReused(c, rt)
+	}
+
+	// reload the user so role changes apply from the next refresh on
+	u, err := h.users.ByID(c.Context(), in.UserID)
+	if err != nil || u == nil {
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired refresh token")
 	}
-	_ = h.refresh.Revoke(c.Context(), in.UserID, hex.EncodeToString(hash[:]))
 
+	newRefresh, next := h.newRefreshToken(in.UserID, rt, now)
 	accessExp := now.Add(h.accessTTL)
+	next.AccessExpiresAt = &accessExp
 	claims := jwt.MapClaims{
 		"sub":     in.UserID,
 		"user_id": in.UserID,
-		"iss":     h.issuer,
-		"aud":     h.audience,
+		"role":    u.Role,
+		"sid":     rt.FamilyID,
+		"jti":     next.ID,
 		"iat":     now.Unix(),
 		"nbf":     now.Unix(),
 		"exp":     accessExp.Unix(),
 	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessStr, err := tok.SignedString(h.jwtSecret)
+	accessStr, err := h.keys.Sign(claims)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "failed to sign token")
 	}
 
-	newRefresh := uuid.NewString()
-	newHash := sha256.Sum256([]byte(newRefresh))
-	if err := h.refresh.Insert(c.Context(), in.UserID, hex.EncodeToString(newHash[:]), now.Add(h.refreshTTL)); err != nil {
+	rotated, err := h.refresh.Rotate(c.Context(), rt.ID, next, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "failed to persist rotated refresh token")
 	}
+	if !rotated {
+		// another request got in first with the same token
+		return h.refreshReused(c, rt)
+	}
 
 	return c.JSON(fiber.Map{
 		"access_token":  accessStr,
@@ -163,7 +310,60 @@ func (h *AuthHandler) RefreshHandler(c *fiber.Ctx) error {
 	})
 }
 
-func RequireAuth(secret string) fiber.Handler {
+// newRefreshToken mints a refresh token and its row. Rotated tokens join
+// parent's family, others start their own.
+func (h *AuthHandler) newRefreshToken(userID string, parent *models.RefreshToken, now time.Time) (string, *models.RefreshToken) {
+	raw := uuid.NewString()
+	hash := sha256.Sum256([]byte(raw))
+	t := &models.RefreshToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		TokenHash: hex.EncodeToString(hash[:]),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(h.refreshTTL),
+	}
+	t.FamilyID = t.ID
+	if parent != nil {
+		t.FamilyID, t.ParentID = parent.FamilyID, &parent.ID
+	}
+	return raw, t
+}
+
+// refreshReused handles a refresh token that was already rotated or revoked.
+// Whoever presents it may hold a stolen copy, so per the OAuth 2.0 security
+// BCP the whole family is revoked and both parties have to log in again.
+func (h *AuthHandler) refreshReused(c *fiber.Ctx, rt *models.RefreshToken) error {
+	if _, err := h.refresh.RevokeSession(c.Context(), rt.UserID, rt.FamilyID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke refresh token family")
+	}
+	if err := h.denylist.RevokeSession(c.Context(), rt.UserID, rt.FamilyID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke access tokens")
+	}
+	h.recordEvent(c, rt.UserID, models.SecurityEventRefreshReuse, "session "+rt.FamilyID+" revoked")
+	return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired refresh token")
+}
+
+// recordEvent logs rather than fails when the event can't be stored, since
+// the response doesn't depend on it.
+func (h *AuthHandler) recordEvent(c *fiber.Ctx, userID, kind, detail string) {
+	err := h.events.Record(c.Context(), &models.SecurityEvent{
+		ID:        models.GenerateSecurityEventID(),
+		UserID:    userID,
+		Kind:      kind,
+		Detail:    detail,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("[auth] %s event for %s not recorded: %v", kind, userID, err)
+	}
+}
+
+// RequireAuth accepts either an access JWT that hasn't been revoked or a
+// personal access token. For API tokens it also stores their scopes, which
+// RequireScope then checks.
+func RequireAuth(keys *service.JWTKeys, apiTokens *service.APITokenService, denylist *service.AccessTokenDenylist) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		auth := c.Get("Authorization")
 		if !strings.HasPrefix(auth, "Bearer ") {
@@ -171,24 +371,42 @@ func RequireAuth(secret string) fiber.Handler {
 		}
 		tokenStr := strings.TrimPrefix(auth, "Bearer ")
 
-		tok, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		if strings.HasPrefix(tokenStr, service.APITokenPrefix) {
+			t, u, err := apiTokens.Authenticate(c.Context(), tokenStr)
+			if errors.Is(err, service.ErrInvalidAPIToken) {
+				return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
 			}
-			return []byte(secret), nil
-		})
-		if err != nil || !tok.Valid {
-			return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "token lookup failed")
+			}
+			c.Locals("userID", u.ID)
+			c.Locals("role", u.Role)
+			c.Locals("scopes", t.Scopes)
+			return c.Next()
 		}
-		claims, ok := tok.Claims.(jwt.MapClaims)
-		if !ok {
-			return fiber.NewError(fiber.StatusUnauthorized, "invalid claims")
+
+		claims, err := keys.Verify(tokenStr)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
 		}
 		userID, _ := claims["user_id"].(string)
 		if userID == "" {
 			return fiber.NewError(fiber.StatusUnauthorized, "missing user id")
 		}
+		// tokens minted before jtis existed can't be revoked and just expire
+		if jti, _ := claims["jti"].(string); jti != "" && denylist.Revoked(jti) {
+			return fiber.NewError(fiber.StatusUnauthorized, "token revoked")
+		}
+		role, _ := claims["role"].(string)
+		if !models.ValidRole(role) {
+			// tokens minted before roles existed
+			role = models.RoleMember
+		}
 		c.Locals("userID", userID)
+		c.Locals("role", role)
+		if sid, _ := claims["sid"].(string); sid != "" {
+			c.Locals("sessionID", sid)
+		}
 		return c.Next()
 	}
 }
@@ -196,7 +414,7 @@ func RequireAuth(secret string) fiber.Handler {
 // LogoutHandler godoc
 //
 //	@Summary		Logout
-//	@Description	Revoke the provided refresh token for the authenticated user
+//	@Description	End the session the provided refresh token belongs to. Its access tokens stop working at once.
 //	@Tags			auth
 //	@Security		BearerAuth
 //	@Accept			json
@@ -217,10 +435,46 @@ func (h *AuthHandler) LogoutHandler(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "invalid logout payload")
 	}
 
-	hash := sha256.Sum256([]byte(input.RefreshToken))
-	if err := h.refresh.Revoke(c.Context(), userID, hex.EncodeToString(hash[:])); err != nil {
+	sum := sha256.Sum256([]byte(input.RefreshToken))
+	hash := hex.EncodeToString(sum[:])
+	rt, err := h.refresh.ByHash(c.Context(), userID, hash)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to look up refresh token")
+	}
+	if err := h.refresh.Revoke(c.Context(), userID, hash); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke refresh token")
 	}
 
+	if rt != nil {
+		if err := h.denylist.RevokeSession(c.Context(), userID, rt.FamilyID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke access tokens")
+		}
+	}
+
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// UnlockUserHandler godoc
+//
+//	@Summary		Unlock a user's login
+//	@Description	Admin only. Clears failed logins and any lockout or backoff delay.
+//	@Tags			users
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			id	path		string	true	"User ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		401,403,404,500	{object}	map[string]string
+//	@Router			/users/{id}/lockout [delete]
+func (h *AuthHandler) UnlockUserHandler(c *fiber.Ctx) error {
+	u, err := h.users.ByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "lookup failed: "+err.Error())
+	}
+	if u == nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+	if err := h.guard.Unlock(c.Context(), u.Username); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to unlock user")
+	}
+	return c.JSON(fiber.Map{"message": "user unlocked"})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitBody enforces limit on every route except the given streaming routes.
+// Once StreamRequestBody is enabled fasthttp no longer rejects large bodies
+// itself, so routes that buffer (JSON, forms) need this check back. A
+// streaming route is a path whose segments must all match, "*" matching any
+// one segment, so "/files/upload" doesn't also exempt "/files/uploads".
+func LimitBody(limit int, streamingRoutes ...string) fiber.Handler {
+	routes := make([][]string, len(streamingRoutes))
+	for i, r := range streamingRoutes {
+		routes[i] = pathSegments(r)
+	}
+
+	return func(c *fiber.Ctx) error {
+		path := pathSegments(c.Path())
+		for _, r := range routes {
+			if segmentsMatch(r, path) {
+				return c.Next()
+			}
+		}
+
+		length := c.Request().Header.ContentLength()
+		if length > limit {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, "request body too large")
+		}
+		// a chunked body has no length up front, and fasthttp would read all of
+		// it into memory as soon as a handler asks for the body
+		if length < 0 {
+			if stream := c.Request().BodyStream(); stream != nil {
+				body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+				if err != nil {
+					return fiber.NewError(fiber.StatusBadRequest, "failed to read request body")
+				}
+				if len(body) > limit {
+					return fiber.NewError(fiber.StatusRequestEntityTooLarge, "request body too large")
+				}
+				c.Request().SetBody(body)
+			}
+		}
+		return c.Next()
+	}
+}
+
+func pathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func segmentsMatch(route, path []string) bool {
+	if len(route) != len(path) {
+		return false
+	}
+	for i, s := range route {
+		if s != "*" && s != path[i] {
+			return false
+		}
+	}
+	return true
+}
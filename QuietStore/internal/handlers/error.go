@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -16,3 +20,17 @@ func CustomErrorHandler(c *fiber.Ctx, err error) error {
 		"code":  code,
 	})
 }
+
+// passwordPolicyError answers a failed password policy check with a 400
+// listing every violation, so clients can show them all at once.
+func passwordPolicyError(c *fiber.Ctx, err error) error {
+	var perr *service.PasswordPolicyError
+	if !errors.As(err, &perr) {
+		return fiber.NewError(fiber.StatusInternalServerError, "password check failed: "+err.Error())
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(models.ValidationErrorResponse{
+		Error:  perr.Error(),
+		Code:   fiber.StatusBadRequest,
+		Errors: perr.Violations,
+	})
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
 	"strconv"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
 	"github.com/gofiber/fiber/v2"
 )
@@ -29,14 +36,17 @@ func resolveUserID(c *fiber.Ctx) (string, error) {
 // UploadFileHandler godoc
 //
 //	@Summary		Upload a file
-//	@Description	Uploads a file for the authenticated user
+//	@Description	Uploads a file for the authenticated user. With file_id, the upload becomes a new version of that file.
 //	@Tags			files
 //	@Security		BearerAuth
 //	@Accept			multipart/form-data
 //	@Param			file	formData	file	true	"file"
+//	@Param			file_id		query		string	false	"existing file to add a version to"
+//	@Param			folder_id	query		string	false	"folder to upload into, root if omitted"
 //	@Produce		json
 //	@Success		200			{object}	models.FileMeta
-//	@Failure		400,401,500	{object}	map[string]string
+//	@Failure		400,401,404,409,500	{object}	map[string]string
+//	@Failure		413,507				{object}	map[string]string
 //	@Router			/files [post]
 func (h *FileHandler) UploadFileHandler(c *fiber.Ctx) error {
 	userID, err := resolveUserID(c)
@@ -44,6 +54,14 @@ func (h *FileHandler) UploadFileHandler(c *fiber.Ctx) error {
 		return err
 	}
 
+	// with StreamRequestBody on, read the multipart body as it arrives instead
+	// of letting fasthttp spool the whole form to disk first
+	if body := c.Request().BodyStream(); body != nil {
+		if boundary := c.Request().Header.MultipartFormBoundary(); len(boundary) > 0 {
+			return h.streamUpload(c, userID, multipart.NewReader(body, string(boundary)))
+		}
+	}
+
 	fh, err := c.FormFile("file")
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, "no file uploaded")
@@ -56,14 +74,57 @@ func (h *FileHandler) UploadFileHandler(c *fiber.Ctx) error {
 	defer f.Close()
 
 	ct := fh.Header.Get("Content-Type")
-	meta, err := h.storage.SaveFile(c.Context(), userID, fh.Filename, ct, fh.Size, f)
+	return h.save(c, userID, fh.Filename, ct, fh.Size, f)
+}
+
+// save stores an upload as a new file, or as a new version of ?file_id= when given.
+func (h *FileHandler) save(c *fiber.Ctx, userID, name, ct string, size int64, r io.Reader) error {
+	var (
+		meta *models.File
+		err  error
+	)
+	if fileID := c.Query("file_id"); fileID != "" {
+		meta, err = h.storage.SaveFileVersion(c.Context(), userID, fileID, ct, r)
+	} else {
+		meta, err = h.storage.SaveFile(c.Context(), userID, c.Query("folder_id"), name, ct, size, r)
+	}
 	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileNotFound):
+			return fiber.NewError(fiber.StatusNotFound, "file not found")
+		case errors.Is(err, repo.ErrFolderNotFound):
+			return fiber.NewError(fiber.StatusNotFound, "folder not found")
+		case errors.Is(err, repo.ErrNameConflict):
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		case errors.Is(err, service.ErrFileTooLarge):
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, repo.ErrQuotaExceeded):
+			return fiber.NewError(fiber.StatusInsufficientStorage, err.Error())
+		}
 		return fiber.NewError(fiber.StatusInternalServerError, "save failed: "+err.Error())
 	}
 
 	return c.Status(fiber.StatusOK).JSON(meta)
 }
 
+func (h *FileHandler) streamUpload(c *fiber.Ctx, userID string, mr *multipart.Reader) error {
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return fiber.NewError(fiber.StatusBadRequest, "no file uploaded")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid multipart body")
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		defer part.Close()
+		return h.save(c, userID, part.FileName(), part.Header.Get("Content-Type"), -1, part)
+	}
+}
+
 // GetUserFilesHandler godoc
 //
 //	@Summary		List my files
@@ -93,16 +154,22 @@ func (h *FileHandler) GetUserFilesHandler(c *fiber.Ctx) error {
 
 // GetFileByIdHandler godoc
 //
-//	@Summary		Get file by ID
-//	@Description	Retrieves metadata for a specific file
+//	@Summary		Download a file
+//	@Description	Streams a file's contents. Supports HEAD, byte ranges (single and multipart/byteranges) and conditional requests using the sha256 as a strong ETag
 //	@Tags			files
 //	@Security		BearerAuth
-//	@Produce		json
-//	@Param			id	path		string	true	"File ID"
-//	@Success		200	{object}	models.FileMeta
-//	@Failure		401	{object}	map[string]string
-//	@Failure		404	{object}	map[string]string
-//	@Router			/files/{id} [get]
+//	@Produce		octet-stream
+//	@Param			fileID				path		string	true	"File ID"
+//	@Param			Range				header		string	false	"bytes=start-end[,start-end...]"
+//	@Param			If-None-Match		header		string	false	"ETag from a previous response"
+//	@Param			If-Modified-Since	header		string	false	"HTTP date"
+//	@Param			If-Range			header		string	false	"ETag or HTTP date"
+//	@Success		200
+//	@Success		206
+//	@Success		304
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Failure		416			{object}	map[string]string
+//	@Router			/me/files/{fileID} [get]
 func (h *FileHandler) GetUserFileByIDHandler(c *fiber.Ctx) error {
 	userID, err := resolveUserID(c)
 	if err != nil {
@@ -110,20 +177,129 @@ func (h *FileHandler) GetUserFileByIDHandler(c *fiber.Ctx) error {
 	}
 
 	fileID := c.Params("fileID")
-	meta, rc, err := h.storage.OpenFile(c.Context(), userID, fileID)
+	meta, err := h.storage.StatFile(c.Context(), userID, fileID)
 	if err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "open failed: "+err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "stat failed: "+err.Error())
+	}
+	if meta == nil {
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
 	}
-	defer rc.Close()
 
-	if meta.ContentType != "" {
-		c.Set("Content-Type", meta.ContentType)
+	etag := ""
+	if meta.SHA256 != "" {
+		etag = `"` + meta.SHA256 + `"`
+		c.Set(fiber.HeaderETag, etag)
+	}
+	// the current version's time, so a new upload invalidates cached copies and
+	// date If-Range validators
+	lastModified := meta.UpdatedAt.UTC()
+	c.Set(fiber.HeaderLastModified, lastModified.Format(http.TimeFormat))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if notModified(c.Get(fiber.HeaderIfNoneMatch), c.Get(fiber.HeaderIfModifiedSince), etag, lastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
 	}
 	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, meta.OriginalName))
-	return c.SendStream(rc)
+
+	var ranges []byteRange
+	if rangeApplies(c.Get(fiber.HeaderIfRange), etag, lastModified) {
+		ranges, err = parseRange(c.Get(fiber.HeaderRange), meta.SizeBytes)
+		if errors.Is(err, errUnsatisfiableRange) {
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", meta.SizeBytes))
+			return fiber.NewError(fiber.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+		}
+	}
+
+	head := c.Method() == fiber.MethodHead
+
+	switch len(ranges) {
+	case 0:
+		c.Set(fiber.HeaderContentType, contentType)
+		if head {
+			c.Response().SkipBody = true
+			c.Response().Header.SetContentLength(int(meta.SizeBytes))
+			return c.SendStatus(fiber.StatusOK)
+		}
+		_, rc, err := h.storage.OpenFile(c.Context(), userID, fileID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "open failed: "+err.Error())
+		}
+		// fasthttp closes the stream once the body has been written
+		return c.SendStream(rc, int(meta.SizeBytes))
+
+	case 1:
+		r := ranges[0]
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Set(fiber.HeaderContentRange, r.contentRange(meta.SizeBytes))
+		c.Status(fiber.StatusPartialContent)
+		if head {
+			c.Response().SkipBody = true
+			c.Response().Header.SetContentLength(int(r.length))
+			return nil
+		}
+		_, rc, err := h.storage.OpenFileRange(c.Context(), userID, fileID, r.start, r.length)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "open failed: "+err.Error())
+		}
+		return c.SendStream(rc, int(r.length))
+
+	default:
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		c.Set(fiber.HeaderContentType, "multipart/byteranges; boundary="+mw.Boundary())
+		c.Status(fiber.StatusPartialContent)
+		if head {
+			c.Response().SkipBody = true
+			return nil
+		}
+
+		// the handler returns before fasthttp drains the body, so use a
+		// context that outlives the request for the range reads
+		ctx := context.WithoutCancel(c.Context())
+		go func() {
+			for _, r := range ranges {
+				part, err := mw.CreatePart(textproto.MIMEHeader{
+					fiber.HeaderContentType:  {contentType},
+					fiber.HeaderContentRange: {r.contentRange(meta.SizeBytes)},
+				})
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				_, rc, err := h.storage.OpenFileRange(ctx, userID, fileID, r.start, r.length)
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				_, err = io.Copy(part, rc)
+				rc.Close()
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			pw.CloseWithError(mw.Close())
+		}()
+		return c.SendStream(pr)
+	}
 }
 
 // DeleteUserFileByIDHandler godoc
+//
+//	@Summary		Move a file to the trash
+//	@Description	Trashed files are hidden from listings and downloads and can be restored until the retention window passes
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID} [delete]
 func (h *FileHandler) DeleteUserFileByIDHandler(c *fiber.Ctx) error {
 	userID, err := resolveUserID(c)
 	if err != nil {
@@ -132,9 +308,92 @@ func (h *FileHandler) DeleteUserFileByIDHandler(c *fiber.Ctx) error {
 
 	fileID := c.Params("fileID")
 	if err := h.storage.DeleteFile(c.Context(), userID, fileID); err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "file not found")
+		}
 		return fiber.NewError(fiber.StatusInternalServerError, "delete failed: "+err.Error())
 	}
-	return c.JSON(fiber.Map{"message": "deleted"})
+	return c.JSON(fiber.Map{"message": "moved to trash"})
+}
+
+// ListTrashHandler godoc
+//
+//	@Summary		List my trash
+//	@Description	Returns the authenticated user's trashed files, most recently deleted first
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		models.FileMeta
+//	@Failure		401,500	{object}	map[string]string
+//	@Router			/me/files/trash [get]
+func (h *FileHandler) ListTrashHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	list, err := h.storage.ListTrash(c.Context(), userID, limit, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "list failed: "+err.Error())
+	}
+	if list == nil {
+		list = []*models.File{}
+	}
+	return c.JSON(list)
+}
+
+// RestoreFileHandler godoc
+//
+//	@Summary		Restore a file from the trash
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/restore [post]
+func (h *FileHandler) RestoreFileHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.storage.RestoreFile(c.Context(), userID, c.Params("fileID")); err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "file not in trash")
+		}
+		if errors.Is(err, repo.ErrNameConflict) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "restore failed: "+err.Error())
+	}
+	return c.JSON(fiber.Map{"message": "restored"})
+}
+
+// EmptyTrashHandler godoc
+//
+//	@Summary		Empty my trash
+//	@Description	Permanently deletes every trashed file of the authenticated user
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		401,500	{object}	map[string]string
+//	@Router			/me/files/trash [delete]
+func (h *FileHandler) EmptyTrashHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	n, err := h.storage.EmptyTrash(c.Context(), userID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "empty trash failed: "+err.Error())
+	}
+	return c.JSON(fiber.Map{"message": "trash emptied", "deleted": n})
 }
 
 func (h *FileHandler) SearchFilesHandler(c *fiber.Ctx) error {
@@ -182,6 +441,9 @@ func (h *FileHandler) RenameFileHandler(c *fiber.Ctx) error {
 	}
 
 	if err := h.storage.RenameFile(c.Context(), userID, fileID, req.NewName); err != nil {
+		if errors.Is(err, repo.ErrNameConflict) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
 		return fiber.NewError(fiber.StatusInternalServerError, "rename failed: "+err.Error())
 	}
 
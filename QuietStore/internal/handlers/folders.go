@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type FolderHandler struct {
+	folders *service.FolderService
+}
+
+func NewFolderHandler(folders *service.FolderService) *FolderHandler {
+	return &FolderHandler{folders: folders}
+}
+
+func folderError(err error) error {
+	switch {
+	case errors.Is(err, repo.ErrFolderNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "folder not found")
+	case errors.Is(err, service.ErrFileNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	case errors.Is(err, service.ErrInvalidName):
+		return fiber.NewError(fiber.StatusBadRequest, "name must be non-empty and must not contain '/'")
+	case errors.Is(err, repo.ErrNameConflict), errors.Is(err, repo.ErrFolderNotEmpty), errors.Is(err, repo.ErrFolderCycle):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "folder operation failed: "+err.Error())
+	}
+}
+
+type CreateFolderRequest struct {
+	Name     string `json:"name"      example:"reports"`
+	ParentID string `json:"parent_id" example:"Folder_2b1c..."`
+}
+
+// UpdateFolderRequest renames and/or moves a folder. Omitted fields are left
+// unchanged; a parent_id of "" moves the folder to the root.
+type UpdateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+type MoveFileRequest struct {
+	FolderID string `json:"folder_id" example:"Folder_2b1c..."`
+}
+
+// CreateFolderHandler godoc
+//
+//	@Summary		Create a folder
+//	@Description	Creates a folder under parent_id, or at the root when it is omitted
+//	@Tags			folders
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		CreateFolderRequest	true	"folder"
+//	@Success		201		{object}	models.Folder
+//	@Failure		400,401,404,409,500	{object}	map[string]string
+//	@Router			/me/folders [post]
+func (h *FolderHandler) CreateFolderHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req CreateFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+
+	f, err := h.folders.CreateFolder(c.Context(), userID, req.ParentID, req.Name)
+	if err != nil {
+		return folderError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(f)
+}
+
+// ListFolderHandler godoc
+//
+//	@Summary		List folder contents
+//	@Description	Lists the folders and files directly inside a folder, or everything below it with recursive=true. Paths in a recursive listing are relative to the folder.
+//	@Tags			folders
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			folderID	path		string	false	"Folder ID, root if omitted"
+//	@Param			recursive	query		bool	false	"include all subfolders"
+//	@Success		200			{object}	models.FolderContents
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/folders/{folderID} [get]
+func (h *FolderHandler) ListFolderHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := h.folders.ListFolder(c.Context(), userID, c.Params("folderID"), c.QueryBool("recursive"))
+	if err != nil {
+		return folderError(err)
+	}
+	return c.JSON(out)
+}
+
+// UpdateFolderHandler godoc
+//
+//	@Summary		Rename or move a folder
+//	@Tags			folders
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			folderID	path		string				true	"Folder ID"
+//	@Param			body		body		UpdateFolderRequest	true	"changes"
+//	@Success		200			{object}	models.Folder
+//	@Failure		400,401,404,409,500	{object}	map[string]string
+//	@Router			/me/folders/{folderID} [patch]
+func (h *FolderHandler) UpdateFolderHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req UpdateFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+
+	f, err := h.folders.UpdateFolder(c.Context(), userID, c.Params("folderID"), req.Name, req.ParentID)
+	if err != nil {
+		return folderError(err)
+	}
+	return c.JSON(f)
+}
+
+// DeleteFolderHandler godoc
+//
+//	@Summary		Delete a folder
+//	@Description	Deletes an empty folder. With recursive=true, subfolders are deleted too and every file below it is moved to the trash.
+//	@Tags			folders
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			folderID	path		string	true	"Folder ID"
+//	@Param			recursive	query		bool	false	"delete a non-empty folder"
+//	@Success		200			{object}	map[string]string
+//	@Failure		401,404,409,500	{object}	map[string]string
+//	@Router			/me/folders/{folderID} [delete]
+func (h *FolderHandler) DeleteFolderHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.folders.DeleteFolder(c.Context(), userID, c.Params("folderID"), c.QueryBool("recursive")); err != nil {
+		return folderError(err)
+	}
+	return c.JSON(fiber.Map{"message": "folder deleted"})
+}
+
+// MoveFileHandler godoc
+//
+//	@Summary		Move a file into a folder
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			fileID	path		string			true	"File ID"
+//	@Param			body	body		MoveFileRequest	true	"target folder, root if empty"
+//	@Success		200		{object}	models.FileMeta
+//	@Failure		400,401,404,409,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/move [patch]
+func (h *FolderHandler) MoveFileHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req MoveFileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+
+	f, err := h.folders.MoveFile(c.Context(), userID, c.Params("fileID"), req.FolderID)
+	if err != nil {
+		return folderError(err)
+	}
+	return c.JSON(f)
+}
+
+// FileByPathHandler godoc
+//
+//	@Summary		Look up a file by path
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			path	query		string	true	"e.g. /reports/2025/q1.pdf"
+//	@Success		200		{object}	models.FileMeta
+//	@Failure		400,401,404,500	{object}	map[string]string
+//	@Router			/me/files/by-path [get]
+func (h *FolderHandler) FileByPathHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "path is required")
+	}
+
+	f, err := h.folders.FileByPath(c.Context(), userID, path)
+	if err != nil {
+		return folderError(err)
+	}
+	return c.JSON(f)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type MFAHandler struct {
+	mfa *service.MFAService
+}
+
+func NewMFAHandler(mfa *service.MFAService) *MFAHandler {
+	return &MFAHandler{mfa: mfa}
+}
+
+func mfaError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrMFAAlreadyEnabled),
+		errors.Is(err, service.ErrMFANotEnabled),
+		errors.Is(err, service.ErrMFANotPending):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInvalidMFACode):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrUserNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "two-factor authentication failed: "+err.Error())
+	}
+}
+
+func parseMFACode(c *fiber.Ctx) (string, error) {
+	var req models.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return "", fiber.NewError(fiber.StatusBadRequest, "code is required")
+	}
+	return req.Code, nil
+}
+
+// GetMFAStatusHandler godoc
+//
+//	@Summary		Two-factor status
+//	@Tags			mfa
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	models.MFAStatus
+//	@Failure		401,403,500	{object}	map[string]string
+//	@Router			/me/mfa [get]
+func (h *MFAHandler) GetMFAStatusHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.mfa.Status(c.Context(), userID)
+	if err != nil {
+		return mfaError(err)
+	}
+	return c.JSON(status)
+}
+
+// EnrollTOTPHandler godoc
+//
+//	@Summary		Start TOTP enrollment
+//	@Description	Generates a secret to add to an authenticator app, as text and as an otpauth:// URI for a QR code. Two-factor authentication stays off until it is confirmed with a code.
+//	@Tags			mfa
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		201	{object}	models.TOTPEnrollmentResponse
+//	@Failure		401,403,409,500	{object}	map[string]string
+//	@Router			/me/mfa/totp [post]
+func (h *MFAHandler) EnrollTOTPHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := h.mfa.StartEnrollment(c.Context(), userID)
+	if err != nil {
+		return mfaError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(out)
+}
+
+// ConfirmTOTPHandler godoc
+//
+//	@Summary		Confirm TOTP enrollment
+//	@Description	Turns two-factor authentication on and returns recovery codes. They are only shown here.
+//	@Tags			mfa
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.MFACodeRequest	true	"code from the authenticator app"
+//	@Success		200		{object}	models.RecoveryCodesResponse
+//	@Failure		400,401,403,409,500	{object}	map[string]string
+//	@Router			/me/mfa/totp/confirm [post]
+func (h *MFAHandler) ConfirmTOTPHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	code, err := parseMFACode(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := h.mfa.ConfirmEnrollment(c.Context(), userID, code)
+	if err != nil {
+		return mfaError(err)
+	}
+	return c.JSON(models.RecoveryCodesResponse{Codes: codes})
+}
+
+// DisableTOTPHandler godoc
+//
+//	@Summary		Turn off two-factor authentication
+//	@Tags			mfa
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.MFACodeRequest	true	"TOTP or recovery code"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400,401,403,409,500	{object}	map[string]string
+//	@Router			/me/mfa/totp [delete]
+func (h *MFAHandler) DisableTOTPHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	code, err := parseMFACode(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.mfa.Disable(c.Context(), userID, code); err != nil {
+		return mfaError(err)
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodesHandler godoc
+//
+//	@Summary		Regenerate recovery codes
+//	@Description	Replaces every recovery code. The new ones are only shown here.
+//	@Tags			mfa
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.MFACodeRequest	true	"TOTP or recovery code"
+//	@Success		200		{object}	models.RecoveryCodesResponse
+//	@Failure		400,401,403,409,500	{object}	map[string]string
+//	@Router			/me/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodesHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	code, err := parseMFACode(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(c.Context(), userID, code)
+	if err != nil {
+		return mfaError(err)
+	}
+	return c.JSON(models.RecoveryCodesResponse{Codes: codes})
+}
+
+// ResetUserMFAHandler godoc
+//
+//	@Summary		Reset a user's two-factor authentication
+//	@Description	Admin only. For users who lost both their authenticator and their recovery codes.
+//	@Tags			users
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			id	path		string	true	"User ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		401,403,409,500	{object}	map[string]string
+//	@Router			/users/{id}/mfa [delete]
+func (h *MFAHandler) ResetUserMFAHandler(c *fiber.Ctx) error {
+	if err := h.mfa.Reset(c.Context(), c.Params("id")); err != nil {
+		return mfaError(err)
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication reset"})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"path"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// oidcStateCookie ties a callback to the browser that started the login,
+	// so nobody can log a victim into the attacker's account with a link.
+	oidcStateCookie = "quietstore_oidc_state"
+	oidcStateMaxAge = 10 * time.Minute
+)
+
+type OIDCHandler struct {
+	oidc *service.OIDCService
+	auth *AuthHandler
+}
+
+// NewOIDCHandler finishes logins through auth, so they get the same two-factor
+// check and sessions as password logins.
+func NewOIDCHandler(oidc *service.OIDCService, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{oidc: oidc, auth: auth}
+}
+
+// oidcCookiePath scopes the state cookie to where the login and callback
+// routes are mounted, whatever prefix the API sits under.
+func oidcCookiePath(c *fiber.Ctx) string {
+	return path.Dir(c.Route().Path)
+}
+
+func oidcError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidOIDCState):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrInvalidIDToken), errors.Is(err, service.ErrUserNotFound):
+		return fiber.NewError(fiber.StatusUnauthorized, "identity provider login failed")
+	case errors.Is(err, service.ErrOIDCEmailNotVerified), errors.Is(err, service.ErrOIDCNoAccount):
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrOIDCAccountConflict):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrOIDCProvider):
+		return fiber.NewError(fiber.StatusBadGateway, "identity provider unavailable")
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "identity provider login failed")
+	}
+}
+
+// OIDCLoginHandler godoc
+//
+//	@Summary		Log in with the identity provider
+//	@Description	Redirects the browser to the OpenID Connect provider, which sends it back to /auth/oidc/callback
+//	@Tags			auth
+//	@Success		302
+//	@Failure		502,500	{object}	map[string]string
+//	@Router			/auth/oidc/login [get]
+func (h *OIDCHandler) OIDCLoginHandler(c *fiber.Ctx) error {
+	authURL, state, err := h.oidc.StartLogin(c.Context())
+	if err != nil {
+		log.Printf("[oidc] starting login failed: %v", err)
+		return oidcError(err)
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath(c),
+		MaxAge:   int(oidcStateMaxAge.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		// Lax still sends it on the provider's top-level redirect back
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallbackHandler godoc
+//
+//	@Summary		Identity provider callback
+//	@Description	Completes an OpenID Connect login. Users are linked by verified email or provisioned on first login. Users with two-factor authentication get an mfa_token to redeem at /auth/login/mfa.
+//	@Tags			auth
+//	@Produce		json
+//	@Param			code	query		string	true	"authorization code"
+//	@Param			state	query		string	true	"state from /auth/oidc/login"
+//	@Success		200		{object}	models.TokenPairResponse
+//	@Failure		400,401,403,409,500,502	{object}	map[string]string
+//	@Router			/auth/oidc/callback [get]
+func (h *OIDCHandler) OIDCCallbackHandler(c *fiber.Ctx) error {
+	cookie := c.Cookies(oidcStateCookie)
+	c.Cookie(&fiber.Cookie{Name: oidcStateCookie, Path: oidcCookiePath(c), Expires: time.Unix(0, 0), MaxAge: -1})
+
+	if e := c.Query("error"); e != "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "identity provider refused the login: "+e)
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "missing code or state")
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		return oidcError(service.ErrInvalidOIDCState)
+	}
+
+	u, err := h.oidc.FinishLogin(c.Context(), state, code)
+	if err != nil {
+		if !errors.Is(err, service.ErrInvalidOIDCState) {
+			log.Printf("[oidc] login failed: %v", err)
+		}
+		return oidcError(err)
+	}
+	return h.auth.completeLogin(c, u)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/oidctest"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// nobody in these tests has two-factor authentication
+type noMFA struct{ repo.MFA }
+
+func (noMFA) TOTP(context.Context, string) (*models.TOTP, error) { return nil, nil }
+
+type sessionsStub struct{ repo.RefreshTokens }
+
+func (sessionsStub) StartSession(context.Context, *models.Session, *models.RefreshToken) error {
+	return nil
+}
+
+// newOIDCApp mounts the OIDC routes under prefix, logging in through a mock
+// provider whose user already has a verified account.
+func newOIDCApp(t *testing.T, prefix string) *fiber.App {
+	t.Helper()
+	user := oidctest.User{Subject: "bob-sub", Email: "bob@example.com", EmailVerified: true}
+	mock, err := oidctest.NewServer("quietstore", "secret", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+
+	users := &oidctest.Users{}
+	now := time.Now()
+	bob := &models.User{ID: models.GenerateUserID(), Username: "bob", Email: user.Email, EmailVerifiedAt: &now, Role: models.RoleMember, CreatedAt: now}
+	if err := users.Create(context.Background(), bob); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := service.GenerateJWTKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := service.NewJWTKeys("quietstore", "quietstore-api", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := service.NewBcryptHasher(bcrypt.MinCost)
+	auth := NewAuthHandler(users, sessionsStub{}, nil, service.NewMFAService(noMFA{}, users, "QuietStore"), nil, passwords, keys, nil, 15*time.Minute, 24*time.Hour)
+
+	provider := service.NewOIDCProvider(mock.Issuer, "quietstore", "secret", "http://quietstore.test"+prefix+"/oidc/callback", []string{"openid", "email"})
+	h := NewOIDCHandler(service.NewOIDCService(provider, oidctest.NewStore(), users, passwords, false), auth)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	g := app.Group(prefix)
+	g.Get("/oidc/login", h.OIDCLoginHandler)
+	g.Get("/oidc/callback", h.OIDCCallbackHandler)
+	return app
+}
+
+// startLogin returns the state cookie and the callback path and query the
+// provider redirects back to.
+func startLogin(t *testing.T, app *fiber.App, prefix string) (*http.Cookie, string) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, prefix+"/oidc/login", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("login status = %d", resp.StatusCode)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("login set no state cookie")
+	}
+
+	callback, err := oidctest.Authorize(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie, callback.RequestURI()
+}
+
+func callback(t *testing.T, app *fiber.App, target string, cookie *http.Cookie) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	const prefix = "/api/v1/auth"
+	app := newOIDCApp(t, prefix)
+	cookie, target := startLogin(t, app, prefix)
+
+	if resp := callback(t, app, target, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("callback without cookie: status %d, want 400", resp.StatusCode)
+	}
+
+	// a state cookie from a login started in another browser
+	other, _ := startLogin(t, app, prefix)
+	if resp := callback(t, app, target, other); resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("callback with another login's cookie: status %d, want 400", resp.StatusCode)
+	}
+
+	// the rejected callbacks didn't use up the login
+	resp := callback(t, app, target, cookie)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("callback with cookie: status %d, want 200", resp.StatusCode)
+	}
+	var pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Username     string `json:"username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pair); err != nil {
+		t.Fatal(err)
+	}
+	if pair.AccessToken == "" || pair.RefreshToken == "" || pair.Username != "bob" {
+		t.Fatalf("callback response %+v", pair)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcStateCookie && (c.Value != "" || c.Expires.After(time.Now())) {
+			t.Fatalf("state cookie not cleared: %+v", c)
+		}
+	}
+}
+
+func TestOIDCStateCookiePathFollowsRoutes(t *testing.T) {
+	for _, prefix := range []string{"/api/v1/auth", "/quietstore/api/v2/auth"} {
+		app := newOIDCApp(t, prefix)
+		cookie, target := startLogin(t, app, prefix)
+		if want := prefix + "/oidc"; cookie.Path != want {
+			t.Fatalf("state cookie path = %q, want %q", cookie.Path, want)
+		}
+
+		resp := callback(t, app, target, cookie)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("callback under %s: status %d", prefix, resp.StatusCode)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == oidcStateCookie && c.Path != cookie.Path {
+				t.Fatalf("state cookie cleared at %q, set at %q", c.Path, cookie.Path)
+			}
+		}
+	}
+}
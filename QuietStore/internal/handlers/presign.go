@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PresignHandler struct {
+	presigner service.Presigner
+}
+
+func NewPresignHandler(presigner service.Presigner) *PresignHandler {
+	return &PresignHandler{presigner: presigner}
+}
+
+func presignError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrFileNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	case errors.Is(err, service.ErrUploadExpired):
+		return fiber.NewError(fiber.StatusGone, "upload expired")
+	case errors.Is(err, service.ErrObjectNotUploaded), errors.Is(err, repo.ErrNameConflict),
+		errors.Is(err, service.ErrEncrypted), errors.Is(err, repo.ErrPresignedUploadGone):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrFileTooLarge):
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, repo.ErrQuotaExceeded):
+		return fiber.NewError(fiber.StatusInsufficientStorage, err.Error())
+	case errors.Is(err, service.ErrObjectMismatch):
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "presign failed: "+err.Error())
+	}
+}
+
+// DownloadURLHandler godoc
+//
+//	@Summary		Presigned download URL
+//	@Description	Returns a short-lived URL that downloads the file directly from object storage
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID"
+//	@Success		200		{object}	models.PresignedURLResponse
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/download-url [get]
+func (h *PresignHandler) DownloadURLHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	u, err := h.presigner.PresignDownload(c.Context(), userID, c.Params("fileID"))
+	if err != nil {
+		return presignError(err)
+	}
+	return c.JSON(models.PresignedURLResponse{
+		Method:    u.Method,
+		URL:       u.URL,
+		ExpiresAt: u.ExpiresAt,
+	})
+}
+
+// UploadURLHandler godoc
+//
+//	@Summary		Presigned upload URL
+//	@Description	Reserves a file ID and returns a short-lived PUT URL plus the headers the client must send with it. Call confirm once the PUT succeeds.
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.PresignUploadRequest	true	"file to upload"
+//	@Success		201		{object}	models.PresignedURLResponse
+//	@Failure		400,401,500	{object}	map[string]string
+//	@Router			/me/files/presigned [post]
+func (h *PresignHandler) UploadURLHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var in models.PresignUploadRequest
+	if err := c.BodyParser(&in); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+	if in.Filename == "" || in.Size < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "filename and size are required")
+	}
+	if sum, err := hex.DecodeString(in.SHA256); err != nil || len(sum) != 32 {
+		return fiber.NewError(fiber.StatusBadRequest, "sha256 must be a hex encoded SHA-256 digest")
+	}
+
+	p, u, err := h.presigner.PresignUpload(c.Context(), userID, in.Filename, in.ContentType, in.Size, in.SHA256)
+	if err != nil {
+		return presignError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(models.PresignedURLResponse{
+		FileID:    p.ID,
+		Method:    u.Method,
+		URL:       u.URL,
+		Headers:   u.Headers,
+		ExpiresAt: u.ExpiresAt,
+	})
+}
+
+// ConfirmUploadHandler godoc
+//
+//	@Summary		Confirm a presigned upload
+//	@Description	Checks the uploaded object's size and checksum against what was declared and creates the file
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID returned by the presign call"
+//	@Success		200		{object}	models.FileMeta
+//	@Failure		401,404,409,410,422,500	{object}	map[string]string
+//	@Router			/me/files/presigned/{fileID}/confirm [post]
+func (h *PresignHandler) ConfirmUploadHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	f, err := h.presigner.ConfirmUpload(c.Context(), userID, c.Params("fileID"))
+	if err != nil {
+		return presignError(err)
+	}
+	return c.JSON(f)
+}
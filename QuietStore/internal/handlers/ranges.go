@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges caps how many ranges we'll serve in one multipart/byteranges
+// response; past that the Range header is ignored and the full body is sent.
+const maxRanges = 16
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses an RFC 9110 "bytes=" Range header against an object of the
+// given size. A nil slice with a nil error means the header should be ignored.
+func parseRange(h string, size int64) ([]byteRange, error) {
+	if h == "" {
+		return nil, nil
+	}
+	const prefix = "bytes="
+	if !strings.HasPrefix(h, prefix) {
+		return nil, nil
+	}
+
+	var (
+		out   []byteRange
+		total int64
+	)
+	for _, spec := range strings.Split(h[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				e, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || e < start {
+					return nil, nil
+				}
+				if e < end {
+					end = e
+				}
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		if r.length > 0 {
+			out = append(out, r)
+			total += r.length
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	// ranges overlapping into more bytes than the object has get the object
+	// once instead, as net/http does
+	if len(out) > maxRanges || total > size {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// etagMatches implements the weak comparison used by If-None-Match.
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since only
+// when no entity tag was sent (RFC 9110 section 13.2.2).
+func notModified(ifNoneMatch, ifModifiedSince, etag string, lastModified time.Time) bool {
+	if ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	if ifModifiedSince == "" {
+		return false
+	}
+	t, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// rangeApplies evaluates If-Range: a Range is only honoured when the
+// validator still matches, otherwise the full representation is sent.
+func rangeApplies(ifRange, etag string, lastModified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tooMany := "bytes=" + strings.Repeat("0-0,", maxRanges) + "1-1"
+	atCap := "bytes=" + strings.TrimSuffix(strings.Repeat("0-0,", maxRanges), ",")
+	atCapWant := make([]byteRange, maxRanges)
+	for i := range atCapWant {
+		atCapWant[i] = byteRange{0, 1}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		want   []byteRange
+		err    error
+	}{
+		{"no header", "", 100, nil, nil},
+		{"other unit", "items=0-5", 100, nil, nil},
+		{"single", "bytes=0-9", 100, []byteRange{{0, 10}}, nil},
+		{"whitespace", "bytes= 10 - 19 ", 100, []byteRange{{10, 10}}, nil},
+		{"last byte", "bytes=99-99", 100, []byteRange{{99, 1}}, nil},
+		{"end past size", "bytes=90-200", 100, []byteRange{{90, 10}}, nil},
+		{"open ended", "bytes=95-", 100, []byteRange{{95, 5}}, nil},
+		{"open ended from 0", "bytes=0-", 100, []byteRange{{0, 100}}, nil},
+		{"suffix", "bytes=-10", 100, []byteRange{{90, 10}}, nil},
+		{"suffix longer than object", "bytes=-500", 100, []byteRange{{0, 100}}, nil},
+		{"multiple", "bytes=0-9, 50-59,-5", 100, []byteRange{{0, 10}, {50, 10}, {95, 5}}, nil},
+		{"unsatisfiable range skipped", "bytes=200-300,0-4", 100, []byteRange{{0, 5}}, nil},
+		{"overlapping within size", "bytes=0-49,25-74", 100, []byteRange{{0, 50}, {25, 50}}, nil},
+
+		{"start past size", "bytes=100-", 100, nil, errUnsatisfiableRange},
+		{"all past size", "bytes=100-110,200-", 100, nil, errUnsatisfiableRange},
+		{"empty suffix", "bytes=-0", 100, nil, errUnsatisfiableRange},
+		{"empty object", "bytes=0-", 0, nil, errUnsatisfiableRange},
+		{"only commas", "bytes=,,", 100, nil, errUnsatisfiableRange},
+
+		{"overlapping past size", "bytes=0-99,0-99", 100, nil, nil},
+		{"reversed", "bytes=10-5", 100, nil, nil},
+		{"no dash", "bytes=10", 100, nil, nil},
+		{"negative start", "bytes=--5", 100, nil, nil},
+		{"garbage", "bytes=a-b", 100, nil, nil},
+		{"at range cap", atCap, 1000, atCapWant, nil},
+		{"over range cap", tooMany, 1000, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRange(%q, %d) error = %v, want %v", tt.header, tt.size, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseRange(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteRangeContentRange(t *testing.T) {
+	if got := (byteRange{start: 90, length: 10}).contentRange(100); got != "bytes 90-99/100" {
+		t.Fatalf("contentRange = %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// RateLimit allows limit requests per expiration window. Limiters built on
+// the same store share its key space, so name keeps their counters apart.
+// Once RequireAuth has run requests are counted per user, before that per IP.
+// Responses carry the RateLimit-* headers of the IETF httpapi draft next to
+// the X-RateLimit-* ones fiber sets.
+//
+// A nil store keeps counters in memory. With a shared store the count is
+// read, bumped and written back without a transaction, so replicas racing on
+// the same key can undercount a little. While the shared store fails,
+// counting carries on in memory, so limits hold per replica rather than not
+// at all.
+func RateLimit(store fiber.Storage, name string, limit int, expiration time.Duration, message string) fiber.Handler {
+	if store != nil {
+		store = &fallbackStorage{Storage: store, local: newMemoryStorage()}
+	}
+	limitValue := strconv.Itoa(limit)
+	policy := limitValue + ";w=" + strconv.Itoa(int(expiration.Seconds()))
+
+	mw := limiter.New(limiter.Config{
+		Max:        limit,
+		Expiration: expiration,
+		Storage:    store,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			if userID, ok := c.Locals("userID").(string); ok && userID != "" {
+				return "limiter:" + name + ":user:" + userID
+			}
+			return "limiter:" + name + ":ip:" + c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			c.Set("RateLimit-Limit", limitValue)
+			c.Set("RateLimit-Remaining", "0")
+			c.Set("RateLimit-Reset", c.GetRespHeader(fiber.HeaderRetryAfter))
+			c.Set("RateLimit-Policy", policy)
+			return fiber.NewError(fiber.StatusTooManyRequests, message)
+		},
+	})
+
+	return func(c *fiber.Ctx) error {
+		err := mw(c)
+		// fiber only sets its headers on requests it let through
+		if remaining := c.GetRespHeader("X-RateLimit-Remaining"); remaining != "" {
+			c.Set("RateLimit-Limit", limitValue)
+			c.Set("RateLimit-Remaining", remaining)
+			c.Set("RateLimit-Reset", c.GetRespHeader("X-RateLimit-Reset"))
+			c.Set("RateLimit-Policy", policy)
+		}
+		return err
+	}
+}
+
+// fallbackStorage keeps a copy of every limiter entry in memory and reads
+// it when the shared store fails. fiber's limiter ignores storage errors and
+// would otherwise start every count at zero, letting password guessing
+// through unlimited whenever the database struggles.
+type fallbackStorage struct {
+	fiber.Storage
+	local *memoryStorage
+}
+
+func (f *fallbackStorage) Get(key string) ([]byte, error) {
+	val, err := f.Storage.Get(key)
+	if err != nil {
+		log.Printf("[ratelimit] %s counted in memory: %v", key, err)
+		return f.local.Get(key)
+	}
+	return val, nil
+}
+
+func (f *fallbackStorage) Set(key string, val []byte, exp time.Duration) error {
+	_ = f.local.Set(key, val, exp)
+	if err := f.Storage.Set(key, val, exp); err != nil {
+		log.Printf("[ratelimit] %s not shared: %v", key, err)
+	}
+	return nil
+}
+
+func (f *fallbackStorage) Delete(key string) error {
+	_ = f.local.Delete(key)
+	return f.Storage.Delete(key)
+}
+
+func (f *fallbackStorage) Reset() error {
+	_ = f.local.Reset()
+	return f.Storage.Reset()
+}
+
+// memoryStorage is a fiber.Storage in a map. Expired entries are swept at
+// most once a minute, so a Set costs a map write.
+type memoryStorage struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	val       []byte
+	expiresAt time.Time
+}
+
+const memorySweepInterval = time.Minute
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (m *memoryStorage) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || (!e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt)) {
+		return nil, nil
+	}
+	return e.val, nil
+}
+
+func (m *memoryStorage) Set(key string, val []byte, exp time.Duration) error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		for k, e := range m.entries {
+			if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	e := memoryEntry{val: val}
+	if exp > 0 {
+		e.expiresAt = now.Add(exp)
+	}
+	m.entries[key] = e
+	return nil
+}
+
+func (m *memoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryStorage) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = map[string]memoryEntry{}
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
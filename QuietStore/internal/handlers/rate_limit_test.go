@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// downStorage is a shared store whose database is unreachable.
+type downStorage struct{ memoryStorage }
+
+var errStoreDown = errors.New("connection refused")
+
+func (*downStorage) Get(string) ([]byte, error)              { return nil, errStoreDown }
+func (*downStorage) Set(string, []byte, time.Duration) error { return errStoreDown }
+
+func limitedApp(store fiber.Storage, limit int) *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/", RateLimit(store, "test", limit, time.Minute, "slow down"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app
+}
+
+func hit(t *testing.T, app *fiber.App) *http.Response {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRateLimit(t *testing.T) {
+	stores := map[string]fiber.Storage{
+		"in memory":    nil,
+		"shared":       newMemoryStorage(),
+		"shared, down": &downStorage{},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			app := limitedApp(store, 3)
+			for i := 1; i <= 3; i++ {
+				resp := hit(t, app)
+				if resp.StatusCode != fiber.StatusNoContent {
+					t.Fatalf("request %d: status %d", i, resp.StatusCode)
+				}
+				if got, want := resp.Header.Get("RateLimit-Remaining"), resp.Header.Get("X-RateLimit-Remaining"); got != want || got == "" {
+					t.Fatalf("request %d: RateLimit-Remaining %q, X-RateLimit-Remaining %q", i, got, want)
+				}
+				if got := resp.Header.Get("RateLimit-Policy"); got != "3;w=60" {
+					t.Fatalf("RateLimit-Policy = %q", got)
+				}
+			}
+
+			resp := hit(t, app)
+			if resp.StatusCode != fiber.StatusTooManyRequests {
+				t.Fatalf("request over the limit: status %d, want 429", resp.StatusCode)
+			}
+			if resp.Header.Get("RateLimit-Remaining") != "0" || resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+				t.Fatalf("429 headers = %v", resp.Header)
+			}
+		})
+	}
+}
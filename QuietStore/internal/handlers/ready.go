@@ -18,11 +18,14 @@ func ReadyCheck(dbConn db.Pingable, s3c *s3.Client, bucket string) fiber.Handler
 			return fiber.NewError(fiber.StatusServiceUnavailable, "db not ready: "+err.Error())
 		}
 
-		_, err := s3c.HeadBucket(ctx, &s3.HeadBucketInput{
-			Bucket: &bucket,
-		})
-		if err != nil {
-			return fiber.NewError(fiber.StatusServiceUnavailable, "s3 not ready: "+err.Error())
+		// s3c is nil when running on the local filesystem backend
+		if s3c != nil {
+			_, err := s3c.HeadBucket(ctx, &s3.HeadBucketInput{
+				Bucket: &bucket,
+			})
+			if err != nil {
+				return fiber.NewError(fiber.StatusServiceUnavailable, "s3 not ready: "+err.Error())
+			}
 		}
 
 		return c.JSON(fiber.Map{
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func resolveRole(c *fiber.Ctx) string {
+	role, _ := c.Locals("role").(string)
+	return role
+}
+
+// RequireRole lets a request through only if its access token carries one of
+// roles. It must run after RequireAuth.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !slices.Contains(roles, resolveRole(c)) {
+			return fiber.NewError(fiber.StatusForbidden, "insufficient role")
+		}
+		return c.Next()
+	}
+}
+
+// RequireRoleToWrite is RequireRole for requests that change something; GET,
+// HEAD and OPTIONS pass for every role.
+func RequireRoleToWrite(roles ...string) fiber.Handler {
+	check := RequireRole(roles...)
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		return check(c)
+	}
+}
+
+// RequireScope limits API tokens to routes their scopes cover: read for GET,
+// HEAD and OPTIONS, write for everything else. Requests authenticated with an
+// access JWT aren't scoped and always pass.
+func RequireScope(read, write string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopes, ok := c.Locals("scopes").([]string)
+		if !ok {
+			return c.Next()
+		}
+		need := write
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			need = read
+		}
+		if !slices.Contains(scopes, need) {
+			return fiber.NewError(fiber.StatusForbidden, "api token lacks the "+need+" scope")
+		}
+		return c.Next()
+	}
+}
+
+// RequireSession rejects API tokens, for routes that manage the account
+// itself and need a real login.
+func RequireSession(c *fiber.Ctx) error {
+	if _, ok := c.Locals("scopes").([]string); ok {
+		return fiber.NewError(fiber.StatusForbidden, "api tokens cannot be used here")
+	}
+	return c.Next()
+}
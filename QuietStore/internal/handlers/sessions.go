@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type SessionHandler struct {
+	refresh  repo.RefreshTokens
+	denylist *service.AccessTokenDenylist
+}
+
+func NewSessionHandler(refresh repo.RefreshTokens, denylist *service.AccessTokenDenylist) *SessionHandler {
+	return &SessionHandler{refresh: refresh, denylist: denylist}
+}
+
+// ListSessionsHandler godoc
+//
+//	@Summary		List my sessions
+//	@Description	Devices currently logged in, most recently used first. The one making the request is marked current.
+//	@Tags			sessions
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		models.Session
+//	@Failure		401,403,500	{object}	map[string]string
+//	@Router			/me/sessions [get]
+func (h *SessionHandler) ListSessionsHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := h.refresh.ListSessions(c.Context(), userID, time.Now().UTC())
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to list sessions")
+	}
+	if list == nil {
+		list = []*models.Session{}
+	}
+	current, _ := c.Locals("sessionID").(string)
+	for _, s := range list {
+		s.Current = s.ID == current
+	}
+	return c.JSON(list)
+}
+
+// RevokeSessionHandler godoc
+//
+//	@Summary		Revoke a session
+//	@Description	Logs the device out, its access token stops working at once.
+//	@Tags			sessions
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			sessionID	path		string	true	"Session ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		401,403,404,500	{object}	map[string]string
+//	@Router			/me/sessions/{sessionID} [delete]
+func (h *SessionHandler) RevokeSessionHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	ok, err := h.refresh.RevokeSession(c.Context(), userID, c.Params("sessionID"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke session")
+	}
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "session not found")
+	}
+	if err := h.denylist.RevokeSession(c.Context(), userID, c.Params("sessionID")); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke access tokens")
+	}
+	return c.JSON(fiber.Map{"message": "session revoked"})
+}
+
+// RevokeAllSessionsHandler godoc
+//
+//	@Summary		Log out everywhere
+//	@Description	Revokes every session, including the current one.
+//	@Tags			sessions
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		401,403,500	{object}	map[string]string
+//	@Router			/me/sessions/revoke-all [post]
+func (h *SessionHandler) RevokeAllSessionsHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	n, err := h.refresh.RevokeAllSessions(c.Context(), userID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke sessions")
+	}
+	if err := h.denylist.RevokeUser(c.Context(), userID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "failed to revoke access tokens")
+	}
+	return c.JSON(fiber.Map{"message": "all sessions revoked", "revoked": n})
+}
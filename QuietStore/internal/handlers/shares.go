@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ShareHandler struct {
+	shares *service.ShareService
+}
+
+func NewShareHandler(shares *service.ShareService) *ShareHandler {
+	return &ShareHandler{shares: shares}
+}
+
+func shareError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrShareNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "share not found")
+	case errors.Is(err, service.ErrFileNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	case errors.Is(err, repo.ErrFolderNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "folder not found")
+	case errors.Is(err, service.ErrShareGone):
+		return fiber.NewError(fiber.StatusGone, err.Error())
+	case errors.Is(err, service.ErrSharePassword):
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	case errors.Is(err, service.ErrInvalidShareTarget):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "share failed: "+err.Error())
+	}
+}
+
+// CreateShareHandler godoc
+//
+//	@Summary		Create a share link
+//	@Description	Creates a public link to a file or folder. The token is only returned here.
+//	@Tags			shares
+//	@Security		BearerAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.CreateShareRequest	true	"share"
+//	@Success		201		{object}	models.CreateShareResponse
+//	@Failure		400,401,404,500	{object}	map[string]string
+//	@Router			/me/shares [post]
+func (h *ShareHandler) CreateShareHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.CreateShareRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return fiber.NewError(fiber.StatusBadRequest, "expires_at must be in the future")
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "max_downloads must be at least 1")
+	}
+
+	sh, token, err := h.shares.CreateShare(c.Context(), userID, req)
+	if err != nil {
+		return shareError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(models.CreateShareResponse{
+		Share: *sh,
+		Token: token,
+		URL:   c.BaseURL() + "/s/" + token,
+	})
+}
+
+// ListSharesHandler godoc
+//
+//	@Summary		List my share links
+//	@Tags			shares
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{array}		models.Share
+//	@Failure		401,500	{object}	map[string]string
+//	@Router			/me/shares [get]
+func (h *ShareHandler) ListSharesHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := h.shares.ListShares(c.Context(), userID)
+	if err != nil {
+		return shareError(err)
+	}
+	if list == nil {
+		list = []*models.Share{}
+	}
+	return c.JSON(list)
+}
+
+// RevokeShareHandler godoc
+//
+//	@Summary		Revoke a share link
+//	@Tags			shares
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			shareID	path		string	true	"Share ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/shares/{shareID} [delete]
+func (h *ShareHandler) RevokeShareHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.shares.RevokeShare(c.Context(), userID, c.Params("shareID")); err != nil {
+		return shareError(err)
+	}
+	return c.JSON(fiber.Map{"message": "share revoked"})
+}
+
+func (h *ShareHandler) resolve(c *fiber.Ctx) (*models.Share, error) {
+	// only ever a header, query strings end up in logs and Referer headers
+	sh, err := h.shares.Resolve(c.Context(), c.Params("token"), c.Get("X-Share-Password"))
+	if err != nil {
+		return nil, shareError(err)
+	}
+	return sh, nil
+}
+
+func setSharedHeaders(c *fiber.Ctx, meta *models.File) {
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	if meta.SHA256 != "" {
+		c.Set(fiber.HeaderETag, `"`+meta.SHA256+`"`)
+	}
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, meta.OriginalName))
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+}
+
+// sendShared streams a shared file. HEAD requests only get the headers and
+// don't count as a download.
+func (h *ShareHandler) sendShared(c *fiber.Ctx, sh *models.Share, fileID string) error {
+	if c.Method() == fiber.MethodHead {
+		meta, err := h.shares.StatShared(c.Context(), sh, fileID)
+		if err != nil {
+			return shareError(err)
+		}
+		setSharedHeaders(c, meta)
+		c.Response().SkipBody = true
+		c.Response().Header.SetContentLength(int(meta.SizeBytes))
+		return nil
+	}
+
+	meta, rc, err := h.shares.OpenShared(c.Context(), sh, fileID)
+	if err != nil {
+		return shareError(err)
+	}
+	setSharedHeaders(c, meta)
+	return c.SendStream(rc, int(meta.SizeBytes))
+}
+
+// PublicShareHandler godoc
+//
+//	@Summary		Open a share link
+//	@Description	Downloads a shared file, or lists a shared folder. No authentication; password protected shares need the X-Share-Password header.
+//	@Tags			shares
+//	@Produce		octet-stream,json
+//	@Param			token				path		string	true	"Share token"
+//	@Param			X-Share-Password	header		string	false	"share password"
+//	@Success		200					{object}	models.SharedFolderContents	"folder shares"
+//	@Failure		401,404,410,500		{object}	map[string]string
+//	@Router			/s/{token} [get]
+func (h *ShareHandler) PublicShareHandler(c *fiber.Ctx) error {
+	sh, err := h.resolve(c)
+	if err != nil {
+		return err
+	}
+
+	if sh.FolderID != nil {
+		out, err := h.shares.ListShared(c.Context(), sh)
+		if err != nil {
+			return shareError(err)
+		}
+		return c.JSON(out)
+	}
+	return h.sendShared(c, sh, "")
+}
+
+// PublicSharedFileHandler godoc
+//
+//	@Summary		Download a file from a shared folder
+//	@Tags			shares
+//	@Produce		octet-stream
+//	@Param			token				path		string	true	"Share token"
+//	@Param			fileID				path		string	true	"File ID"
+//	@Param			X-Share-Password	header		string	false	"share password"
+//	@Success		200
+//	@Failure		401,404,410,500	{object}	map[string]string
+//	@Router			/s/{token}/files/{fileID} [get]
+func (h *ShareHandler) PublicSharedFileHandler(c *fiber.Ctx) error {
+	sh, err := h.resolve(c)
+	if err != nil {
+		return err
+	}
+	return h.sendShared(c, sh, c.Params("fileID"))
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+// UploadHandler speaks the tus 1.0 resumable upload protocol (https://tus.io/protocols/resumable-upload).
+type UploadHandler struct {
+	uploads service.ResumableUploads
+}
+
+func NewUploadHandler(uploads service.ResumableUploads) *UploadHandler {
+	return &UploadHandler{uploads: uploads}
+}
+
+// RequireTusResumable rejects requests that don't speak our tus version. OPTIONS is exempt per the spec.
+func RequireTusResumable(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Method() == fiber.MethodOptions {
+		return c.Next()
+	}
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return fiber.NewError(fiber.StatusPreconditionFailed, "unsupported tus version")
+	}
+	return c.Next()
+}
+
+func tusError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "upload not found")
+	case errors.Is(err, service.ErrUploadExpired):
+		return fiber.NewError(fiber.StatusGone, "upload expired")
+	case errors.Is(err, service.ErrUploadOffsetMismatch), errors.Is(err, repo.ErrOffsetConflict):
+		return fiber.NewError(fiber.StatusConflict, "upload offset mismatch")
+	case errors.Is(err, repo.ErrNameConflict):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrFileTooLarge):
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, repo.ErrQuotaExceeded):
+		return fiber.NewError(fiber.StatusInsufficientStorage, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "upload failed: "+err.Error())
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated "key base64value" pairs.
+func parseUploadMetadata(h string) (map[string]string, error) {
+	out := map[string]string{}
+	if strings.TrimSpace(h) == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(h, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if kv[0] == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		if len(kv) == 1 {
+			out[kv[0]] = ""
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		out[kv[0]] = string(v)
+	}
+	return out, nil
+}
+
+func setUploadHeaders(c *fiber.Ctx, u *models.UploadSession) {
+	c.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Set("Upload-Expires", u.ExpiresAt.UTC().Format(time.RFC1123))
+}
+
+// OptionsHandler godoc
+//
+//	@Summary		tus capabilities
+//	@Description	Advertises the supported tus version and extensions
+//	@Tags			uploads
+//	@Security		BearerAuth
+//	@Success		204
+//	@Router			/me/files/uploads [options]
+func (h *UploadHandler) OptionsHandler(c *fiber.Ctx) error {
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// CreateUploadHandler godoc
+//
+//	@Summary		Start a resumable upload
+//	@Description	Creates a tus upload session; requires Upload-Length, accepts Upload-Metadata (filename, filetype)
+//	@Tags			uploads
+//	@Security		BearerAuth
+//	@Param			Upload-Length	header	int		true	"total size in bytes"
+//	@Param			Upload-Metadata	header	string	false	"tus metadata"
+//	@Success		201
+//	@Failure		400,401,412,500	{object}	map[string]string
+//	@Router			/me/files/uploads [post]
+func (h *UploadHandler) CreateUploadHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Get("Upload-Defer-Length") != "" {
+		return fiber.NewError(fiber.StatusBadRequest, "deferred length is not supported")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Length")
+	}
+
+	rawMeta := c.Get("Upload-Metadata")
+	meta, err := parseUploadMetadata(rawMeta)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Metadata")
+	}
+	name := meta["filename"]
+	if name == "" {
+		name = meta["name"]
+	}
+	if name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "filename metadata is required")
+	}
+	ct := meta["filetype"]
+	if ct == "" {
+		ct = meta["type"]
+	}
+
+	u, f, err := h.uploads.CreateUpload(c.Context(), userID, name, ct, length, rawMeta)
+	if err != nil {
+		return tusError(err)
+	}
+
+	c.Set("Location", c.BaseURL()+strings.TrimSuffix(c.Path(), "/")+"/"+u.ID)
+	if f != nil {
+		c.Set("Upload-Offset", "0")
+		c.Set("X-File-ID", f.ID)
+	} else {
+		c.Set("Upload-Expires", u.ExpiresAt.UTC().Format(time.RFC1123))
+	}
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// HeadUploadHandler godoc
+//
+//	@Summary		Upload status
+//	@Description	Reports how many bytes of a resumable upload the server has
+//	@Tags			uploads
+//	@Security		BearerAuth
+//	@Param			uploadID	path	string	true	"Upload ID"
+//	@Success		200
+//	@Failure		404,410	{object}	map[string]string
+//	@Router			/me/files/uploads/{uploadID} [head]
+func (h *UploadHandler) HeadUploadHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	u, err := h.uploads.GetUpload(c.Context(), userID, c.Params("uploadID"))
+	if err != nil {
+		return tusError(err)
+	}
+	setUploadHeaders(c, u)
+	c.Set("Cache-Control", "no-store")
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// PatchUploadHandler godoc
+//
+//	@Summary		Append to an upload
+//	@Description	Writes the request body at Upload-Offset; once the last byte arrives the file is created and its ID returned in X-File-ID
+//	@Tags			uploads
+//	@Security		BearerAuth
+//	@Accept			application/offset+octet-stream
+//	@Param			uploadID		path	string	true	"Upload ID"
+//	@Param			Upload-Offset	header	int		true	"current offset"
+//	@Success		204
+//	@Failure		400,404,409,410,413,415,500	{object}	map[string]string
+//	@Router			/me/files/uploads/{uploadID} [patch]
+func (h *UploadHandler) PatchUploadHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "content type must be application/offset+octet-stream")
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Offset")
+	}
+
+	u, err := h.uploads.GetUpload(c.Context(), userID, c.Params("uploadID"))
+	if err != nil {
+		return tusError(err)
+	}
+	if cl := int64(c.Request().Header.ContentLength()); cl > 0 && offset+cl > u.Length {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "chunk exceeds Upload-Length")
+	}
+
+	var body io.Reader = c.Request().BodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	u, f, err := h.uploads.WriteUpload(c.Context(), userID, u.ID, offset, body)
+	if err != nil {
+		return tusError(err)
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if f != nil {
+		c.Set("X-File-ID", f.ID)
+	} else {
+		c.Set("Upload-Expires", u.ExpiresAt.UTC().Format(time.RFC1123))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TerminateUploadHandler godoc
+//
+//	@Summary		Cancel an upload
+//	@Description	Aborts a resumable upload and discards the bytes received so far
+//	@Tags			uploads
+//	@Security		BearerAuth
+//	@Param			uploadID	path	string	true	"Upload ID"
+//	@Success		204
+//	@Failure		404,500	{object}	map[string]string
+//	@Router			/me/files/uploads/{uploadID} [delete]
+func (h *UploadHandler) TerminateUploadHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.uploads.TerminateUpload(c.Context(), userID, c.Params("uploadID")); err != nil {
+		return tusError(err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type UsageHandler struct {
+	quotas *service.QuotaPolicy
+}
+
+func NewUsageHandler(quotas *service.QuotaPolicy) *UsageHandler {
+	return &UsageHandler{quotas: quotas}
+}
+
+// GetUsageHandler godoc
+//
+//	@Summary		My storage usage
+//	@Description	Reports bytes stored (all versions, trash included), file count and what's left of the quota
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Success		200	{object}	models.Usage
+//	@Failure		401,500	{object}	map[string]string
+//	@Router			/me/usage [get]
+func (h *UsageHandler) GetUsageHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	usage, err := h.quotas.Usage(c.Context(), userID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "usage lookup failed: "+err.Error())
+	}
+	return c.JSON(usage)
+}
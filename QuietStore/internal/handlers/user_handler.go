@@ -6,20 +6,40 @@ import (
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	users repo.Users
+	users     repo.Users
+	refresh   repo.RefreshTokens
+	passwords service.PasswordHasher
+	policy    *service.PasswordPolicy
+	denylist  *service.AccessTokenDenylist
 }
 
-func NewUserHandler(users repo.Users) *UserHandler {
-	return &UserHandler{users: users}
+func NewUserHandler(users repo.Users, refresh repo.RefreshTokens, passwords service.PasswordHasher, policy *service.PasswordPolicy, denylist *service.AccessTokenDenylist) *UserHandler {
+	return &UserHandler{users: users, refresh: refresh, passwords: passwords, policy: policy, denylist: denylist}
 }
 
+// authorizeUser lets admins at every account and everyone else only at their own.
+func authorizeUser(c *fiber.Ctx, id string) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	if userID != id && resolveRole(c) != models.RoleAdmin {
+		return fiber.NewError(fiber.StatusForbidden, "not allowed to access this user")
+	}
+	return nil
+}
+
+// GET /api/v1/users/:id
 func (h *UserHandler) GetUserByIDHandler(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if err := authorizeUser(c, id); err != nil {
+		return err
+	}
 	u, err := h.users.ByID(c.Context(), id)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "lookup failed: "+err.Error())
@@ -28,10 +48,12 @@ func (h *UserHandler) GetUserByIDHandler(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, "user not found")
 	}
 	return c.JSON(fiber.Map{
-		"id":         u.ID,
-		"username":   u.Username,
-		"email":      u.Email,
-		"created_at": u.CreatedAt,
+		"id":                u.ID,
+		"username":          u.Username,
+		"email":             u.Email,
+		"email_verified_at": u.EmailVerifiedAt,
+		"role":              u.Role,
+		"created_at":        u.CreatedAt,
 	})
 }
 
@@ -48,8 +70,11 @@ func (h *UserHandler) CreateUserHandler(c *fiber.Ctx) error {
 	if input.Username == "" || input.Password == "" {
 		return fiber.NewError(fiber.StatusBadRequest, "missing username or password")
 	}
+	if err := h.policy.Check(input.Password, input.Username, input.Email); err != nil {
+		return passwordPolicyError(c, err)
+	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hash, err := h.passwords.Hash(input.Password)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "hash failed")
 	}
@@ -58,7 +83,8 @@ func (h *UserHandler) CreateUserHandler(c *fiber.Ctx) error {
 		ID:        models.GenerateUserID(),
 		Username:  input.Username,
 		Email:     input.Email,
-		Password:  string(hash),
+		Password:  hash,
+		Role:      models.RoleMember,
 		CreatedAt: time.Now(),
 	}
 	if err := h.users.Create(c.Context(), u); err != nil {
@@ -69,13 +95,21 @@ func (h *UserHandler) CreateUserHandler(c *fiber.Ctx) error {
 		"id":         u.ID,
 		"username":   u.Username,
 		"email":      u.Email,
+		"role":       u.Role,
 		"created_at": u.CreatedAt,
 	})
 }
 
 // PATCH /api/v1/users/:id
+// Members can update their own account; role and quota_bytes are admin only.
+// A new role or password ends every session of the user, since access tokens
+// carry the role and whoever knew the old password may hold one.
 func (h *UserHandler) UpdateUserHandler(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if err := authorizeUser(c, id); err != nil {
+		return err
+	}
+	isAdmin := resolveRole(c) == models.RoleAdmin
 	u, err := h.users.ByID(c.Context(), id)
 	if err != nil {
 		return fiber.NewError(500, "lookup failed: "+err.Error())
@@ -85,9 +119,12 @@ func (h *UserHandler) UpdateUserHandler(c *fiber.Ctx) error {
 	}
 
 	var body struct {
-		Username *string `json:"username"`
-		Email    *string `json:"email"`
-		Password *string `json:"password"`
+		Username    *string `json:"username"`
+		Email       *string `json:"email"`
+		Password    *string `json:"password"`
+		Role        *string `json:"role"`
+		MaxVersions *int    `json:"max_versions"`
+		QuotaBytes  *int64  `json:"quota_bytes"`
 	}
 	if err := c.BodyParser(&body); err != nil {
 		return fiber.NewError(400, "invalid body")
@@ -99,28 +136,80 @@ func (h *UserHandler) UpdateUserHandler(c *fiber.Ctx) error {
 	if body.Email != nil {
 		u.Email = *body.Email
 	}
+	if body.MaxVersions != nil {
+		if *body.MaxVersions < 0 {
+			return fiber.NewError(400, "max_versions must be zero (unlimited) or more")
+		}
+		u.MaxVersions = body.MaxVersions
+	}
+	logout := false
+	if body.Role != nil && *body.Role != u.Role {
+		if !isAdmin {
+			return fiber.NewError(fiber.StatusForbidden, "only admins can change roles")
+		}
+		if !models.ValidRole(*body.Role) {
+			return fiber.NewError(400, "role must be admin, member or read-only")
+		}
+		if callerID, _ := resolveUserID(c); callerID == u.ID {
+			return fiber.NewError(400, "admins cannot change their own role")
+		}
+		u.Role = *body.Role
+		logout = true
+	}
+	if body.QuotaBytes != nil {
+		if !isAdmin {
+			return fiber.NewError(fiber.StatusForbidden, "only admins can change quotas")
+		}
+		if *body.QuotaBytes < 0 {
+			return fiber.NewError(400, "quota_bytes must be zero (unlimited) or more")
+		}
+		u.QuotaBytes = body.QuotaBytes
+	}
 	if body.Password != nil && *body.Password != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(*body.Password), bcrypt.DefaultCost)
+		// checked against the username and email as they are after this update
+		if err := h.policy.Check(*body.Password, u.Username, u.Email); err != nil {
+			return passwordPolicyError(c, err)
+		}
+		hash, err := h.passwords.Hash(*body.Password)
 		if err != nil {
 			return fiber.NewError(500, "hash failed")
 		}
-		u.Password = string(hash)
+		u.Password = hash
+		logout = true
 	}
 
 	if err := h.users.Update(c.Context(), u); err != nil {
 		return fiber.NewError(500, "update failed: "+err.Error())
 	}
+	if logout {
+		if err := h.denylist.RevokeUser(c.Context(), u.ID); err != nil {
+			return fiber.NewError(500, "failed to revoke access tokens")
+		}
+		if _, err := h.refresh.RevokeAllSessions(c.Context(), u.ID); err != nil {
+			return fiber.NewError(500, "failed to revoke sessions")
+		}
+	}
 
 	return c.JSON(fiber.Map{
-		"id":       u.ID,
-		"username": u.Username,
-		"email":    u.Email,
+		"id":           u.ID,
+		"username":     u.Username,
+		"email":        u.Email,
+		"role":         u.Role,
+		"max_versions": u.MaxVersions,
+		"quota_bytes":  u.QuotaBytes,
 	})
 }
 
 // DELETE /api/v1/users/:id
 func (h *UserHandler) DeleteUserHandler(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if callerID, _ := resolveUserID(c); callerID == id {
+		return fiber.NewError(400, "admins cannot delete their own account")
+	}
+	// before the delete takes the refresh tokens, which name the access tokens
+	if err := h.denylist.RevokeUser(c.Context(), id); err != nil {
+		return fiber.NewError(500, "failed to revoke access tokens")
+	}
 	if err := h.users.Delete(c.Context(), id); err != nil {
 		return fiber.NewError(404, "user not found")
 	}
@@ -143,6 +232,7 @@ func (h *UserHandler) GetAllUsersHandler(c *fiber.Ctx) error {
 			"id":         u.ID,
 			"username":   u.Username,
 			"email":      u.Email,
+			"role":       u.Role,
 			"created_at": u.CreatedAt,
 		})
 	}
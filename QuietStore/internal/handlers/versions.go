@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+func versionError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrFileNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	case errors.Is(err, service.ErrVersionNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "version not found")
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "version lookup failed: "+err.Error())
+	}
+}
+
+func versionParam(c *fiber.Ctx) (int, error) {
+	n, err := strconv.Atoi(c.Params("version"))
+	if err != nil || n < 1 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid version")
+	}
+	return n, nil
+}
+
+// ListVersionsHandler godoc
+//
+//	@Summary		List file versions
+//	@Description	Returns every retained version of a file, oldest first
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID"
+//	@Success		200		{array}		models.FileVersion
+//	@Failure		401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/versions [get]
+func (h *FileHandler) ListVersionsHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := h.storage.ListVersions(c.Context(), userID, c.Params("fileID"))
+	if err != nil {
+		return versionError(err)
+	}
+	if list == nil {
+		list = []*models.FileVersion{}
+	}
+	return c.JSON(list)
+}
+
+// GetVersionHandler godoc
+//
+//	@Summary		Download a file version
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		octet-stream
+//	@Param			fileID	path	string	true	"File ID"
+//	@Param			version	path	int		true	"Version number"
+//	@Success		200
+//	@Failure		400,401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/versions/{version} [get]
+func (h *FileHandler) GetVersionHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	n, err := versionParam(c)
+	if err != nil {
+		return err
+	}
+
+	fileID := c.Params("fileID")
+	meta, err := h.storage.StatFile(c.Context(), userID, fileID)
+	if err != nil {
+		return versionError(err)
+	}
+	if meta == nil {
+		return versionError(service.ErrFileNotFound)
+	}
+	v, rc, err := h.storage.OpenFileVersion(c.Context(), userID, fileID, n)
+	if err != nil {
+		return versionError(err)
+	}
+
+	if v.ContentType != "" {
+		c.Set(fiber.HeaderContentType, v.ContentType)
+	}
+	if v.SHA256 != "" {
+		c.Set(fiber.HeaderETag, `"`+v.SHA256+`"`)
+	}
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, meta.OriginalName))
+	return c.SendStream(rc, int(v.SizeBytes))
+}
+
+// PromoteVersionHandler godoc
+//
+//	@Summary		Roll back to a version
+//	@Description	Makes an older version the current contents of the file
+//	@Tags			files
+//	@Security		BearerAuth
+//	@Produce		json
+//	@Param			fileID	path		string	true	"File ID"
+//	@Param			version	path		int		true	"Version number"
+//	@Success		200		{object}	models.FileMeta
+//	@Failure		400,401,404,500	{object}	map[string]string
+//	@Router			/me/files/{fileID}/versions/{version}/promote [post]
+func (h *FileHandler) PromoteVersionHandler(c *fiber.Ctx) error {
+	userID, err := resolveUserID(c)
+	if err != nil {
+		return err
+	}
+	n, err := versionParam(c)
+	if err != nil {
+		return err
+	}
+
+	f, err := h.storage.PromoteVersion(c.Context(), userID, c.Params("fileID"), n)
+	if err != nil {
+		return versionError(err)
+	}
+	return c.JSON(f)
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What an account token is for.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+// AccountToken is a mailed, single-use token. Email is the address it was sent
+// to, so a verification doesn't carry over to an address changed since.
+type AccountToken struct {
+	ID        string
+	UserID    string
+	Purpose   string
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" example:"alice@example.com"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password" example:"n3w-s3cr3t"`
+}
+
+func GenerateAccountTokenID() string {
+	return "AccountToken_" + uuid.NewString()
+}
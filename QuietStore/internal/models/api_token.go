@@ -0,0 +1,52 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes an API token can be granted. Read scopes cover GET and HEAD, write
+// scopes everything else on the same routes.
+const (
+	ScopeFilesRead   = "files:read"
+	ScopeFilesWrite  = "files:write"
+	ScopeSharesRead  = "shares:read"
+	ScopeSharesWrite = "shares:write"
+)
+
+var Scopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeSharesRead, ScopeSharesWrite}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// APIToken is a personal access token a user creates for automation. The
+// token itself is only returned when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"                 example:"ci-backup"`
+	Scopes    []string   `json:"scopes"               example:"files:read,files:write"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2026-01-01T00:00:00Z"`
+}
+
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+func GenerateAPITokenID() string {
+	return "Token_" + uuid.New().String()
+}
@@ -0,0 +1,8 @@
+package models
+
+// DataKey is a wrapped data key as stored on one row of Table.
+type DataKey struct {
+	Table   string
+	RowID   string
+	Wrapped []byte
+}
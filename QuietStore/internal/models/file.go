@@ -7,15 +7,37 @@ import (
 )
 
 type File struct {
-	ID           string     `json:"id"`
-	OwnerUserID  string     `json:"owner_user_id"`
-	ObjectKey    string     `json:"object_key"`
-	OriginalName string     `json:"original_name"`
-	SizeBytes    int64      `json:"size_bytes"`
-	ContentType  string     `json:"content_type,omitempty"`
-	SHA256       string     `json:"sha256,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	ID           string  `json:"id"`
+	OwnerUserID  string  `json:"owner_user_id"`
+	FolderID     *string `json:"folder_id,omitempty"` // nil at the root
+	ObjectKey    string  `json:"object_key"`
+	OriginalName string  `json:"original_name"`
+	SizeBytes    int64   `json:"size_bytes"`
+	ContentType  string  `json:"content_type,omitempty"`
+	SHA256       string  `json:"sha256,omitempty"`
+	BlobSHA256   *string `json:"-"` // set when ObjectKey is a shared blob
+	WrappedKey   []byte  `json:"-"` // set when the object is encrypted at rest
+	// CurrentVersion is the version whose object the fields above describe.
+	CurrentVersion int       `json:"current_version"`
+	CreatedAt      time.Time `json:"created_at"`
+	// UpdatedAt is when the current version was saved or promoted.
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// FileVersion is one stored revision of a file's contents.
+type FileVersion struct {
+	ID          string    `json:"id"`
+	FileID      string    `json:"file_id"`
+	Version     int       `json:"version"`
+	ObjectKey   string    `json:"object_key"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ContentType string    `json:"content_type,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	BlobSHA256  *string   `json:"-"`
+	WrappedKey  []byte    `json:"-"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 type FileMeta struct {
@@ -27,6 +49,21 @@ type FileMeta struct {
 	CreatedAt   time.Time `json:"created_at" example:"2025-08-26T05:53:20Z"`
 }
 
+// Blob is a content-addressed object referenced by RefCount file versions.
+type Blob struct {
+	SHA256    string `json:"sha256"`
+	ObjectKey string `json:"object_key"`
+	SizeBytes int64  `json:"size_bytes"`
+	RefCount  int64  `json:"ref_count"`
+	// WrappedKey is the data key the object is encrypted with, if any.
+	WrappedKey []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 func GenerateFileID() string {
 	return "File_" + uuid.New().String()
 }
+
+func GenerateVersionID() string {
+	return "Version_" + uuid.New().String()
+}
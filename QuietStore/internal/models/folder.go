@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Folder struct {
+	ID          string  `json:"id"`
+	OwnerUserID string  `json:"owner_user_id"`
+	ParentID    *string `json:"parent_id,omitempty"`
+	Name        string  `json:"name"`
+	// Path is only set in recursive listings, relative to the listed folder.
+	Path      string    `json:"path,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FolderContents is a folder listing. Folder is nil for the root.
+type FolderContents struct {
+	Folder  *Folder   `json:"folder,omitempty"`
+	Folders []*Folder `json:"folders"`
+	Files   []*File   `json:"files"`
+}
+
+func GenerateFolderID() string {
+	return "Folder_" + uuid.New().String()
+}
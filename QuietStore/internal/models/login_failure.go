@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginFailures counts failed logins for a username. LockedUntil is when the
+// next attempt is allowed, after a backoff delay or a lockout.
+type LoginFailures struct {
+	Username     string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTP is a user's authenticator app secret. It is pending until the user
+// confirms it with a code.
+type TOTP struct {
+	UserID    string
+	Secret    []byte
+	EnabledAt *time.Time
+	// LastStep is the time step of the last accepted code.
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.EnabledAt != nil
+}
+
+// MFAChallenge is handed out by the first login step to a user with MFA on and
+// redeemed with a code in the second.
+type MFAChallenge struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type MFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"      example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	URI    string `json:"otpauth_uri" example:"otpauth://totp/QuietStore:alice?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=QuietStore"`
+}
+
+type RecoveryCodesResponse struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFACodeRequest carries either a TOTP code or a recovery code.
+type MFACodeRequest struct {
+	Code string `json:"code" example:"123456"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code" example:"123456"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func GenerateRecoveryCodeID() string {
+	return "Recovery_" + uuid.NewString()
+}
+
+func GenerateMFAChallengeID() string {
+	return "Challenge_" + uuid.NewString()
+}
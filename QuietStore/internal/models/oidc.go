@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LinkedIdentity ties a user to their account at an OpenID Connect provider.
+// Provider is the provider's issuer URL.
+type LinkedIdentity struct {
+	ID          string
+	UserID      string
+	Provider    string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// OIDCLogin is a login waiting for the provider to redirect back.
+type OIDCLogin struct {
+	ID           string
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+func GenerateLinkedIdentityID() string {
+	return "Identity_" + uuid.NewString()
+}
+
+func GenerateOIDCLoginID() string {
+	return "OIDCLogin_" + uuid.NewString()
+}
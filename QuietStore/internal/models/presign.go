@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// PresignedUpload is a file the client was given a presigned PUT URL for but
+// hasn't confirmed yet. Its ID becomes the file ID once confirmed.
+type PresignedUpload struct {
+	ID           string    `json:"file_id"`
+	OwnerUserID  string    `json:"owner_user_id"`
+	ObjectKey    string    `json:"object_key"`
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type,omitempty"`
+	SizeBytes    int64     `json:"size_bytes"`
+	SHA256       string    `json:"sha256"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type PresignUploadRequest struct {
+	Filename    string `json:"filename"     example:"report.pdf"`
+	ContentType string `json:"content_type" example:"application/pdf"`
+	Size        int64  `json:"size"         example:"102400"`
+	SHA256      string `json:"sha256"       example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+type PresignedURLResponse struct {
+	FileID    string            `json:"file_id,omitempty" example:"File_2b1c..."`
+	Method    string            `json:"method"            example:"PUT"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RefreshToken is one link in a refresh token family. A login starts a family
+// and each refresh revokes the presented token and adds its child. The access
+// token issued along with it has the token's ID as jti and expires at
+// AccessExpiresAt.
+type RefreshToken struct {
+	ID              string
+	UserID          string
+	FamilyID        string
+	ParentID        *string
+	TokenHash       string
+	IssuedAt        time.Time
+	ExpiresAt       time.Time
+	AccessExpiresAt *time.Time
+	RevokedAt       *time.Time
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RevokedAccessToken denylists an access JWT by its jti until it would have
+// expired anyway.
+type RevokedAccessToken struct {
+	JTI       string    `json:"jti"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
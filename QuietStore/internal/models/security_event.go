@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of security event.
+const (
+	// SecurityEventRefreshReuse is a revoked refresh token being presented
+	// again, which means it was copied. Its whole family gets revoked.
+	SecurityEventRefreshReuse = "refresh_token_reuse"
+	// SecurityEventAccountLocked is an account locked after too many failed
+	// logins.
+	SecurityEventAccountLocked = "account_locked"
+)
+
+type SecurityEvent struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Detail    string    `json:"detail,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func GenerateSecurityEventID() string {
+	return "Event_" + uuid.NewString()
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is a logged in device: one refresh token family, from the login
+// that started it to logout, revocation or expiry.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+	// Current marks the session the request was made from.
+	Current bool `json:"current"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Share is a public link to a file or a folder. Exactly one of FileID and
+// FolderID is set. The token itself is only returned when the share is created.
+type Share struct {
+	ID            string     `json:"id"`
+	OwnerUserID   string     `json:"owner_user_id"`
+	TokenHash     string     `json:"-"`
+	FileID        *string    `json:"file_id,omitempty"`
+	FolderID      *string    `json:"folder_id,omitempty"`
+	PasswordHash  string     `json:"-"`
+	HasPassword   bool       `json:"has_password"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads  *int       `json:"max_downloads,omitempty"`
+	DownloadCount int        `json:"download_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateShareRequest struct {
+	FileID       string     `json:"file_id,omitempty"   example:"File_2b1c..."`
+	FolderID     string     `json:"folder_id,omitempty" example:"Folder_2b1c..."`
+	Password     string     `json:"password,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" example:"2025-09-01T00:00:00Z"`
+	MaxDownloads *int       `json:"max_downloads,omitempty" example:"10"`
+}
+
+type CreateShareResponse struct {
+	Share
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// SharedFolderContents is what anonymous visitors of a folder share see. It
+// leaves out owner IDs and storage keys. Paths are relative to the shared
+// folder.
+type SharedFolderContents struct {
+	Folder  SharedFolder    `json:"folder"`
+	Folders []*SharedFolder `json:"folders"`
+	Files   []*SharedFile   `json:"files"`
+}
+
+type SharedFolder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SharedFile struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func GenerateShareID() string {
+	return "Share_" + uuid.New().String()
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadSession tracks a resumable (tus) upload that is being written into an
+// S3 multipart upload. Offset counts every byte acknowledged to the client,
+// including the tail that has not yet filled a whole part.
+type UploadSession struct {
+	ID           string    `json:"id"`
+	OwnerUserID  string    `json:"owner_user_id"`
+	FileID       string    `json:"file_id"`
+	ObjectKey    string    `json:"object_key"`
+	S3UploadID   string    `json:"-"`
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Length       int64     `json:"length"`
+	Offset       int64     `json:"offset"`
+	HashState    []byte    `json:"-"`
+	WrappedKey   []byte    `json:"-"` // data key the parts are encrypted with, if any
+	Metadata     string    `json:"metadata,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type UploadPart struct {
+	UploadID   string `json:"upload_id"`
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	SizeBytes  int64  `json:"size_bytes"`
+}
+
+func GenerateUploadID() string {
+	return "Upload_" + uuid.New().String()
+}
@@ -0,0 +1,10 @@
+package models
+
+// Usage reports how much a user stores. QuotaBytes and RemainingBytes are
+// omitted when the user has no quota.
+type Usage struct {
+	UsedBytes      int64  `json:"used_bytes"`
+	FileCount      int64  `json:"file_count"`
+	QuotaBytes     *int64 `json:"quota_bytes,omitempty"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+}
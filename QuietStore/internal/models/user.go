@@ -2,17 +2,36 @@ package models
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Roles a user can have. Every new account is a member.
+const (
+	RoleAdmin    = "admin"
+	RoleMember   = "member"
+	RoleReadOnly = "read-only"
+)
+
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember || role == RoleReadOnly
+}
+
 type User struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	// EmailVerifiedAt is cleared whenever Email changes.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Password        string     `json:"-"`
+	Role            string     `json:"role"`
+	// MaxVersions overrides the server-wide file version retention; nil means the default.
+	MaxVersions *int `json:"max_versions,omitempty"`
+	// QuotaBytes overrides the default storage quota; nil means the default, 0 unlimited.
+	QuotaBytes *int64    `json:"quota_bytes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 func (u *User) Validate() error {
@@ -25,6 +44,12 @@ func (u *User) Validate() error {
 	return nil
 }
 
+// NormalizeEmail is the form addresses are stored in. They are unique
+// regardless of case, and nobody expects two accounts for Alice@ and alice@.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func GenerateUserID() string {
 	return "User_" + uuid.New().String()
 }
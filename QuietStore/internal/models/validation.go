@@ -0,0 +1,16 @@
+package models
+
+// FieldError is one problem with one field of a request. Code is stable for
+// clients to act on, Message is for people.
+type FieldError struct {
+	Field   string `json:"field" example:"password"`
+	Code    string `json:"code" example:"too_short"`
+	Message string `json:"message" example:"must be at least 10 characters"`
+}
+
+// ValidationErrorResponse lists everything wrong with a request at once.
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Code   int          `json:"code"`
+	Errors []FieldError `json:"errors"`
+}
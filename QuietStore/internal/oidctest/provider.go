@@ -0,0 +1,285 @@
+// Package oidctest is a minimal OpenID Connect provider for tests and local
+// development. It serves discovery, authorization, token and JWKS endpoints,
+// approves every authorization request as its User without showing a login
+// page, and signs ID tokens with an RSA key generated at startup.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyID      = "oidctest"
+	idTokenTTL = 5 * time.Minute
+)
+
+// User is who the provider logs in.
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Provider's exported fields are read while serving requests, so set them
+// before the logins they are meant for, not during one.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	User         User
+	// DiscoveryIssuer is advertised in the discovery document instead of
+	// Issuer when set, as a misconfigured or impersonating provider would.
+	DiscoveryIssuer string
+	// Claims are set on ID tokens after the usual ones, so they can override
+	// nonce, aud, azp and the like. A nil value removes the claim.
+	Claims map[string]any
+
+	key    *rsa.PrivateKey
+	server *httptest.Server
+
+	mu    sync.Mutex
+	codes map[string]authorization // by code
+}
+
+type authorization struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	user        User
+	claims      map[string]any
+}
+
+// New makes a provider for issuer. Serve its Handler at that URL. An empty
+// clientSecret makes it accept public clients.
+func New(issuer, clientID, clientSecret string, user User) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		User:         user,
+		key:          key,
+		codes:        map[string]authorization{},
+	}, nil
+}
+
+// NewServer starts a provider on a local httptest server, with the server's
+// URL as its issuer. Close it when done.
+func NewServer(clientID, clientSecret string, user User) (*Provider, error) {
+	p, err := New("", clientID, clientSecret, user)
+	if err != nil {
+		return nil, err
+	}
+	p.server = httptest.NewServer(p.Handler())
+	p.Issuer = p.server.URL
+	return p, nil
+}
+
+// Close stops the server NewServer started.
+func (p *Provider) Close() {
+	if p.server != nil {
+		p.server.Close()
+	}
+}
+
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	return mux
+}
+
+// Authorize plays the browser: it opens authURL, as returned by
+// OIDCService.StartLogin, and returns the callback URL the provider
+// redirects to.
+func Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorize: %s", resp.Status)
+	}
+	return resp.Location()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.Issuer
+	if p.DiscoveryIssuer != "" {
+		issuer = p.DiscoveryIssuer
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// authorize approves the request straight away and redirects back with a code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		redirectURI: redirect.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		user:        p.User,
+		claims:      maps.Clone(p.Claims),
+	}
+	p.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	return id == p.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) == 1
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if !p.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	a, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || a.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != a.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"aud":            p.ClientID,
+		"sub":            a.user.Subject,
+		"email":          a.user.Email,
+		"email_verified": a.user.EmailVerified,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idTokenTTL).Unix(),
+	}
+	if a.nonce != "" {
+		claims["nonce"] = a.nonce
+	}
+	if a.user.PreferredUsername != "" {
+		claims["preferred_username"] = a.user.PreferredUsername
+	}
+	for k, v := range a.claims {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = keyID
+	idToken, err := t.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, models.JWKSet{Keys: []models.JWK{{
+		Kty: "RSA",
+		Kid: keyID,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
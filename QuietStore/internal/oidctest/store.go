@@ -0,0 +1,177 @@
+package oidctest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// Store keeps pending logins and linked identities in memory, in place of
+// repo.OIDCPGX.
+type Store struct {
+	mu         sync.Mutex
+	logins     map[string]*models.OIDCLogin
+	identities []*models.LinkedIdentity
+}
+
+func NewStore() *Store {
+	return &Store{logins: map[string]*models.OIDCLogin{}}
+}
+
+func (s *Store) CreateLogin(_ context.Context, l *models.OIDCLogin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins[l.StateHash] = l
+	return nil
+}
+
+func (s *Store) TakeLogin(_ context.Context, stateHash string, now time.Time) (*models.OIDCLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.logins[stateHash]
+	delete(s.logins, stateHash)
+	if !ok || !l.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return l, nil
+}
+
+func (s *Store) IdentityBySubject(_ context.Context, provider, subject string) (*models.LinkedIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, li := range s.identities {
+		if li.Provider == provider && li.Subject == subject {
+			return li, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Store) LinkIdentity(_ context.Context, li *models.LinkedIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities = append(s.identities, li)
+	return nil
+}
+
+func (s *Store) TouchIdentity(_ context.Context, id, email string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, li := range s.identities {
+		if li.ID == id {
+			li.LastLoginAt = now
+			if email != "" {
+				li.Email = email
+			}
+		}
+	}
+	return nil
+}
+
+// Identities returns the linked identities, for checking what a login linked.
+func (s *Store) Identities() []*models.LinkedIdentity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*models.LinkedIdentity(nil), s.identities...)
+}
+
+// Users is an in-memory repo.Users.
+type Users struct {
+	mu    sync.Mutex
+	users []*models.User
+}
+
+func (r *Users) Create(_ context.Context, u *models.User) error {
+	u.Email = models.NormalizeEmail(u.Email)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.users {
+		if other.Username == u.Username || other.Email == u.Email {
+			return errors.New("username or email already taken")
+		}
+	}
+	r.users = append(r.users, u)
+	return nil
+}
+
+func (r *Users) find(match func(*models.User) bool) *models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if match(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (r *Users) ByID(_ context.Context, id string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id }), nil
+}
+
+func (r *Users) ByUsername(_ context.Context, username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username }), nil
+}
+
+func (r *Users) ByEmail(_ context.Context, email string) (*models.User, error) {
+	email = models.NormalizeEmail(email)
+	return r.find(func(u *models.User) bool { return u.Email == email }), nil
+}
+
+func (r *Users) MarkEmailVerified(_ context.Context, id, email string) (bool, error) {
+	u := r.find(func(u *models.User) bool { return u.ID == id && u.Email == email })
+	if u == nil {
+		return false, nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	u.EmailVerifiedAt = &now
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *Users) SetPassword(_ context.Context, id, passwordHash string) error {
+	if u := r.find(func(u *models.User) bool { return u.ID == id }); u != nil {
+		r.mu.Lock()
+		u.Password = passwordHash
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+func (r *Users) Update(_ context.Context, u *models.User) error {
+	u.Email = models.NormalizeEmail(u.Email)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, other := range r.users {
+		if other.ID == u.ID {
+			r.users[i] = u
+		}
+	}
+	return nil
+}
+
+func (r *Users) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, u := range r.users {
+		if u.ID == id {
+			r.users = append(r.users[:i], r.users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *Users) List(_ context.Context, limit, offset int) ([]*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if offset >= len(r.users) {
+		return nil, nil
+	}
+	end := min(offset+limit, len(r.users))
+	return append([]*models.User(nil), r.users[offset:end]...), nil
+}
@@ -0,0 +1,17 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type AccountTokens interface {
+	Create(ctx context.Context, t *models.AccountToken) error
+	// Use marks a valid token used and returns it, or nil if it is unknown,
+	// expired or already used.
+	Use(ctx context.Context, purpose, tokenHash string, now time.Time) (*models.AccountToken, error)
+	// Invalidate uses up every outstanding token of the user for purpose.
+	Invalidate(ctx context.Context, userID, purpose string) error
+}
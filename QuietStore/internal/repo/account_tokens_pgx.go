@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AccountTokensPGX struct{ pool *pgxpool.Pool }
+
+func NewAccountTokensPGX(pool *pgxpool.Pool) *AccountTokensPGX {
+	return &AccountTokensPGX{pool: pool}
+}
+
+func (r *AccountTokensPGX) Create(ctx context.Context, t *models.AccountToken) error {
+	// expired tokens are cleared out here rather than by a purge job
+	if _, err := r.pool.Exec(ctx, `DELETE FROM account_tokens WHERE expires_at < $1`, t.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO account_tokens (id, user_id, purpose, token_hash, email, expires_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		t.ID, t.UserID, t.Purpose, t.TokenHash, t.Email, t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+func (r *AccountTokensPGX) Use(ctx context.Context, purpose, tokenHash string, now time.Time) (*models.AccountToken, error) {
+	var t models.AccountToken
+	err := r.pool.QueryRow(ctx, `
+		UPDATE account_tokens SET used_at = $3
+		WHERE purpose = $1 AND token_hash = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at`,
+		purpose, tokenHash, now).
+		Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.Email, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *AccountTokensPGX) Invalidate(ctx context.Context, userID, purpose string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE account_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose)
+	return err
+}
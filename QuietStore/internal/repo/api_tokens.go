@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type APITokens interface {
+	Create(ctx context.Context, t *models.APIToken) error
+	ListByUser(ctx context.Context, userID string) ([]*models.APIToken, error)
+	// Revoke reports false if the user has no such live token.
+	Revoke(ctx context.Context, id, userID string) (bool, error)
+	// Use looks up a live token by hash and records that it was used at now.
+	// It returns nil if the token is unknown, revoked or expired.
+	Use(ctx context.Context, tokenHash string, now time.Time) (*models.APIToken, error)
+}
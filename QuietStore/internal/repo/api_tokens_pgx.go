@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APITokensPGX struct{ pool *pgxpool.Pool }
+
+func NewAPITokensPGX(pool *pgxpool.Pool) *APITokensPGX { return &APITokensPGX{pool: pool} }
+
+const apiTokenColumns = `id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at, revoked_at`
+
+func scanAPIToken(row pgx.Row) (*models.APIToken, error) {
+	var t models.APIToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Scopes,
+		&t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt, &t.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *APITokensPGX) Create(ctx context.Context, t *models.APIToken) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		t.ID, t.UserID, t.Name, t.TokenHash, t.Scopes, t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+func (r *APITokensPGX) ListByUser(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		WHERE user_id=$1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (r *APITokensPGX) Revoke(ctx context.Context, id, userID string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE api_tokens SET revoked_at = NOW()
+		WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *APITokensPGX) Use(ctx context.Context, tokenHash string, now time.Time) (*models.APIToken, error) {
+	t, err := scanAPIToken(r.pool.QueryRow(ctx, `
+		UPDATE api_tokens SET last_used_at = $2
+		WHERE token_hash=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING `+apiTokenColumns, tokenHash, now))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
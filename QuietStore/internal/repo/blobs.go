@@ -0,0 +1,28 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// ErrBlobPending is returned by Acquire while another upload is still putting
+// the blob's object in place. The caller keeps its own copy instead.
+var ErrBlobPending = errors.New("blob is still being stored")
+
+// Blobs reference-counts content-addressed objects. A new blob's row is
+// committed as pending before its object is put in place, so the possibly long
+// copy holds neither a connection nor a row lock, and only counts as stored
+// once that finished.
+type Blobs interface {
+	// Acquire takes a reference on b, inserting it if it's new. For a new blob
+	// place is called to put the object at b.ObjectKey; if it fails the row
+	// is dropped again. For an existing one b is updated with its stored key
+	// and wrapped data key.
+	Acquire(ctx context.Context, b *models.Blob, place func(ctx context.Context) error) error
+	// Release drops a reference. When it was the last one, remove is called
+	// with the object key before the blob row goes away. Pending blobs are
+	// left alone.
+	Release(ctx context.Context, sha256 string, remove func(ctx context.Context, key string) error) error
+}
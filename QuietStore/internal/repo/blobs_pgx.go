@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BlobsPGX struct{ pool *pgxpool.Pool }
+
+func NewBlobsPGX(pool *pgxpool.Pool) *BlobsPGX { return &BlobsPGX{pool: pool} }
+
+// blobPlaceTimeout is how long a pending blob is left to the upload that
+// inserted it. After that it is taken to have died and the next upload of the
+// same content places the object itself.
+const blobPlaceTimeout = time.Hour
+
+func (r *BlobsPGX) Acquire(ctx context.Context, b *models.Blob, place func(ctx context.Context) error) error {
+	// pending comes back true when this call is the one to place the object,
+	// for a new row or one whose upload gave up
+	var (
+		pending bool
+		claimed time.Time
+	)
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO blobs (sha256, object_key, size_bytes, ref_count, wrapped_key, created_at, pending)
+		VALUES ($1, $2, $3, 1, $4, $5, TRUE)
+		ON CONFLICT (sha256) DO UPDATE SET
+			ref_count   = CASE WHEN blobs.pending THEN 1 ELSE blobs.ref_count + 1 END,
+			object_key  = CASE WHEN blobs.pending THEN EXCLUDED.object_key ELSE blobs.object_key END,
+			size_bytes  = CASE WHEN blobs.pending THEN EXCLUDED.size_bytes ELSE blobs.size_bytes END,
+			wrapped_key = CASE WHEN blobs.pending THEN EXCLUDED.wrapped_key ELSE blobs.wrapped_key END,
+			created_at  = CASE WHEN blobs.pending THEN EXCLUDED.created_at ELSE blobs.created_at END
+		WHERE NOT blobs.pending OR blobs.created_at < $6
+		RETURNING ref_count, object_key, wrapped_key, pending, created_at`,
+		b.SHA256, b.ObjectKey, b.SizeBytes, b.WrappedKey, b.CreatedAt, time.Now().Add(-blobPlaceTimeout),
+	).Scan(&b.RefCount, &b.ObjectKey, &b.WrappedKey, &pending, &claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrBlobPending
+	}
+	if err != nil || !pending {
+		return err
+	}
+
+	// created_at tells our claim apart from a later takeover
+	if err := place(ctx); err != nil {
+		_, _ = r.pool.Exec(context.WithoutCancel(ctx), `
+			DELETE FROM blobs WHERE sha256 = $1 AND pending AND created_at = $2`, b.SHA256, claimed)
+		return err
+	}
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE blobs SET pending = FALSE
+		WHERE sha256 = $1 AND pending AND created_at = $2`, b.SHA256, claimed)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("blob %s: placing the object took longer than %s", b.SHA256, blobPlaceTimeout)
+	}
+	return nil
+}
+
+func (r *BlobsPGX) Release(ctx context.Context, sha256 string, remove func(ctx context.Context, key string) error) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		refs int64
+		key  string
+	)
+	err = tx.QueryRow(ctx, `
+		UPDATE blobs SET ref_count = ref_count - 1
+		WHERE sha256 = $1 AND NOT pending
+		RETURNING ref_count, object_key`, sha256).Scan(&refs, &key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if refs <= 0 {
+		// the object goes last, still under the row lock, so nothing can take
+		// a new reference between its removal and the commit
+		if _, err := tx.Exec(ctx, `DELETE FROM blobs WHERE sha256 = $1`, sha256); err != nil {
+			return err
+		}
+		if err := remove(ctx, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// DataKeys reaches the wrapped data keys stored next to encrypted objects in
+// files, file_versions, blobs and upload_sessions, for master key rotation.
+type DataKeys interface {
+	// Stale returns up to limit wrapped keys that don't start with masterKeyID.
+	Stale(ctx context.Context, masterKeyID []byte, limit int) ([]*models.DataKey, error)
+	// Rewrap replaces k's wrapped key, unless the row changed since it was read.
+	Rewrap(ctx context.Context, k *models.DataKey, wrapped []byte) (bool, error)
+}
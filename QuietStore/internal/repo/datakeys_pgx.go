@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DataKeysPGX struct{ pool *pgxpool.Pool }
+
+func NewDataKeysPGX(pool *pgxpool.Pool) *DataKeysPGX { return &DataKeysPGX{pool: pool} }
+
+// dataKeyTables maps every table holding wrapped keys to its primary key column.
+var dataKeyTables = map[string]string{
+	"files":           "id",
+	"file_versions":   "id",
+	"blobs":           "sha256",
+	"upload_sessions": "id",
+}
+
+func (r *DataKeysPGX) Stale(ctx context.Context, masterKeyID []byte, limit int) ([]*models.DataKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT t, id, wrapped_key FROM (
+			SELECT 'files' AS t, id, wrapped_key FROM files
+			UNION ALL SELECT 'file_versions', id, wrapped_key FROM file_versions
+			UNION ALL SELECT 'blobs', sha256, wrapped_key FROM blobs
+			UNION ALL SELECT 'upload_sessions', id, wrapped_key FROM upload_sessions
+		) k
+		WHERE wrapped_key IS NOT NULL AND substring(wrapped_key from 1 for $2) <> $1
+		LIMIT $3`, masterKeyID, len(masterKeyID), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.DataKey
+	for rows.Next() {
+		var k models.DataKey
+		if err := rows.Scan(&k.Table, &k.RowID, &k.Wrapped); err != nil {
+			return nil, err
+		}
+		out = append(out, &k)
+	}
+	return out, rows.Err()
+}
+
+func (r *DataKeysPGX) Rewrap(ctx context.Context, k *models.DataKey, wrapped []byte) (bool, error) {
+	idColumn, ok := dataKeyTables[k.Table]
+	if !ok {
+		return false, fmt.Errorf("no data keys in table %q", k.Table)
+	}
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE `+k.Table+` SET wrapped_key = $1
+		 WHERE `+idColumn+` = $2 AND wrapped_key = $3`,
+		wrapped, k.RowID, k.Wrapped)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
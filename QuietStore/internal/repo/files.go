@@ -2,15 +2,30 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 )
 
 type Files interface {
-	Create(ctx context.Context, f *models.File) error
+	// Create inserts a file, failing with ErrQuotaExceeded if it takes the owner
+	// past quota (zero or less for no limit).
+	Create(ctx context.Context, f *models.File, quota int64) error
 	ByID(ctx context.Context, id string) (*models.File, error)
 	ListByOwner(ctx context.Context, ownerID string, limit, offset int) ([]*models.File, error)
+	// Delete removes a file and all its versions for good.
 	Delete(ctx context.Context, id string, ownerID string) error
 	ListByFilters(ctx context.Context, userID string, q string, contentType string, minSize, maxSize int64, limit, offset int) ([]*models.File, error)
 	UpdateOriginalName(ctx context.Context, fileID, userID, newName string) error
+	SoftDelete(ctx context.Context, id string, ownerID string) (bool, error)
+	Restore(ctx context.Context, id string, ownerID string) (bool, error)
+	ListTrash(ctx context.Context, ownerID string, limit, offset int) ([]*models.File, error)
+	ListDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*models.File, error)
+	// ByName returns the live file called name in a folder (nil parentID for the root).
+	ByName(ctx context.Context, ownerID string, folderID *string, name string) (*models.File, error)
+	ListInFolder(ctx context.Context, ownerID string, folderID *string) ([]*models.File, error)
+	// ListInSubtree returns the live files in a folder and all of its subfolders.
+	ListInSubtree(ctx context.Context, ownerID string, folderID *string) ([]*models.File, error)
+	// Move puts a live file into another folder. It reports false if there was no live file to move.
+	Move(ctx context.Context, id, ownerID string, folderID *string) (bool, error)
 }
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -14,31 +16,90 @@ type FilesPGX struct{ pool *pgxpool.Pool }
 
 func NewFilesPGX(pool *pgxpool.Pool) *FilesPGX { return &FilesPGX{pool: pool} }
 
-func (r *FilesPGX) Create(ctx context.Context, f *models.File) error {
-	_, err := r.pool.Exec(ctx, `
-		INSERT INTO files (id, owner_user_id, object_key, original_name, size_bytes, content_type, sha256, created_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
-		f.ID, f.OwnerUserID, f.ObjectKey, f.OriginalName, f.SizeBytes, f.ContentType, f.SHA256, f.CreatedAt)
+const fileColumns = `id, owner_user_id, folder_id, object_key, original_name, size_bytes, content_type, sha256, blob_sha256, wrapped_key, current_version, created_at, updated_at, deleted_at`
+
+func scanFile(row pgx.Row) (*models.File, error) {
+	var f models.File
+	if err := row.Scan(&f.ID, &f.OwnerUserID, &f.FolderID, &f.ObjectKey, &f.OriginalName, &f.SizeBytes, &f.ContentType, &f.SHA256,
+		&f.BlobSHA256, &f.WrappedKey, &f.CurrentVersion, &f.CreatedAt, &f.UpdatedAt, &f.DeletedAt); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func collectFiles(rows pgx.Rows) ([]*models.File, error) {
+	defer rows.Close()
+
+	var out []*models.File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// insertFile writes a new files row together with its first version and
+// charges it to the owner's usage. Every path that creates a file (direct, tus,
+// presigned) goes through here, inside a transaction so the folder name claim
+// and the usage row lock hold until commit.
+func insertFile(ctx context.Context, db dbtx, f *models.File, quota int64) error {
+	if err := claimName(ctx, db, f.OwnerUserID, f.FolderID, f.OriginalName, f.ID); err != nil {
+		return err
+	}
+	if err := addUsage(ctx, db, f.OwnerUserID, f.SizeBytes, 1, quota); err != nil {
+		return err
+	}
+	f.CurrentVersion = 1
+	f.UpdatedAt = f.CreatedAt
+	if _, err := db.Exec(ctx, `
+		INSERT INTO files (id, owner_user_id, folder_id, object_key, original_name, size_bytes, content_type, sha256, blob_sha256, wrapped_key, current_version, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$12)`,
+		f.ID, f.OwnerUserID, f.FolderID, f.ObjectKey, f.OriginalName, f.SizeBytes, f.ContentType, f.SHA256, f.BlobSHA256,
+		f.WrappedKey, f.CurrentVersion, f.CreatedAt); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, `
+		INSERT INTO file_versions (id, file_id, version, object_key, size_bytes, content_type, sha256, blob_sha256, wrapped_key, uploaded_by, created_at)
+		VALUES ('Version_' || gen_random_uuid()::text, $1, 1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		f.ID, f.ObjectKey, f.SizeBytes, f.ContentType, f.SHA256, f.BlobSHA256, f.WrappedKey, f.OwnerUserID, f.CreatedAt)
 	return err
 }
 
+func (r *FilesPGX) Create(ctx context.Context, f *models.File, quota int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertFile(ctx, tx, f, quota); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func (r *FilesPGX) ByID(ctx context.Context, id string) (*models.File, error) {
-	row := r.pool.QueryRow(ctx, `
-		SELECT id, owner_user_id, object_key, original_name, size_bytes, content_type, sha256, created_at, deleted_at
-		FROM files WHERE id=$1`, id)
-	var f models.File
-	if err := row.Scan(&f.ID, &f.OwnerUserID, &f.ObjectKey, &f.OriginalName, &f.SizeBytes, &f.ContentType, &f.SHA256, &f.CreatedAt, &f.DeletedAt); err != nil {
+	f, err := scanFile(r.pool.QueryRow(ctx, `SELECT `+fileColumns+` FROM files WHERE id=$1`, id))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &f, nil
+	return f, nil
 }
 
 func (r *FilesPGX) ListByOwner(ctx context.Context, ownerID string, limit, offset int) ([]*models.File, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, owner_user_id, object_key, original_name, size_bytes, content_type, sha256, created_at, deleted_at
+		SELECT `+fileColumns+`
 		FROM files
 		WHERE owner_user_id=$1 AND deleted_at IS NULL
 		ORDER BY created_at DESC
@@ -46,22 +107,36 @@ func (r *FilesPGX) ListByOwner(ctx context.Context, ownerID string, limit, offse
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var out []*models.File
-	for rows.Next() {
-		var f models.File
-		if err := rows.Scan(&f.ID, &f.OwnerUserID, &f.ObjectKey, &f.OriginalName, &f.SizeBytes, &f.ContentType, &f.SHA256, &f.CreatedAt, &f.DeletedAt); err != nil {
-			return nil, err
-		}
-		out = append(out, &f)
-	}
-	return out, rows.Err()
+	return collectFiles(rows)
 }
 
 func (r *FilesPGX) Delete(ctx context.Context, id string, ownerID string) error {
-	_, err := r.pool.Exec(ctx, `DELETE FROM files WHERE id=$1 AND owner_user_id=$2`, id, ownerID)
-	return err
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var freed int64
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(v.size_bytes), 0)
+		FROM files f LEFT JOIN file_versions v ON v.file_id = f.id
+		WHERE f.id = $1 AND f.owner_user_id = $2
+		GROUP BY f.id`, id, ownerID).Scan(&freed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	// file_versions rows go with it via ON DELETE CASCADE
+	if _, err := tx.Exec(ctx, `DELETE FROM files WHERE id=$1`, id); err != nil {
+		return err
+	}
+	if err := addUsage(ctx, tx, ownerID, -freed, -1, 0); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (r *FilesPGX) ListByFilters(
@@ -72,8 +147,7 @@ func (r *FilesPGX) ListByFilters(
 ) ([]*models.File, error) {
 
 	rows, err := r.pool.Query(ctx, `
-        SELECT id, owner_user_id, object_key, original_name, size_bytes,
-               content_type, sha256, created_at, deleted_at
+        SELECT `+fileColumns+`
         FROM files
         WHERE owner_user_id = $1
 		  AND deleted_at IS NULL
@@ -88,25 +162,195 @@ func (r *FilesPGX) ListByFilters(
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	out := make([]*models.File, 0)
-	for rows.Next() {
-		var f models.File
-		if err := rows.Scan(
-			&f.ID, &f.OwnerUserID, &f.ObjectKey, &f.OriginalName,
-			&f.SizeBytes, &f.ContentType, &f.SHA256, &f.CreatedAt, &f.DeletedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
-		}
-		out = append(out, &f)
+	out, err := collectFiles(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan failed: %w", err)
 	}
-	rows.Close()
-	return out, rows.Err()
+	if out == nil {
+		out = make([]*models.File, 0)
+	}
+	return out, nil
 }
 
 func (r *FilesPGX) UpdateOriginalName(ctx context.Context, fileID, userID, newName string) error {
-	_, err := r.pool.Exec(ctx, `
-		UPDATE files SET original_name = $1
-		WHERE id = $2 AND owner_user_id = $3 AND deleted_at IS NULL`,
-		newName, fileID, userID)
-	return err
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var folderID *string
+	err = tx.QueryRow(ctx, `
+		SELECT folder_id FROM files
+		WHERE id = $1 AND owner_user_id = $2 AND deleted_at IS NULL
+		FOR UPDATE`, fileID, userID).Scan(&folderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := claimName(ctx, tx, userID, folderID, newName, fileID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE files SET original_name = $1 WHERE id = $2`, newName, fileID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SoftDelete moves a file to the trash. It reports false if there was no live file to move.
+func (r *FilesPGX) SoftDelete(ctx context.Context, id string, ownerID string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE files SET deleted_at = NOW()
+		WHERE id = $1 AND owner_user_id = $2 AND deleted_at IS NULL`,
+		id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Restore takes a file back out of the trash, into the folder it was deleted
+// from (or the root if that folder is gone). It reports false if the file wasn't trashed.
+func (r *FilesPGX) Restore(ctx context.Context, id string, ownerID string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		name     string
+		folderID *string
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT original_name, folder_id FROM files
+		WHERE id = $1 AND owner_user_id = $2 AND deleted_at IS NOT NULL
+		FOR UPDATE`, id, ownerID).Scan(&name, &folderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := claimName(ctx, tx, ownerID, folderID, name, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE files SET deleted_at = NULL WHERE id = $1`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func (r *FilesPGX) ListTrash(ctx context.Context, ownerID string, limit, offset int) ([]*models.File, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE owner_user_id=$1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+		LIMIT $2 OFFSET $3`, ownerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return collectFiles(rows)
+}
+
+// ListDeletedBefore returns trashed files of every user whose retention window has passed.
+func (r *FilesPGX) ListDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*models.File, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		ORDER BY deleted_at
+		LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectFiles(rows)
+}
+
+func (r *FilesPGX) ByName(ctx context.Context, ownerID string, folderID *string, name string) (*models.File, error) {
+	// newest first, since files uploaded before folders existed may share a name
+	f, err := scanFile(r.pool.QueryRow(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE owner_user_id = $1 AND folder_id IS NOT DISTINCT FROM $2 AND original_name = $3 AND deleted_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1`, ownerID, folderID, name))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *FilesPGX) ListInFolder(ctx context.Context, ownerID string, folderID *string) ([]*models.File, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE owner_user_id = $1 AND folder_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL
+		ORDER BY original_name`, ownerID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return collectFiles(rows)
+}
+
+func (r *FilesPGX) ListInSubtree(ctx context.Context, ownerID string, folderID *string) ([]*models.File, error) {
+	if folderID == nil {
+		rows, err := r.pool.Query(ctx, `
+			SELECT `+fileColumns+`
+			FROM files
+			WHERE owner_user_id = $1 AND deleted_at IS NULL
+			ORDER BY original_name`, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		return collectFiles(rows)
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		WITH RECURSIVE tree AS (
+			SELECT id FROM folders WHERE id = $2 AND owner_user_id = $1
+			UNION ALL
+			SELECT f.id FROM folders f JOIN tree ON f.parent_id = tree.id
+		)
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE owner_user_id = $1 AND deleted_at IS NULL AND folder_id IN (SELECT id FROM tree)
+		ORDER BY original_name`, ownerID, *folderID)
+	if err != nil {
+		return nil, err
+	}
+	return collectFiles(rows)
+}
+
+func (r *FilesPGX) Move(ctx context.Context, id, ownerID string, folderID *string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	err = tx.QueryRow(ctx, `
+		SELECT original_name FROM files
+		WHERE id = $1 AND owner_user_id = $2 AND deleted_at IS NULL
+		FOR UPDATE`, id, ownerID).Scan(&name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := claimName(ctx, tx, ownerID, folderID, name, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE files SET folder_id = $1 WHERE id = $2`, folderID, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
 }
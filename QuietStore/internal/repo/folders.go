@@ -0,0 +1,33 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+var (
+	// ErrNameConflict is returned when a folder or live file with the same name
+	// already exists in the target folder.
+	ErrNameConflict   = errors.New("name already exists in folder")
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	// ErrFolderCycle is returned when a folder would be moved into its own subtree.
+	ErrFolderCycle = errors.New("folder cannot be moved into itself")
+)
+
+// Folders stores the folder tree. A nil parentID always means the root.
+type Folders interface {
+	Create(ctx context.Context, f *models.Folder) error
+	ByID(ctx context.Context, id string) (*models.Folder, error)
+	Child(ctx context.Context, ownerID string, parentID *string, name string) (*models.Folder, error)
+	Children(ctx context.Context, ownerID string, parentID *string) ([]*models.Folder, error)
+	// Subtree returns every folder below parentID, with Path set relative to it.
+	Subtree(ctx context.Context, ownerID string, parentID *string) ([]*models.Folder, error)
+	// Update renames and/or moves a folder.
+	Update(ctx context.Context, id, ownerID, name string, parentID *string) (*models.Folder, error)
+	// Delete removes a folder. With recursive it also removes its subfolders and
+	// moves every file in them to the trash; otherwise the folder must be empty.
+	Delete(ctx context.Context, id, ownerID string, recursive bool) error
+}
@@ -0,0 +1,264 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FoldersPGX struct{ pool *pgxpool.Pool }
+
+func NewFoldersPGX(pool *pgxpool.Pool) *FoldersPGX { return &FoldersPGX{pool: pool} }
+
+const folderColumns = `id, owner_user_id, parent_id, name, created_at`
+
+func scanFolder(row pgx.Row) (*models.Folder, error) {
+	var f models.Folder
+	if err := row.Scan(&f.ID, &f.OwnerUserID, &f.ParentID, &f.Name, &f.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// claimName makes sure name is free among the folders and live files directly
+// inside parentID, ignoring exceptID (the entry being renamed or moved). It
+// takes a transaction-scoped advisory lock on the parent so two concurrent
+// claims for the same folder serialize; db must be a transaction.
+func claimName(ctx context.Context, db dbtx, ownerID string, parentID *string, name, exceptID string) error {
+	parent := ""
+	if parentID != nil {
+		parent = *parentID
+	}
+	if _, err := db.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1::text || ':' || $2::text))`, ownerID, parent); err != nil {
+		return err
+	}
+
+	if parentID != nil {
+		var ok bool
+		if err := db.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM folders WHERE id = $1 AND owner_user_id = $2)`,
+			*parentID, ownerID).Scan(&ok); err != nil {
+			return err
+		}
+		if !ok {
+			return ErrFolderNotFound
+		}
+	}
+
+	var taken bool
+	if err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM folders
+			WHERE owner_user_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3 AND id <> $4
+		) OR EXISTS (
+			SELECT 1 FROM files
+			WHERE owner_user_id = $1 AND folder_id IS NOT DISTINCT FROM $2 AND original_name = $3 AND id <> $4
+			  AND deleted_at IS NULL
+		)`, ownerID, parentID, name, exceptID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrNameConflict
+	}
+	return nil
+}
+
+// lockTree serializes structural changes (moves, deletes) to one owner's folders,
+// so two concurrent moves can't combine into a cycle.
+func lockTree(ctx context.Context, tx pgx.Tx, ownerID string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('folders:' || $1::text))`, ownerID)
+	return err
+}
+
+func (r *FoldersPGX) Create(ctx context.Context, f *models.Folder) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := claimName(ctx, tx, f.OwnerUserID, f.ParentID, f.Name, f.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO folders (id, owner_user_id, parent_id, name, created_at)
+		VALUES ($1,$2,$3,$4,$5)`,
+		f.ID, f.OwnerUserID, f.ParentID, f.Name, f.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *FoldersPGX) ByID(ctx context.Context, id string) (*models.Folder, error) {
+	f, err := scanFolder(r.pool.QueryRow(ctx, `SELECT `+folderColumns+` FROM folders WHERE id=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *FoldersPGX) Child(ctx context.Context, ownerID string, parentID *string, name string) (*models.Folder, error) {
+	f, err := scanFolder(r.pool.QueryRow(ctx, `
+		SELECT `+folderColumns+`
+		FROM folders
+		WHERE owner_user_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3`,
+		ownerID, parentID, name))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *FoldersPGX) Children(ctx context.Context, ownerID string, parentID *string) ([]*models.Folder, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+folderColumns+`
+		FROM folders
+		WHERE owner_user_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+		ORDER BY name`, ownerID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Folder
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+func (r *FoldersPGX) Subtree(ctx context.Context, ownerID string, parentID *string) ([]*models.Folder, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH RECURSIVE tree AS (
+			SELECT `+folderColumns+`, name AS path
+			FROM folders
+			WHERE owner_user_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+			UNION ALL
+			SELECT f.id, f.owner_user_id, f.parent_id, f.name, f.created_at, tree.path || '/' || f.name
+			FROM folders f JOIN tree ON f.parent_id = tree.id
+		)
+		SELECT `+folderColumns+`, path
+		FROM tree
+		ORDER BY path`, ownerID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Folder
+	for rows.Next() {
+		var f models.Folder
+		if err := rows.Scan(&f.ID, &f.OwnerUserID, &f.ParentID, &f.Name, &f.CreatedAt, &f.Path); err != nil {
+			return nil, err
+		}
+		out = append(out, &f)
+	}
+	return out, rows.Err()
+}
+
+func (r *FoldersPGX) Update(ctx context.Context, id, ownerID, name string, parentID *string) (*models.Folder, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockTree(ctx, tx, ownerID); err != nil {
+		return nil, err
+	}
+	f, err := scanFolder(tx.QueryRow(ctx, `
+		SELECT `+folderColumns+` FROM folders
+		WHERE id = $1 AND owner_user_id = $2
+		FOR UPDATE`, id, ownerID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrFolderNotFound
+		}
+		return nil, err
+	}
+
+	if parentID != nil {
+		var cycle bool
+		if err := tx.QueryRow(ctx, `
+			WITH RECURSIVE sub AS (
+				SELECT id FROM folders WHERE id = $1
+				UNION ALL
+				SELECT f.id FROM folders f JOIN sub ON f.parent_id = sub.id
+			)
+			SELECT EXISTS (SELECT 1 FROM sub WHERE id = $2)`, id, *parentID).Scan(&cycle); err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, ErrFolderCycle
+		}
+	}
+	if err := claimName(ctx, tx, ownerID, parentID, name, id); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE folders SET name = $1, parent_id = $2 WHERE id = $3`, name, parentID, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	f.Name, f.ParentID = name, parentID
+	return f, nil
+}
+
+func (r *FoldersPGX) Delete(ctx context.Context, id, ownerID string, recursive bool) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockTree(ctx, tx, ownerID); err != nil {
+		return err
+	}
+	var exists, empty bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM folders WHERE id = $1 AND owner_user_id = $2),
+		       NOT EXISTS (SELECT 1 FROM folders WHERE parent_id = $1)
+		       AND NOT EXISTS (SELECT 1 FROM files WHERE folder_id = $1 AND deleted_at IS NULL)`,
+		id, ownerID).Scan(&exists, &empty); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFolderNotFound
+	}
+	if !empty {
+		if !recursive {
+			return ErrFolderNotEmpty
+		}
+		// trashed files lose their folder with it and are restored to the root
+		if _, err := tx.Exec(ctx, `
+			WITH RECURSIVE tree AS (
+				SELECT id FROM folders WHERE id = $1
+				UNION ALL
+				SELECT f.id FROM folders f JOIN tree ON f.parent_id = tree.id
+			)
+			UPDATE files SET deleted_at = NOW()
+			WHERE owner_user_id = $2 AND deleted_at IS NULL AND folder_id IN (SELECT id FROM tree)`,
+			id, ownerID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM folders WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,17 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type LoginFailures interface {
+	Get(ctx context.Context, username string) (*models.LoginFailures, error)
+	// RecordFailure counts a failure at now and returns the new count. Failures
+	// from before windowStart are forgotten first.
+	RecordFailure(ctx context.Context, username string, now, windowStart time.Time) (int, error)
+	Lock(ctx context.Context, username string, until time.Time) error
+	Clear(ctx context.Context, username string) error
+}
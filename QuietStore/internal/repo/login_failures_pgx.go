@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LoginFailuresPGX struct{ pool *pgxpool.Pool }
+
+func NewLoginFailuresPGX(pool *pgxpool.Pool) *LoginFailuresPGX {
+	return &LoginFailuresPGX{pool: pool}
+}
+
+func (r *LoginFailuresPGX) Get(ctx context.Context, username string) (*models.LoginFailures, error) {
+	var f models.LoginFailures
+	err := r.pool.QueryRow(ctx, `
+		SELECT username, failures, last_failed_at, locked_until
+		FROM login_failures WHERE username=$1`, username).
+		Scan(&f.Username, &f.Failures, &f.LastFailedAt, &f.LockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *LoginFailuresPGX) RecordFailure(ctx context.Context, username string, now, windowStart time.Time) (int, error) {
+	// guessed usernames would pile up otherwise, so stale rows go here
+	if _, err := r.pool.Exec(ctx, `
+		DELETE FROM login_failures
+		WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < $2)`, windowStart, now); err != nil {
+		return 0, err
+	}
+	var n int
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO login_failures (username, failures, last_failed_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (username) DO UPDATE SET
+		  failures = CASE WHEN login_failures.last_failed_at < $3 THEN 1
+		                  ELSE login_failures.failures + 1 END,
+		  last_failed_at = $2
+		RETURNING failures`, username, now, windowStart).Scan(&n)
+	return n, err
+}
+
+func (r *LoginFailuresPGX) Lock(ctx context.Context, username string, until time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE login_failures SET locked_until=$2
+		WHERE username=$1`, username, until)
+	return err
+}
+
+func (r *LoginFailuresPGX) Clear(ctx context.Context, username string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM login_failures WHERE username=$1`, username)
+	return err
+}
@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type MFA interface {
+	TOTP(ctx context.Context, userID string) (*models.TOTP, error)
+	// SetPendingTOTP stores a new secret awaiting confirmation, replacing any
+	// earlier pending one. It returns false if TOTP is already enabled.
+	SetPendingTOTP(ctx context.Context, userID string, secret []byte) (bool, error)
+	// EnableTOTP confirms the pending secret, records step as used and replaces
+	// the recovery codes. It returns false if nothing was pending.
+	EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) (bool, error)
+	// UseTOTPStep records step as used, and returns false if it or a later
+	// step already was.
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	// DeleteTOTP turns MFA off, dropping the secret and recovery codes.
+	DeleteTOTP(ctx context.Context, userID string) (bool, error)
+
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+
+	CreateChallenge(ctx context.Context, c *models.MFAChallenge) error
+	ChallengeByHash(ctx context.Context, tokenHash string, now time.Time) (*models.MFAChallenge, error)
+	// CountChallengeAttempt bumps the attempt counter and returns the new count,
+	// or 0 if the challenge is gone.
+	CountChallengeAttempt(ctx context.Context, id string) (int, error)
+	DeleteChallenge(ctx context.Context, id string) error
+}
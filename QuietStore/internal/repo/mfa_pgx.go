@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MFAPGX struct{ pool *pgxpool.Pool }
+
+func NewMFAPGX(pool *pgxpool.Pool) *MFAPGX { return &MFAPGX{pool: pool} }
+
+func (r *MFAPGX) TOTP(ctx context.Context, userID string) (*models.TOTP, error) {
+	var t models.TOTP
+	err := r.pool.QueryRow(ctx, `
+		SELECT user_id, secret, enabled_at, last_step, created_at
+		FROM user_totp WHERE user_id=$1`, userID).
+		Scan(&t.UserID, &t.Secret, &t.EnabledAt, &t.LastStep, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *MFAPGX) SetPendingTOTP(ctx context.Context, userID string, secret []byte) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		INSERT INTO user_totp (user_id, secret, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		   SET secret = EXCLUDED.secret, last_step = 0, created_at = EXCLUDED.created_at
+		 WHERE user_totp.enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, q dbtx, userID string, codeHashes []string) error {
+	if _, err := q.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := q.Exec(ctx, `
+			INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at)
+			VALUES ($1, $2, $3, NOW())`, models.GenerateRecoveryCodeID(), userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MFAPGX) EnableTOTP(ctx context.Context, userID string, step int64, codeHashes []string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE user_totp SET enabled_at = NOW(), last_step = $2
+		WHERE user_id=$1 AND enabled_at IS NULL`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func (r *MFAPGX) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE user_totp SET last_step = $2
+		WHERE user_id=$1 AND last_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *MFAPGX) DeleteTOTP(ctx context.Context, userID string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id=$1`, userID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, tx.Commit(ctx)
+}
+
+func (r *MFAPGX) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MFAPGX) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *MFAPGX) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := r.pool.QueryRow(ctx, `
+		SELECT count(*) FROM mfa_recovery_codes
+		WHERE user_id=$1 AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+func (r *MFAPGX) CreateChallenge(ctx context.Context, c *models.MFAChallenge) error {
+	// abandoned challenges are cleared out here rather than by a purge job
+	if _, err := r.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < $1`, c.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO mfa_challenges (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		c.ID, c.UserID, c.TokenHash, c.ExpiresAt, c.CreatedAt)
+	return err
+}
+
+func (r *MFAPGX) ChallengeByHash(ctx context.Context, tokenHash string, now time.Time) (*models.MFAChallenge, error) {
+	var c models.MFAChallenge
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, user_id, token_hash, attempts, expires_at, created_at
+		FROM mfa_challenges
+		WHERE token_hash=$1 AND expires_at > $2`, tokenHash, now).
+		Scan(&c.ID, &c.UserID, &c.TokenHash, &c.Attempts, &c.ExpiresAt, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *MFAPGX) CountChallengeAttempt(ctx context.Context, id string) (int, error) {
+	var n int
+	err := r.pool.QueryRow(ctx, `
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE id=$1
+		RETURNING attempts`, id).Scan(&n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (r *MFAPGX) DeleteChallenge(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE id=$1`, id)
+	return err
+}
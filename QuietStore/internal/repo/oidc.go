@@ -0,0 +1,20 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type OIDC interface {
+	CreateLogin(ctx context.Context, l *models.OIDCLogin) error
+	// TakeLogin deletes and returns the unexpired login with the state hash,
+	// so a callback can only be completed once.
+	TakeLogin(ctx context.Context, stateHash string, now time.Time) (*models.OIDCLogin, error)
+
+	IdentityBySubject(ctx context.Context, provider, subject string) (*models.LinkedIdentity, error)
+	LinkIdentity(ctx context.Context, li *models.LinkedIdentity) error
+	// TouchIdentity records a login and the email the provider last reported.
+	TouchIdentity(ctx context.Context, id, email string, now time.Time) error
+}
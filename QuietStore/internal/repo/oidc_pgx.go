@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OIDCPGX struct{ pool *pgxpool.Pool }
+
+func NewOIDCPGX(pool *pgxpool.Pool) *OIDCPGX {
+	return &OIDCPGX{pool: pool}
+}
+
+func (r *OIDCPGX) CreateLogin(ctx context.Context, l *models.OIDCLogin) error {
+	// abandoned logins are cleared out here rather than by a purge job
+	if _, err := r.pool.Exec(ctx, `DELETE FROM oidc_logins WHERE expires_at < $1`, l.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO oidc_logins (id, state_hash, nonce, code_verifier, expires_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6)`,
+		l.ID, l.StateHash, l.Nonce, l.CodeVerifier, l.ExpiresAt, l.CreatedAt)
+	return err
+}
+
+func (r *OIDCPGX) TakeLogin(ctx context.Context, stateHash string, now time.Time) (*models.OIDCLogin, error) {
+	var l models.OIDCLogin
+	err := r.pool.QueryRow(ctx, `
+		DELETE FROM oidc_logins
+		WHERE state_hash=$1
+		RETURNING id, state_hash, nonce, code_verifier, expires_at, created_at`, stateHash).
+		Scan(&l.ID, &l.StateHash, &l.Nonce, &l.CodeVerifier, &l.ExpiresAt, &l.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !l.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+func (r *OIDCPGX) IdentityBySubject(ctx context.Context, provider, subject string) (*models.LinkedIdentity, error) {
+	var li models.LinkedIdentity
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM linked_identities
+		WHERE provider=$1 AND subject=$2`, provider, subject).
+		Scan(&li.ID, &li.UserID, &li.Provider, &li.Subject, &li.Email, &li.CreatedAt, &li.LastLoginAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &li, nil
+}
+
+func (r *OIDCPGX) LinkIdentity(ctx context.Context, li *models.LinkedIdentity) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO linked_identities (id, user_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		li.ID, li.UserID, li.Provider, li.Subject, li.Email, li.CreatedAt, li.LastLoginAt)
+	return err
+}
+
+func (r *OIDCPGX) TouchIdentity(ctx context.Context, id, email string, now time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE linked_identities SET email=$2, last_login_at=$3
+		WHERE id=$1`, id, email, now)
+	return err
+}
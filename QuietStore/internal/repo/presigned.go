@@ -0,0 +1,21 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// ErrPresignedUploadGone is returned by Complete when the pending upload was
+// confirmed or purged in the meantime.
+var ErrPresignedUploadGone = errors.New("upload was already confirmed or has expired")
+
+type PresignedUploads interface {
+	Create(ctx context.Context, p *models.PresignedUpload) error
+	ByID(ctx context.Context, id string) (*models.PresignedUpload, error)
+	Complete(ctx context.Context, id string, f *models.File, quota int64) error
+	Delete(ctx context.Context, id string) error
+	ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.PresignedUpload, error)
+}
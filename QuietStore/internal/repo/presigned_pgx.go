@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PresignedPGX struct{ pool *pgxpool.Pool }
+
+func NewPresignedPGX(pool *pgxpool.Pool) *PresignedPGX { return &PresignedPGX{pool: pool} }
+
+const presignedColumns = `id, owner_user_id, object_key, original_name, content_type, size_bytes, sha256, created_at, expires_at`
+
+func scanPresigned(row pgx.Row) (*models.PresignedUpload, error) {
+	var p models.PresignedUpload
+	var ct *string
+	if err := row.Scan(&p.ID, &p.OwnerUserID, &p.ObjectKey, &p.OriginalName, &ct, &p.SizeBytes, &p.SHA256, &p.CreatedAt, &p.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if ct != nil {
+		p.ContentType = *ct
+	}
+	return &p, nil
+}
+
+func (r *PresignedPGX) Create(ctx context.Context, p *models.PresignedUpload) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO presigned_uploads (id, owner_user_id, object_key, original_name, content_type, size_bytes, sha256, created_at, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		p.ID, p.OwnerUserID, p.ObjectKey, p.OriginalName, p.ContentType, p.SizeBytes, p.SHA256, p.CreatedAt, p.ExpiresAt)
+	return err
+}
+
+func (r *PresignedPGX) ByID(ctx context.Context, id string) (*models.PresignedUpload, error) {
+	p, err := scanPresigned(r.pool.QueryRow(ctx, `SELECT `+presignedColumns+` FROM presigned_uploads WHERE id=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// Complete inserts the confirmed file row and drops the pending entry in one transaction.
+func (r *PresignedPGX) Complete(ctx context.Context, id string, f *models.File, quota int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM presigned_uploads WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPresignedUploadGone
+	}
+	if err := insertFile(ctx, tx, f, quota); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PresignedPGX) Delete(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM presigned_uploads WHERE id=$1`, id)
+	return err
+}
+
+func (r *PresignedPGX) ListExpired(ctx context.Context, before time.Time, limit int) ([]*models.PresignedUpload, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+presignedColumns+`
+		FROM presigned_uploads
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.PresignedUpload
+	for rows.Next() {
+		p, err := scanPresigned(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// the limiter holds a lock while it talks to the store, so a slow database
+// must not stall every request behind it
+const rateLimitStoreTimeout = 2 * time.Second
+
+// RateLimitStorePGX is a fiber.Storage for the limiter middleware, so limits
+// hold across replicas. Expiry uses the database clock, which all replicas
+// agree on. Expired rows are ignored on read and removed by Sweep.
+type RateLimitStorePGX struct{ pool *pgxpool.Pool }
+
+func NewRateLimitStorePGX(pool *pgxpool.Pool) *RateLimitStorePGX {
+	return &RateLimitStorePGX{pool: pool}
+}
+
+func (r *RateLimitStorePGX) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	var val []byte
+	err := r.pool.QueryRow(ctx, `
+		SELECT value FROM rate_limits
+		WHERE key=$1 AND (expires_at IS NULL OR expires_at > NOW())`, key).Scan(&val)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return val, err
+}
+
+// Set stores val under key. An exp of zero keeps it until it is deleted.
+func (r *RateLimitStorePGX) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO rate_limits (key, value, expires_at)
+		VALUES ($1, $2, CASE WHEN $3::float8 > 0 THEN NOW() + $3::float8 * INTERVAL '1 second' END)
+		ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, expires_at=EXCLUDED.expires_at`,
+		key, val, exp.Seconds())
+	return err
+}
+
+func (r *RateLimitStorePGX) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	_, err := r.pool.Exec(ctx, `DELETE FROM rate_limits WHERE key=$1`, key)
+	return err
+}
+
+func (r *RateLimitStorePGX) Reset() error {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+	_, err := r.pool.Exec(ctx, `DELETE FROM rate_limits`)
+	return err
+}
+
+// Close does nothing, the pool belongs to the caller.
+func (r *RateLimitStorePGX) Close() error {
+	return nil
+}
+
+// Sweep deletes expired counters and returns how many.
+func (r *RateLimitStorePGX) Sweep(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM rate_limits WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
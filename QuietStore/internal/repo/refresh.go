@@ -3,11 +3,29 @@ package repo
 import (
 	"context"
 	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 )
 
+// RefreshTokens stores refresh tokens and the sessions they belong to. A
+// session's ID is the family ID of its tokens.
 type RefreshTokens interface {
-	Insert(ctx context.Context, userID string, tokenHash string, expiresAt time.Time) error
-	FindValid(ctx context.Context, userID string, tokenHash string, now time.Time) (bool, error)
+	// StartSession records a login: the session and the first token of its family.
+	StartSession(ctx context.Context, s *models.Session, t *models.RefreshToken) error
+	// ByHash returns the token whether or not it is still valid, so a replayed
+	// revoked token can be told apart from an unknown one.
+	ByHash(ctx context.Context, userID string, tokenHash string) (*models.RefreshToken, error)
+	// Rotate revokes parent and inserts next in one step, and marks the session
+	// used from ip and userAgent. It returns false when parent was already
+	// revoked, i.e. another request rotated it first.
+	Rotate(ctx context.Context, parentID string, next *models.RefreshToken, ip, userAgent string) (bool, error)
+	SessionByID(ctx context.Context, id string) (*models.Session, error)
+	// ListSessions returns the user's sessions that still hold a live token.
+	ListSessions(ctx context.Context, userID string, now time.Time) ([]*models.Session, error)
+	// RevokeSession ends a session and revokes all of its tokens.
+	RevokeSession(ctx context.Context, userID, sessionID string) (bool, error)
+	RevokeAllSessions(ctx context.Context, userID string) (int64, error)
+	// Revoke ends the session the token belongs to.
 	Revoke(ctx context.Context, userID string, tokenHash string) error
 	Purge(ctx context.Context, expiresBefore time.Time, revokedBefore time.Time) (int64, error)
 }
@@ -2,8 +2,11 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -11,49 +14,204 @@ type RefreshPGX struct{ pool *pgxpool.Pool }
 
 func NewRefreshPGX(pool *pgxpool.Pool) *RefreshPGX { return &RefreshPGX{pool: pool} }
 
-func (r *RefreshPGX) Insert(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
-	_, err := r.pool.Exec(ctx, `
-		INSERT INTO refresh_tokens (id, user_id, token_hash, issued_at, expires_at)
-		VALUES (gen_random_uuid()::text, $1, $2, NOW(), $3)
-	`, userID, tokenHash, expiresAt)
+const (
+	refreshColumns = `id, user_id, family_id, parent_id, token_hash, issued_at, expires_at, access_expires_at, revoked_at`
+	sessionColumns = `id, user_id, user_agent, ip, created_at, last_used_at, revoked_at`
+)
+
+func scanSession(row pgx.Row) (*models.Session, error) {
+	var s models.Session
+	if err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func insertRefresh(ctx context.Context, q dbtx, t *models.RefreshToken) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO refresh_tokens (id, user_id, family_id, parent_id, token_hash, issued_at, expires_at, access_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, t.ID, t.UserID, t.FamilyID, t.ParentID, t.TokenHash, t.IssuedAt, t.ExpiresAt, t.AccessExpiresAt)
 	return err
 }
 
-func (r *RefreshPGX) FindValid(ctx context.Context, userID, tokenHash string, now time.Time) (bool, error) {
-	var exists bool
+func (r *RefreshPGX) StartSession(ctx context.Context, s *models.Session, t *models.RefreshToken) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, s.ID, s.UserID, s.UserAgent, s.IP, s.CreatedAt, s.LastUsedAt); err != nil {
+		return err
+	}
+	if err := insertRefresh(ctx, tx, t); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *RefreshPGX) ByHash(ctx context.Context, userID, tokenHash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
 	err := r.pool.QueryRow(ctx, `
-		SELECT EXISTS (
-			SELECT 1 FROM refresh_tokens
-			WHERE user_id=$1
-			  AND token_hash=$2
-			  AND revoked_at IS NULL
-			  AND expires_at > $3
-		)
-	`, userID, tokenHash, now).Scan(&exists)
-	return exists, err
+		SELECT `+refreshColumns+`
+		FROM refresh_tokens
+		WHERE user_id=$1 AND token_hash=$2
+	`, userID, tokenHash).Scan(&t.ID, &t.UserID, &t.FamilyID, &t.ParentID, &t.TokenHash,
+		&t.IssuedAt, &t.ExpiresAt, &t.AccessExpiresAt, &t.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
-func (r *RefreshPGX) Revoke(ctx context.Context, userID, tokenHash string) error {
-	_, err := r.pool.Exec(ctx, `
+func (r *RefreshPGX) Rotate(ctx context.Context, parentID string, next *models.RefreshToken, ip, userAgent string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		   SET revoked_at = NOW()
+		 WHERE id=$1 AND revoked_at IS NULL
+	`, parentID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := insertRefresh(ctx, tx, next); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE sessions
+		   SET last_used_at = $2, ip = $3, user_agent = $4
+		 WHERE id=$1
+	`, next.FamilyID, next.IssuedAt, ip, userAgent); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func (r *RefreshPGX) SessionByID(ctx context.Context, id string) (*models.Session, error) {
+	s, err := scanSession(r.pool.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return s, err
+}
+
+func (r *RefreshPGX) ListSessions(ctx context.Context, userID string, now time.Time) ([]*models.Session, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions s
+		WHERE s.user_id=$1
+		  AND s.revoked_at IS NULL
+		  AND EXISTS (
+			SELECT 1 FROM refresh_tokens t
+			WHERE t.family_id = s.id AND t.revoked_at IS NULL AND t.expires_at > $2
+		  )
+		ORDER BY s.last_used_at DESC`, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// revokeSessions ends the user's sessions matching the given condition on
+// sessions s, and revokes their tokens.
+func (r *RefreshPGX) revokeSessions(ctx context.Context, where string, args ...any) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE sessions s SET revoked_at = NOW()
+		WHERE s.revoked_at IS NULL AND `+where+`
+		RETURNING s.id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if _, err := tx.Exec(ctx, `
 		UPDATE refresh_tokens
 		   SET revoked_at = NOW()
-		 WHERE user_id=$1 AND token_hash=$2 AND revoked_at IS NULL
-	`, userID, tokenHash)
+		 WHERE family_id = ANY($1) AND revoked_at IS NULL
+	`, ids); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), tx.Commit(ctx)
+}
+
+func (r *RefreshPGX) RevokeSession(ctx context.Context, userID, sessionID string) (bool, error) {
+	n, err := r.revokeSessions(ctx, `s.user_id=$1 AND s.id=$2`, userID, sessionID)
+	return n > 0, err
+}
+
+func (r *RefreshPGX) RevokeAllSessions(ctx context.Context, userID string) (int64, error) {
+	return r.revokeSessions(ctx, `s.user_id=$1`, userID)
+}
+
+func (r *RefreshPGX) Revoke(ctx context.Context, userID, tokenHash string) error {
+	_, err := r.revokeSessions(ctx, `s.user_id=$1 AND s.id IN (
+		SELECT family_id FROM refresh_tokens WHERE user_id=$1 AND token_hash=$2)`, userID, tokenHash)
 	return err
 }
 
+// Purge deletes expired tokens, and revoked ones once their whole family is
+// dead. Rotated tokens of a live family are kept so replaying them is caught.
+// Sessions go with their last token.
 func (r *RefreshPGX) Purge(ctx context.Context, expiresBefore time.Time, revokedBefore time.Time) (int64, error) {
 	expiresBefore = expiresBefore.UTC()
 	revokedBefore = revokedBefore.UTC()
 
 	tag, err := r.pool.Exec(ctx, `
-		DELETE FROM refresh_tokens
+		DELETE FROM refresh_tokens t
 		WHERE
-			expires_at < $1
-			OR (revoked_at IS NOT NULL AND revoked_at < $2)
+			t.expires_at < $1
+			OR (t.revoked_at IS NOT NULL AND t.revoked_at < $2
+				AND NOT EXISTS (
+					SELECT 1 FROM refresh_tokens l
+					WHERE l.family_id = t.family_id
+					  AND l.revoked_at IS NULL
+					  AND l.expires_at >= $1
+				))
 	`, expiresBefore, revokedBefore)
 	if err != nil {
 		return 0, err
 	}
+	if _, err := r.pool.Exec(ctx, `
+		DELETE FROM sessions s
+		WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id)
+	`); err != nil {
+		return tag.RowsAffected(), err
+	}
 	return tag.RowsAffected(), nil
 }
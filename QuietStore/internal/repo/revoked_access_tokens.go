@@ -0,0 +1,22 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// RevokedAccessTokens is the access token denylist. Every revocation is
+// announced with NOTIFY, so each replica can keep its own copy in memory.
+type RevokedAccessTokens interface {
+	// RevokeSessions denylists the live access tokens of the user's sessions,
+	// only those of sessionID unless it is empty, and returns the new entries.
+	RevokeSessions(ctx context.Context, userID, sessionID string) ([]*models.RevokedAccessToken, error)
+	// Active returns every entry that hasn't expired by now.
+	Active(ctx context.Context, now time.Time) ([]*models.RevokedAccessToken, error)
+	// Listen calls onRevoke for each revocation announced until ctx ends or
+	// the connection fails. onListening runs once announcements are being
+	// received, so entries loaded from there on can't be missed.
+	Listen(ctx context.Context, onListening func(ctx context.Context) error, onRevoke func(*models.RevokedAccessToken)) error
+}
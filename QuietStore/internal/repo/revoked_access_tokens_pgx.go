@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const revokedAccessChannel = "revoked_access_tokens"
+
+type RevokedAccessTokensPGX struct{ pool *pgxpool.Pool }
+
+func NewRevokedAccessTokensPGX(pool *pgxpool.Pool) *RevokedAccessTokensPGX {
+	return &RevokedAccessTokensPGX{pool: pool}
+}
+
+func scanRevokedAccessTokens(rows pgx.Rows) ([]*models.RevokedAccessToken, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.RevokedAccessToken, error) {
+		var t models.RevokedAccessToken
+		err := row.Scan(&t.JTI, &t.UserID, &t.ExpiresAt, &t.RevokedAt)
+		return &t, err
+	})
+}
+
+func (r *RevokedAccessTokensPGX) RevokeSessions(ctx context.Context, userID, sessionID string) ([]*models.RevokedAccessToken, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// entries are useless once their token has expired, so they are cleared
+	// out here rather than by a purge job
+	if _, err := tx.Exec(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, `
+		INSERT INTO revoked_access_tokens (jti, user_id, expires_at, revoked_at)
+		SELECT id, user_id, access_expires_at, NOW()
+		FROM refresh_tokens
+		WHERE user_id=$1 AND ($2 = '' OR family_id=$2) AND access_expires_at > NOW()
+		ON CONFLICT (jti) DO NOTHING
+		RETURNING jti, user_id, expires_at, revoked_at
+	`, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := scanRevokedAccessTokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	// notifications go out on commit
+	for _, t := range revoked {
+		payload, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, revokedAccessChannel, string(payload)); err != nil {
+			return nil, err
+		}
+	}
+	return revoked, tx.Commit(ctx)
+}
+
+func (r *RevokedAccessTokensPGX) Active(ctx context.Context, now time.Time) ([]*models.RevokedAccessToken, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT jti, user_id, expires_at, revoked_at
+		FROM revoked_access_tokens
+		WHERE expires_at > $1`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanRevokedAccessTokens(rows)
+}
+
+func (r *RevokedAccessTokensPGX) Listen(ctx context.Context, onListening func(ctx context.Context) error, onRevoke func(*models.RevokedAccessToken)) error {
+	pooled, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// a listening connection must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+revokedAccessChannel); err != nil {
+		return err
+	}
+	if err := onListening(ctx); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var t models.RevokedAccessToken
+		if err := json.Unmarshal([]byte(n.Payload), &t); err != nil {
+			continue
+		}
+		onRevoke(&t)
+	}
+}
@@ -0,0 +1,11 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type SecurityEvents interface {
+	Record(ctx context.Context, e *models.SecurityEvent) error
+}
@@ -0,0 +1,22 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SecurityEventsPGX struct{ pool *pgxpool.Pool }
+
+func NewSecurityEventsPGX(pool *pgxpool.Pool) *SecurityEventsPGX {
+	return &SecurityEventsPGX{pool: pool}
+}
+
+func (r *SecurityEventsPGX) Record(ctx context.Context, e *models.SecurityEvent) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO security_events (id, user_id, kind, detail, ip, user_agent, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		e.ID, e.UserID, e.Kind, e.Detail, e.IP, e.UserAgent, e.CreatedAt)
+	return err
+}
@@ -0,0 +1,19 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type Shares interface {
+	Create(ctx context.Context, s *models.Share) error
+	ByTokenHash(ctx context.Context, tokenHash string) (*models.Share, error)
+	ListByOwner(ctx context.Context, ownerID string) ([]*models.Share, error)
+	// Revoke reports false if the owner has no such live share.
+	Revoke(ctx context.Context, id, ownerID string) (bool, error)
+	// ClaimDownload counts one download against a share. It reports false if
+	// the share was revoked, expired or used up in the meantime.
+	ClaimDownload(ctx context.Context, id string, now time.Time) (bool, error)
+}
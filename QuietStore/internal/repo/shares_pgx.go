@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SharesPGX struct{ pool *pgxpool.Pool }
+
+func NewSharesPGX(pool *pgxpool.Pool) *SharesPGX { return &SharesPGX{pool: pool} }
+
+const shareColumns = `id, owner_user_id, token_hash, file_id, folder_id, password_hash,
+		expires_at, max_downloads, download_count, created_at, revoked_at`
+
+func scanShare(row pgx.Row) (*models.Share, error) {
+	var s models.Share
+	var pw *string
+	if err := row.Scan(&s.ID, &s.OwnerUserID, &s.TokenHash, &s.FileID, &s.FolderID, &pw,
+		&s.ExpiresAt, &s.MaxDownloads, &s.DownloadCount, &s.CreatedAt, &s.RevokedAt); err != nil {
+		return nil, err
+	}
+	if pw != nil {
+		s.PasswordHash = *pw
+		s.HasPassword = true
+	}
+	return &s, nil
+}
+
+func (r *SharesPGX) Create(ctx context.Context, s *models.Share) error {
+	var pw *string
+	if s.PasswordHash != "" {
+		pw = &s.PasswordHash
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO shares (id, owner_user_id, token_hash, file_id, folder_id, password_hash, expires_at, max_downloads, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		s.ID, s.OwnerUserID, s.TokenHash, s.FileID, s.FolderID, pw, s.ExpiresAt, s.MaxDownloads, s.CreatedAt)
+	return err
+}
+
+func (r *SharesPGX) ByTokenHash(ctx context.Context, tokenHash string) (*models.Share, error) {
+	s, err := scanShare(r.pool.QueryRow(ctx, `SELECT `+shareColumns+` FROM shares WHERE token_hash=$1`, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *SharesPGX) ListByOwner(ctx context.Context, ownerID string) ([]*models.Share, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+shareColumns+`
+		FROM shares
+		WHERE owner_user_id=$1
+		ORDER BY created_at DESC`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Share
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *SharesPGX) Revoke(ctx context.Context, id, ownerID string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE shares SET revoked_at = NOW()
+		WHERE id = $1 AND owner_user_id = $2 AND revoked_at IS NULL`,
+		id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *SharesPGX) ClaimDownload(ctx context.Context, id string, now time.Time) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE shares SET download_count = download_count + 1
+		WHERE id = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > $2)
+		  AND (max_downloads IS NULL OR download_count < max_downloads)`,
+		id, now)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
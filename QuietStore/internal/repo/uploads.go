@@ -0,0 +1,23 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// ErrOffsetConflict is returned by Checkpoint when another request advanced the
+// upload since it was read.
+var ErrOffsetConflict = errors.New("upload offset changed concurrently")
+
+type Uploads interface {
+	Create(ctx context.Context, u *models.UploadSession) error
+	ByID(ctx context.Context, id string) (*models.UploadSession, error)
+	Parts(ctx context.Context, uploadID string) ([]models.UploadPart, error)
+	Checkpoint(ctx context.Context, id string, prevOffset, newOffset int64, hashState []byte, part *models.UploadPart, expiresAt time.Time) error
+	Complete(ctx context.Context, id string, f *models.File, quota int64) error
+	Delete(ctx context.Context, id string) error
+	ListExpired(ctx context.Context, now time.Time, limit int) ([]*models.UploadSession, error)
+}
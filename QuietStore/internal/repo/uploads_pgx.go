@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type UploadsPGX struct{ pool *pgxpool.Pool }
+
+func NewUploadsPGX(pool *pgxpool.Pool) *UploadsPGX { return &UploadsPGX{pool: pool} }
+
+const uploadColumns = `id, owner_user_id, file_id, object_key, s3_upload_id, original_name, content_type,
+		upload_length, upload_offset, hash_state, wrapped_key, metadata, created_at, expires_at`
+
+func scanUpload(row pgx.Row) (*models.UploadSession, error) {
+	var u models.UploadSession
+	var ct, meta *string
+	if err := row.Scan(&u.ID, &u.OwnerUserID, &u.FileID, &u.ObjectKey, &u.S3UploadID, &u.OriginalName, &ct,
+		&u.Length, &u.Offset, &u.HashState, &u.WrappedKey, &meta, &u.CreatedAt, &u.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if ct != nil {
+		u.ContentType = *ct
+	}
+	if meta != nil {
+		u.Metadata = *meta
+	}
+	return &u, nil
+}
+
+func (r *UploadsPGX) Create(ctx context.Context, u *models.UploadSession) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO upload_sessions (id, owner_user_id, file_id, object_key, s3_upload_id, original_name, content_type,
+			upload_length, upload_offset, hash_state, wrapped_key, metadata, created_at, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)`,
+		u.ID, u.OwnerUserID, u.FileID, u.ObjectKey, u.S3UploadID, u.OriginalName, u.ContentType,
+		u.Length, u.Offset, u.HashState, u.WrappedKey, u.Metadata, u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+func (r *UploadsPGX) ByID(ctx context.Context, id string) (*models.UploadSession, error) {
+	u, err := scanUpload(r.pool.QueryRow(ctx, `SELECT `+uploadColumns+` FROM upload_sessions WHERE id=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *UploadsPGX) Parts(ctx context.Context, uploadID string) ([]models.UploadPart, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT upload_id, part_number, etag, size_bytes
+		FROM upload_parts
+		WHERE upload_id=$1
+		ORDER BY part_number`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.UploadPart
+	for rows.Next() {
+		var p models.UploadPart
+		if err := rows.Scan(&p.UploadID, &p.PartNumber, &p.ETag, &p.SizeBytes); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// Checkpoint records progress on an upload: the new offset and hash state, plus
+// the part that was just uploaded (if any). It fails with ErrOffsetConflict if
+// the stored offset is no longer prevOffset.
+func (r *UploadsPGX) Checkpoint(
+	ctx context.Context,
+	id string,
+	prevOffset, newOffset int64,
+	hashState []byte,
+	part *models.UploadPart,
+	expiresAt time.Time,
+) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE upload_sessions
+		   SET upload_offset = $1, hash_state = $2, expires_at = $3
+		 WHERE id = $4 AND upload_offset = $5`,
+		newOffset, hashState, expiresAt, id, prevOffset)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOffsetConflict
+	}
+
+	if part != nil {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO upload_parts (upload_id, part_number, etag, size_bytes)
+			VALUES ($1,$2,$3,$4)
+			ON CONFLICT (upload_id, part_number) DO UPDATE SET etag = EXCLUDED.etag, size_bytes = EXCLUDED.size_bytes`,
+			id, part.PartNumber, part.ETag, part.SizeBytes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Complete inserts the finished file row and drops the upload session in one transaction.
+func (r *UploadsPGX) Complete(ctx context.Context, id string, f *models.File, quota int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertFile(ctx, tx, f, quota); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM upload_sessions WHERE id=$1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UploadsPGX) Delete(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM upload_sessions WHERE id=$1`, id)
+	return err
+}
+
+func (r *UploadsPGX) ListExpired(ctx context.Context, now time.Time, limit int) ([]*models.UploadSession, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+uploadColumns+`
+		FROM upload_sessions
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.UploadSession
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrQuotaExceeded is returned when committing a file or version would take
+// its owner past their quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Usage reads the per-user totals. They are kept up to date by the Files,
+// FileVersions, Uploads and PresignedUploads repos in the same transaction
+// that adds or removes the stored bytes.
+type Usage interface {
+	// ByUser returns the bytes and file count stored by a user.
+	ByUser(ctx context.Context, userID string) (usedBytes, fileCount int64, err error)
+}
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type UsagePGX struct{ pool *pgxpool.Pool }
+
+func NewUsagePGX(pool *pgxpool.Pool) *UsagePGX { return &UsagePGX{pool: pool} }
+
+func (r *UsagePGX) ByUser(ctx context.Context, userID string) (int64, int64, error) {
+	var used, count int64
+	err := r.pool.QueryRow(ctx, `SELECT used_bytes, file_count FROM user_usage WHERE user_id=$1`, userID).Scan(&used, &count)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return used, count, err
+}
+
+// addUsage adjusts a user's totals inside db's transaction. The row lock it
+// takes serializes concurrent uploads by the same user until commit. When
+// bytes is positive and quota is above zero, it fails with ErrQuotaExceeded
+// instead of going over.
+func addUsage(ctx context.Context, db dbtx, userID string, bytes, files, quota int64) error {
+	var used int64
+	if err := db.QueryRow(ctx, `
+		INSERT INTO user_usage (user_id, used_bytes, file_count)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		   SET used_bytes = user_usage.used_bytes + EXCLUDED.used_bytes,
+		       file_count = user_usage.file_count + EXCLUDED.file_count
+		RETURNING used_bytes`, userID, bytes, files).Scan(&used); err != nil {
+		return err
+	}
+	if bytes > 0 && quota > 0 && used > quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
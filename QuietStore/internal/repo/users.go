@@ -10,6 +10,9 @@ type Users interface {
 	Create(ctx context.Context, u *models.User) error
 	ByID(ctx context.Context, id string) (*models.User, error)
 	ByUsername(ctx context.Context, username string) (*models.User, error)
+	ByEmail(ctx context.Context, email string) (*models.User, error)
+	MarkEmailVerified(ctx context.Context, id, email string) (bool, error)
+	SetPassword(ctx context.Context, id, passwordHash string) error
 	Update(ctx context.Context, u *models.User) error
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context, limit, offset int) ([]*models.User, error)
@@ -14,50 +14,98 @@ type UsersPGX struct{ pool *pgxpool.Pool }
 
 func NewUsersPGX(pool *pgxpool.Pool) *UsersPGX { return &UsersPGX{pool: pool} }
 
+const userColumns = `id, username, email, email_verified_at, password_hash, role, max_versions, quota_bytes, created_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var u models.User
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.EmailVerifiedAt, &u.Password, &u.Role, &u.MaxVersions, &u.QuotaBytes, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 func (r *UsersPGX) Create(ctx context.Context, u *models.User) error {
+	u.Email = models.NormalizeEmail(u.Email)
 	_, err := r.pool.Exec(ctx, `
-    INSERT INTO users (id, username, email, password_hash, created_at)
-    VALUES ($1, $2, $3, $4, $5)`,
-		u.ID, u.Username, u.Email, u.Password, u.CreatedAt)
+    INSERT INTO users (id, username, email, password_hash, role, max_versions, quota_bytes, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		u.ID, u.Username, u.Email, u.Password, u.Role, u.MaxVersions, u.QuotaBytes, u.CreatedAt)
 	return err
 }
 
 func (r *UsersPGX) ByID(ctx context.Context, id string) (*models.User, error) {
-	row := r.pool.QueryRow(ctx, `
-    SELECT id, username, email, password_hash, created_at
-    FROM users WHERE id=$1`, id)
-	var u models.User
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.CreatedAt); err != nil {
+	u, err := scanUser(r.pool.QueryRow(ctx, `
+    SELECT `+userColumns+`
+    FROM users WHERE id=$1`, id))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &u, nil
+	return u, nil
 }
 
 func (r *UsersPGX) ByUsername(ctx context.Context, username string) (*models.User, error) {
-	row := r.pool.QueryRow(ctx, `
-    SELECT id, username, email, password_hash, created_at
-    FROM users WHERE username=$1`, username)
-	var u models.User
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.CreatedAt); err != nil {
+	u, err := scanUser(r.pool.QueryRow(ctx, `
+    SELECT `+userColumns+`
+    FROM users WHERE username=$1`, username))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &u, nil
+	return u, nil
 }
 
+// Update saves u. A changed email address drops its verification.
 func (r *UsersPGX) Update(ctx context.Context, u *models.User) error {
+	u.Email = models.NormalizeEmail(u.Email)
 	_, err := r.pool.Exec(ctx, `
 		UPDATE users SET
 			username = $1,
+			email_verified_at = CASE WHEN email IS DISTINCT FROM $2 THEN NULL ELSE email_verified_at END,
 			email = $2,
-			password_hash = $3
-		WHERE id = $4`,
-		u.Username, u.Email, u.Password, u.ID)
+			password_hash = $3,
+			role = $4,
+			max_versions = $5,
+			quota_bytes = $6
+		WHERE id = $7`,
+		u.Username, u.Email, u.Password, u.Role, u.MaxVersions, u.QuotaBytes, u.ID)
+	return err
+}
+
+// ByEmail finds the account with email in any case. Addresses are unique
+// by lower(email), so there is at most one.
+func (r *UsersPGX) ByEmail(ctx context.Context, email string) (*models.User, error) {
+	u, err := scanUser(r.pool.QueryRow(ctx, `
+    SELECT `+userColumns+`
+    FROM users WHERE lower(email)=lower($1)`, email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// MarkEmailVerified verifies email for the user, unless their address has
+// changed since.
+func (r *UsersPGX) MarkEmailVerified(ctx context.Context, id, email string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET email_verified_at = NOW()
+		WHERE id = $1 AND email = $2`, id, email)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *UsersPGX) SetPassword(ctx context.Context, id, passwordHash string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, id)
 	return err
 }
 
@@ -69,7 +117,7 @@ func (r *UsersPGX) Delete(ctx context.Context, id string) error {
 
 func (r *UsersPGX) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, username, email, password_hash, created_at
+		SELECT `+userColumns+`
 		FROM users
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2`, limit, offset)
@@ -80,11 +128,11 @@ func (r *UsersPGX) List(ctx context.Context, limit, offset int) ([]*models.User,
 
 	var users []*models.User
 	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.CreatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, &u)
+		users = append(users, u)
 	}
 	return users, nil
 }
@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+type FileVersions interface {
+	List(ctx context.Context, fileID string) ([]*models.FileVersion, error)
+	ByNumber(ctx context.Context, fileID string, version int) (*models.FileVersion, error)
+	// Add appends v as the newest version of its file and makes it current,
+	// failing with ErrQuotaExceeded if it takes the owner past quota.
+	Add(ctx context.Context, v *models.FileVersion, quota int64) error
+	// Promote makes an existing version the current one. It reports false if there is no such version.
+	Promote(ctx context.Context, fileID string, version int) (bool, error)
+	Delete(ctx context.Context, id string) error
+}
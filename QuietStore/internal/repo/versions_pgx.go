@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type VersionsPGX struct{ pool *pgxpool.Pool }
+
+func NewVersionsPGX(pool *pgxpool.Pool) *VersionsPGX { return &VersionsPGX{pool: pool} }
+
+const versionColumns = `id, file_id, version, object_key, size_bytes, content_type, sha256, blob_sha256, wrapped_key, uploaded_by, created_at`
+
+func scanVersion(row pgx.Row) (*models.FileVersion, error) {
+	var v models.FileVersion
+	var ct, sum *string
+	if err := row.Scan(&v.ID, &v.FileID, &v.Version, &v.ObjectKey, &v.SizeBytes, &ct, &sum, &v.BlobSHA256, &v.WrappedKey, &v.UploadedBy, &v.CreatedAt); err != nil {
+		return nil, err
+	}
+	if ct != nil {
+		v.ContentType = *ct
+	}
+	if sum != nil {
+		v.SHA256 = *sum
+	}
+	return &v, nil
+}
+
+func (r *VersionsPGX) List(ctx context.Context, fileID string) ([]*models.FileVersion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+versionColumns+`
+		FROM file_versions
+		WHERE file_id=$1
+		ORDER BY version`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.FileVersion
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (r *VersionsPGX) ByNumber(ctx context.Context, fileID string, version int) (*models.FileVersion, error) {
+	v, err := scanVersion(r.pool.QueryRow(ctx, `
+		SELECT `+versionColumns+` FROM file_versions WHERE file_id=$1 AND version=$2`, fileID, version))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func (r *VersionsPGX) Add(ctx context.Context, v *models.FileVersion, quota int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the file so concurrent uploads get distinct version numbers
+	var ownerID string
+	if err := tx.QueryRow(ctx, `SELECT owner_user_id FROM files WHERE id=$1 FOR UPDATE`, v.FileID).Scan(&ownerID); err != nil {
+		return err
+	}
+	if err := addUsage(ctx, tx, ownerID, v.SizeBytes, 0, quota); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(MAX(version), 0) + 1 FROM file_versions WHERE file_id=$1`, v.FileID).Scan(&v.Version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO file_versions (id, file_id, version, object_key, size_bytes, content_type, sha256, blob_sha256, wrapped_key, uploaded_by, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
+		v.ID, v.FileID, v.Version, v.ObjectKey, v.SizeBytes, v.ContentType, v.SHA256, v.BlobSHA256, v.WrappedKey, v.UploadedBy, v.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE files
+		   SET current_version = $1, object_key = $2, size_bytes = $3, content_type = $4, sha256 = $5, blob_sha256 = $6,
+		       wrapped_key = $7, updated_at = $8
+		 WHERE id = $9`,
+		v.Version, v.ObjectKey, v.SizeBytes, v.ContentType, v.SHA256, v.BlobSHA256, v.WrappedKey, v.CreatedAt, v.FileID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *VersionsPGX) Promote(ctx context.Context, fileID string, version int) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE files f
+		   SET current_version = v.version, object_key = v.object_key, size_bytes = v.size_bytes,
+		       content_type = COALESCE(v.content_type, ''), sha256 = COALESCE(v.sha256, ''),
+		       blob_sha256 = v.blob_sha256, wrapped_key = v.wrapped_key, updated_at = NOW()
+		  FROM file_versions v
+		 WHERE f.id = $1 AND v.file_id = f.id AND v.version = $2`,
+		fileID, version)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *VersionsPGX) Delete(ctx context.Context, id string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		ownerID string
+		size    int64
+	)
+	err = tx.QueryRow(ctx, `
+		DELETE FROM file_versions v
+		USING files f
+		WHERE v.id = $1 AND f.id = v.file_id
+		RETURNING f.owner_user_id, v.size_bytes`, id).Scan(&ownerID, &size)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := addUsage(ctx, tx, ownerID, -size, 0, 0); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+const denylistRetryDelay = 5 * time.Second
+
+// AccessTokenDenylist rejects access tokens whose session was ended before
+// they expired. The list is kept in memory, so checking a token costs no
+// query, and Run keeps it current with the revocations every replica
+// announces. While the database can't be reached, revocations made by other
+// replicas arrive late, but never those made by this one.
+type AccessTokenDenylist struct {
+	revoked repo.RevokedAccessTokens
+
+	mu   sync.RWMutex
+	jtis map[string]time.Time
+}
+
+func NewAccessTokenDenylist(revoked repo.RevokedAccessTokens) *AccessTokenDenylist {
+	return &AccessTokenDenylist{revoked: revoked, jtis: map[string]time.Time{}}
+}
+
+// Load replaces the in-memory list with the one in the database.
+func (d *AccessTokenDenylist) Load(ctx context.Context) error {
+	now := time.Now()
+	list, err := d.revoked.Active(ctx, now)
+	if err != nil {
+		return err
+	}
+	jtis := make(map[string]time.Time, len(list))
+	for _, t := range list {
+		jtis[t.JTI] = t.ExpiresAt
+	}
+	d.mu.Lock()
+	d.jtis = jtis
+	d.mu.Unlock()
+	return nil
+}
+
+// Run follows revocations until ctx ends, reconnecting when the connection
+// drops. Each time it starts listening it reloads the whole list, since
+// announcements made in between are lost.
+func (d *AccessTokenDenylist) Run(ctx context.Context) {
+	for {
+		err := d.revoked.Listen(ctx, d.Load, d.add)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[access-denylist] listening failed, retrying in %s: %v", denylistRetryDelay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(denylistRetryDelay):
+		}
+	}
+}
+
+// Revoked reports whether the access token with jti was revoked.
+func (d *AccessTokenDenylist) Revoked(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	exp, ok := d.jtis[jti]
+	return ok && time.Now().Before(exp)
+}
+
+// RevokeSession revokes the access tokens issued in one of the user's sessions.
+func (d *AccessTokenDenylist) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	return d.revoke(ctx, userID, sessionID)
+}
+
+// RevokeUser revokes every access token the user holds.
+func (d *AccessTokenDenylist) RevokeUser(ctx context.Context, userID string) error {
+	return d.revoke(ctx, userID, "")
+}
+
+func (d *AccessTokenDenylist) revoke(ctx context.Context, userID, sessionID string) error {
+	list, err := d.revoked.RevokeSessions(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	// applied right away rather than when our own announcement comes back
+	for _, t := range list {
+		d.add(t)
+	}
+	return nil
+}
+
+// add records a revocation and forgets the ones that have expired.
+func (d *AccessTokenDenylist) add(t *models.RevokedAccessToken) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for jti, exp := range d.jtis {
+		if !now.Before(exp) {
+			delete(d.jtis, jti)
+		}
+	}
+	if now.Before(t.ExpiresAt) {
+		d.jtis[t.JTI] = t.ExpiresAt
+	}
+}
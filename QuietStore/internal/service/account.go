@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidAccountToken  = errors.New("invalid or expired token")
+	ErrNoEmail              = errors.New("account has no email address")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+	mailTimeout          = 30 * time.Second
+)
+
+// AccountService runs the emailed account flows: verifying an address and
+// resetting a forgotten password. Tokens are JWTs signed for a per-purpose
+// audience, and are single use because their hash is redeemed in the database.
+type AccountService struct {
+	users     repo.Users
+	tokens    repo.AccountTokens
+	refresh   repo.RefreshTokens
+	keys      *JWTKeys
+	passwords PasswordHasher
+	policy    *PasswordPolicy
+	denylist  *AccessTokenDenylist
+	mailer    Mailer
+	baseURL   string
+}
+
+// NewAccountService puts links to baseURL in the emails it sends: the web app
+// is expected to serve /verify-email and /reset-password and post the token
+// back to the API.
+func NewAccountService(users repo.Users, tokens repo.AccountTokens, refresh repo.RefreshTokens, keys *JWTKeys, passwords PasswordHasher, policy *PasswordPolicy, denylist *AccessTokenDenylist, mailer Mailer, baseURL string) *AccountService {
+	return &AccountService{users: users, tokens: tokens, refresh: refresh, keys: keys, passwords: passwords, policy: policy, denylist: denylist, mailer: mailer, baseURL: baseURL}
+}
+
+func (s *AccountService) audience(purpose string) string {
+	return s.keys.Audience() + ":" + purpose
+}
+
+func (s *AccountService) issue(ctx context.Context, u *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	t := &models.AccountToken{
+		ID:        models.GenerateAccountTokenID(),
+		UserID:    u.ID,
+		Purpose:   purpose,
+		Email:     u.Email,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	token, err := s.keys.SignFor(s.audience(purpose), jwt.MapClaims{
+		"sub": u.ID,
+		"jti": t.ID,
+		"iat": now.Unix(),
+		"exp": t.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	t.TokenHash = hashSecretCode(token)
+	if err := s.tokens.Create(ctx, t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeem checks a token's signature before looking it up, so forged tokens
+// never reach the database, then uses it up.
+func (s *AccountService) redeem(ctx context.Context, purpose, token string) (*models.AccountToken, error) {
+	if _, err := s.keys.VerifyFor(s.audience(purpose), token); err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+	t, err := s.tokens.Use(ctx, purpose, hashSecretCode(token), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrInvalidAccountToken
+	}
+	return t, nil
+}
+
+// send mails in the background. A request that mails only when an account
+// exists must not take longer than one that doesn't.
+func (s *AccountService) send(m Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, m); err != nil {
+			log.Printf("[mail] sending %q failed: %v", m.Subject, err)
+		}
+	}()
+}
+
+func (s *AccountService) link(path, token string) string {
+	return s.baseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// RequestEmailVerification mails the user a link proving they own their
+// address.
+func (s *AccountService) RequestEmailVerification(ctx context.Context, userID string) error {
+	u, err := s.users.ByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrUserNotFound
+	}
+	if u.Email == "" {
+		return ErrNoEmail
+	}
+	if u.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.issue(ctx, u, models.PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	s.send(Mail{
+		To:      u.Email,
+		Subject: "Verify your QuietStore email address",
+		Body: fmt.Sprintf("Hi %s,\n\nconfirm this is your email address by opening the link below. It is valid for %s.\n\n%s\n\nIf you didn't ask for this, you can ignore this email.\n",
+			u.Username, emailVerificationTTL, s.link("/verify-email", token)),
+	})
+	return nil
+}
+
+// VerifyEmail marks the address the token was sent to as verified, provided
+// it is still the user's address.
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	t, err := s.redeem(ctx, models.PurposeEmailVerification, token)
+	if err != nil {
+		return err
+	}
+	ok, err := s.users.MarkEmailVerified(ctx, t.UserID, t.Email)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidAccountToken
+	}
+	return nil
+}
+
+// RequestPasswordReset mails a reset link if an account has the address. It
+// says nothing either way, so it can't be used to find accounts.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	u, err := s.users.ByEmail(ctx, email)
+	if err != nil || u == nil {
+		return err
+	}
+
+	token, err := s.issue(ctx, u, models.PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	s.send(Mail{
+		To:      u.Email,
+		Subject: "Reset your QuietStore password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. Choose a new one by opening the link below. It is valid for %s.\n\n%s\n\nIf it wasn't you, ignore this email and your password stays as it is.\n",
+			u.Username, passwordResetTTL, s.link("/reset-password", token)),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password and logs the user out everywhere, since
+// whoever knew the old one may hold a session. The token only counts if it
+// was sent to the user's current address. The password is checked against
+// the policy before the token is used up, so a rejected one can be retried
+// with the same link.
+func (s *AccountService) ResetPassword(ctx context.Context, token, password string) error {
+	claims, err := s.keys.VerifyFor(s.audience(models.PurposePasswordReset), token)
+	if err != nil {
+		return ErrInvalidAccountToken
+	}
+	sub, _ := claims["sub"].(string)
+	u, err := s.users.ByID(ctx, sub)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrInvalidAccountToken
+	}
+	if err := s.policy.Check(password, u.Username, u.Email); err != nil {
+		return err
+	}
+
+	t, err := s.redeem(ctx, models.PurposePasswordReset, token)
+	if err != nil {
+		return err
+	}
+	// a link mailed to an address the account has since dropped no longer
+	// proves anything about who is using it
+	if t.UserID != u.ID || t.Email != u.Email {
+		return ErrInvalidAccountToken
+	}
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.users.SetPassword(ctx, t.UserID, hash); err != nil {
+		return err
+	}
+	// other reset links in flight are no longer wanted
+	if err := s.tokens.Invalidate(ctx, t.UserID, models.PurposePasswordReset); err != nil {
+		return err
+	}
+	if _, err := s.refresh.RevokeAllSessions(ctx, t.UserID); err != nil {
+		return err
+	}
+	if err := s.denylist.RevokeUser(ctx, t.UserID); err != nil {
+		return err
+	}
+	// the link proves the mailbox works too
+	_, _ = s.users.MarkEmailVerified(ctx, t.UserID, t.Email)
+	return nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+// APITokenPrefix starts every personal access token, which tells them apart
+// from JWTs in the Authorization header.
+const APITokenPrefix = "qs_"
+
+var (
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrInvalidAPIToken  = errors.New("invalid or expired api token")
+	ErrInvalidScopes    = errors.New("scopes must be a non-empty list of files:read, files:write, shares:read, shares:write")
+)
+
+// APITokenService issues personal access tokens and resolves them back to
+// their user.
+type APITokenService struct {
+	tokens repo.APITokens
+	users  repo.Users
+}
+
+func NewAPITokenService(tokens repo.APITokens, users repo.Users) *APITokenService {
+	return &APITokenService{tokens: tokens, users: users}
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken returns the new token's record and the token itself, which is
+// not stored and can't be shown again.
+func (s *APITokenService) CreateToken(ctx context.Context, userID string, req models.CreateAPITokenRequest) (*models.APIToken, string, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, "", ErrInvalidName
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", ErrInvalidScopes
+	}
+	for _, sc := range req.Scopes {
+		if !models.ValidScope(sc) {
+			return nil, "", ErrInvalidScopes
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	t := &models.APIToken{
+		ID:        models.GenerateAPITokenID(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: hashAPIToken(token),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.tokens.Create(ctx, t); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+func (s *APITokenService) ListTokens(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	return s.tokens.ListByUser(ctx, userID)
+}
+
+func (s *APITokenService) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	ok, err := s.tokens.Revoke(ctx, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented token to its record and owner.
+func (s *APITokenService) Authenticate(ctx context.Context, token string) (*models.APIToken, *models.User, error) {
+	t, err := s.tokens.Use(ctx, hashAPIToken(token), time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	if t == nil {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	u, err := s.users.ByID(ctx, t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u == nil {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	return t, u, nil
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var (
+	ErrUnknownMasterKey = errors.New("data key was wrapped with a master key that is not configured")
+	// ErrEncrypted is returned for operations that would hand out an encrypted
+	// object's raw bytes, such as presigned downloads.
+	ErrEncrypted = errors.New("file is encrypted at rest and must be downloaded through the API")
+)
+
+const (
+	masterKeyIDSize = 8
+	dataKeySize     = 32
+	// sealChunkSize is how much plaintext each GCM chunk holds. It divides
+	// uploadPartSize so resumable upload parts encrypt independently.
+	sealChunkSize = 64 << 10
+	sealTagSize   = 16
+	sealedChunk   = sealChunkSize + sealTagSize
+)
+
+type masterKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// Keyring wraps per-object data keys with a master key. Keys from before a
+// rotation stay in the ring so data keys they wrapped can still be opened.
+type Keyring struct {
+	active   *masterKey
+	previous []*masterKey
+}
+
+// NewKeyring builds a keyring from 32-byte AES-256 master keys. New data keys
+// are wrapped with active; previous ones are only used to unwrap.
+func NewKeyring(active []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	var err error
+	if k.active, err = newMasterKey(active); err != nil {
+		return nil, err
+	}
+	for _, p := range previous {
+		mk, err := newMasterKey(p)
+		if err != nil {
+			return nil, err
+		}
+		k.previous = append(k.previous, mk)
+	}
+	return k, nil
+}
+
+func newMasterKey(key []byte) (*masterKey, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	// the id only has to tell configured keys apart, not hide them
+	sum := sha256.Sum256(key)
+	return &masterKey{id: sum[:masterKeyIDSize], aead: aead}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ActiveID identifies the master key new data keys are wrapped with. Every
+// wrapped key starts with the id of the master key that wrapped it.
+func (k *Keyring) ActiveID() []byte {
+	return k.active.id
+}
+
+// newDataKey returns a fresh data key and its wrapped form.
+func (k *Keyring) newDataKey() ([]byte, []byte, error) {
+	dk := make([]byte, dataKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := k.wrap(dk)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dk, wrapped, nil
+}
+
+// wrap seals dk as id || nonce || ciphertext under the active master key.
+func (k *Keyring) wrap(dk []byte) ([]byte, error) {
+	mk := k.active
+	nonce := make([]byte, mk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, mk.id...), nonce...)
+	return mk.aead.Seal(out, nonce, dk, mk.id), nil
+}
+
+func (k *Keyring) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < masterKeyIDSize {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	id := wrapped[:masterKeyIDSize]
+	for _, mk := range append([]*masterKey{k.active}, k.previous...) {
+		if !bytes.Equal(mk.id, id) {
+			continue
+		}
+		rest := wrapped[masterKeyIDSize:]
+		ns := mk.aead.NonceSize()
+		if len(rest) < ns {
+			return nil, errors.New("wrapped data key is truncated")
+		}
+		dk, err := mk.aead.Open(nil, rest[:ns], rest[ns:], id)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key: %w", err)
+		}
+		return dk, nil
+	}
+	return nil, ErrUnknownMasterKey
+}
+
+// Rewrap re-wraps a data key under the active master key.
+func (k *Keyring) Rewrap(wrapped []byte) ([]byte, error) {
+	dk, err := k.unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(dk)
+}
+
+// RotateMasterKey rewraps every stored data key that isn't wrapped with the
+// keyring's active master key, and returns how many it rewrapped. Objects
+// themselves are not touched. Rows changed concurrently are picked up again on
+// the next pass.
+func RotateMasterKey(ctx context.Context, keys repo.DataKeys, kr *Keyring) (int, error) {
+	rewrapped := 0
+	for {
+		stale, err := keys.Stale(ctx, kr.ActiveID(), 100)
+		if err != nil {
+			return rewrapped, err
+		}
+		if len(stale) == 0 {
+			return rewrapped, nil
+		}
+		for _, dk := range stale {
+			wrapped, err := kr.Rewrap(dk.Wrapped)
+			if err != nil {
+				return rewrapped, fmt.Errorf("%s %s: %w", dk.Table, dk.RowID, err)
+			}
+			ok, err := keys.Rewrap(ctx, dk, wrapped)
+			if err != nil {
+				return rewrapped, err
+			}
+			if ok {
+				rewrapped++
+			}
+		}
+		log.Printf("[rotate-master-key] rewrapped %d data keys so far", rewrapped)
+	}
+}
+
+// Objects are encrypted as a sequence of independently sealed chunks of
+// sealChunkSize plaintext bytes, each followed by its GCM tag. The nonce is
+// the chunk index plus a flag on the last chunk, so chunks can't be reordered
+// and a truncated object fails to open. Data keys are never reused across
+// objects, which keeps the counter nonces unique.
+
+// sealedSize is the stored size of an object with n plaintext bytes.
+func sealedSize(n int64) int64 {
+	chunks := max((n+sealChunkSize-1)/sealChunkSize, 1)
+	return n + chunks*sealTagSize
+}
+
+func chunkNonce(i int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(i))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// sealChunks encrypts data, which starts at chunk first. Only the last part of
+// an object may end mid-chunk, and it must be sealed with final set.
+func sealChunks(aead cipher.AEAD, data []byte, first int64, final bool) []byte {
+	out := make([]byte, 0, sealedSize(int64(len(data))))
+	for i := first; ; i++ {
+		n := min(len(data), sealChunkSize)
+		last := final && n == len(data)
+		out = aead.Seal(out, chunkNonce(i, last), data[:n], nil)
+		data = data[n:]
+		if len(data) == 0 {
+			return out
+		}
+	}
+}
+
+// sealPending encrypts bytes that are stored temporarily and will be re-read
+// and re-chunked later. They get a random nonce with the high byte set so it
+// can never collide with a chunk nonce.
+func sealPending(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce[1:]); err != nil {
+		return nil, err
+	}
+	nonce[0] = 0x80
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func openPending(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < 12 {
+		return nil, errors.New("pending part is truncated")
+	}
+	return aead.Open(nil, sealed[:12], sealed[12:], nil)
+}
+
+// sealReader encrypts everything read from src.
+type sealReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	idx    int64
+	buf    []byte
+	peek   []byte
+	sealed []byte
+	out    []byte
+	done   bool
+}
+
+func newSealReader(src io.Reader, dk []byte) (*sealReader, error) {
+	aead, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+	return &sealReader{src: src, aead: aead, buf: make([]byte, sealChunkSize)}, nil
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// fill seals the next chunk. A full chunk is only known to be the last one
+// once src has nothing more, so one byte is read ahead.
+func (s *sealReader) fill() error {
+	n := copy(s.buf, s.peek)
+	s.peek = nil
+	m, err := io.ReadFull(s.src, s.buf[n:])
+	n += m
+	final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		var one [1]byte
+		if _, err := io.ReadFull(s.src, one[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			final = true
+		} else {
+			s.peek = one[:]
+		}
+	}
+	s.sealed = s.aead.Seal(s.sealed[:0], chunkNonce(s.idx, final), s.buf[:n], nil)
+	s.out = s.sealed
+	s.idx++
+	s.done = final
+	return nil
+}
+
+// openReader decrypts chunks read from src, starting at chunk first of an
+// object with size plaintext bytes.
+type openReader struct {
+	src  io.Reader
+	aead cipher.AEAD
+	idx  int64
+	last int64
+	buf  []byte
+	out  []byte
+}
+
+func newOpenReader(src io.Reader, dk []byte, size, first int64) (*openReader, error) {
+	aead, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+	last := max((size+sealChunkSize-1)/sealChunkSize-1, 0)
+	return &openReader{src: src, aead: aead, idx: first, last: last, buf: make([]byte, sealedChunk)}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.idx > o.last {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(o.src, o.buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := o.aead.Open(o.buf[:0], chunkNonce(o.idx, o.idx == o.last), o.buf[:n], nil)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt chunk %d: %w", o.idx, err)
+		}
+		o.out = plain
+		o.idx++
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+// sealedRange maps a plaintext range onto the chunks that hold it: the stored
+// byte range to fetch, the first chunk's index and how many plaintext bytes of
+// it to skip.
+func sealedRange(size, offset, length int64) (start, end, first, skip int64) {
+	first = offset / sealChunkSize
+	lastChunk := (offset + length - 1) / sealChunkSize
+	start = first * sealedChunk
+	end = min((lastChunk+1)*sealedChunk, sealedSize(size)) - 1
+	return start, end, first, offset % sealChunkSize
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
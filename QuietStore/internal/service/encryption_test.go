@@ -0,0 +1,313 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func seal(t *testing.T, plain, dk []byte) []byte {
+	t.Helper()
+	sr, err := newSealReader(bytes.NewReader(plain), dk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func open(sealed, dk []byte, size, first int64) ([]byte, error) {
+	or, err := newOpenReader(bytes.NewReader(sealed), dk, size, first)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(or)
+}
+
+var sealSizes = []struct {
+	name string
+	size int
+}{
+	{"empty", 0},
+	{"one byte", 1},
+	{"chunk less one", sealChunkSize - 1},
+	{"one chunk", sealChunkSize},
+	{"chunk plus one", sealChunkSize + 1},
+	{"three chunks", 3 * sealChunkSize},
+	{"three chunks and a bit", 3*sealChunkSize + 17},
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	for _, tt := range sealSizes {
+		t.Run(tt.name, func(t *testing.T) {
+			dk := randomBytes(t, dataKeySize)
+			plain := randomBytes(t, tt.size)
+
+			sealed := seal(t, plain, dk)
+			if got, want := int64(len(sealed)), sealedSize(int64(tt.size)); got != want {
+				t.Fatalf("sealed %d bytes, sealedSize says %d", got, want)
+			}
+			// uploads sealed a part at a time must come out the same
+			aead, _ := newGCM(dk)
+			if parts := sealChunks(aead, plain, 0, true); !bytes.Equal(parts, sealed) {
+				t.Fatal("sealChunks and sealReader disagree")
+			}
+
+			got, err := open(sealed, dk, int64(tt.size), 0)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("round trip changed the plaintext")
+			}
+			if _, err := open(sealed, randomBytes(t, dataKeySize), int64(tt.size), 0); err == nil {
+				t.Fatal("opened with the wrong data key")
+			}
+		})
+	}
+}
+
+// slowReader hands out a few bytes per Read, so chunks arrive in pieces.
+type slowReader struct{ r io.Reader }
+
+func (s slowReader) Read(p []byte) (int, error) {
+	return s.r.Read(p[:min(len(p), 1000)])
+}
+
+func TestSealReaderShortReads(t *testing.T) {
+	dk := randomBytes(t, dataKeySize)
+	plain := randomBytes(t, 2*sealChunkSize+5)
+
+	sr, err := newSealReader(slowReader{bytes.NewReader(plain)}, dk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed, seal(t, plain, dk)) {
+		t.Fatal("short reads changed the sealed bytes")
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	dk := randomBytes(t, dataKeySize)
+	size := 3*sealChunkSize + 100
+	plain := randomBytes(t, size)
+	sealed := seal(t, plain, dk)
+
+	chunk := func(i int) []byte { return sealed[i*sealedChunk : min((i+1)*sealedChunk, len(sealed))] }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	flipped := bytes.Clone(sealed)
+	flipped[sealedChunk+10] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		size   int64
+	}{
+		{"last chunk cut off", sealed[:3*sealedChunk], int64(size)},
+		{"cut mid chunk", sealed[:len(sealed)-10], int64(size)},
+		{"cut to a chunk boundary, claiming the shorter size", sealed[:3*sealedChunk], 3 * sealChunkSize},
+		{"chunks swapped", join(chunk(1), chunk(0), chunk(2), chunk(3)), int64(size)},
+		{"chunk repeated", join(chunk(0), chunk(0), chunk(2), chunk(3)), int64(size)},
+		{"bit flipped", flipped, int64(size)},
+		{"empty", nil, int64(size)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := open(tt.sealed, dk, tt.size, 0); err == nil {
+				t.Fatal("tampered object opened")
+			}
+		})
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	dk := randomBytes(t, dataKeySize)
+	size := int64(3*sealChunkSize + 100)
+	plain := randomBytes(t, int(size))
+	sealed := seal(t, plain, dk)
+
+	tests := []struct {
+		name           string
+		offset, length int64
+	}{
+		{"start of first chunk", 0, 10},
+		{"inside a chunk", 1000, 2000},
+		{"across a boundary", sealChunkSize - 5, 10},
+		{"exactly the second chunk", sealChunkSize, sealChunkSize},
+		{"across several chunks", 10, 2*sealChunkSize + 50},
+		{"tail of the last chunk", size - 7, 7},
+		{"whole object", 0, size},
+		{"last byte", size - 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, first, skip := sealedRange(size, tt.offset, tt.length)
+			or, err := newOpenReader(bytes.NewReader(sealed[start:end+1]), dk, size, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.CopyN(io.Discard, or, skip); err != nil {
+				t.Fatalf("skip: %v", err)
+			}
+			got := make([]byte, tt.length)
+			if _, err := io.ReadFull(or, got); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, plain[tt.offset:tt.offset+tt.length]) {
+				t.Fatal("range opened to the wrong bytes")
+			}
+		})
+	}
+
+	// a chunk opened at the wrong index doesn't decrypt
+	if _, err := open(sealed[sealedChunk:2*sealedChunk], dk, size, 2); err == nil {
+		t.Fatal("chunk 1 opened as chunk 2")
+	}
+}
+
+func TestPendingSeal(t *testing.T) {
+	dk := randomBytes(t, dataKeySize)
+	aead, _ := newGCM(dk)
+	plain := randomBytes(t, 1000)
+
+	sealed, err := sealPending(aead, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed[0]&0x80 == 0 {
+		t.Fatal("pending nonce could collide with a chunk nonce")
+	}
+	got, err := openPending(aead, sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("openPending = %v", err)
+	}
+	if _, err := openPending(aead, sealed[:11]); err == nil {
+		t.Fatal("truncated pending part opened")
+	}
+}
+
+func TestKeyringWrap(t *testing.T) {
+	kr, err := NewKeyring(randomBytes(t, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, wrapped, err := kr.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wrapped, kr.ActiveID()) {
+		t.Fatal("wrapped key doesn't start with the master key id")
+	}
+	got, err := kr.unwrap(wrapped)
+	if err != nil || !bytes.Equal(got, dk) {
+		t.Fatalf("unwrap = %v", err)
+	}
+
+	bad := bytes.Clone(wrapped)
+	bad[len(bad)-1] ^= 1
+	if _, err := kr.unwrap(bad); err == nil {
+		t.Fatal("tampered wrapped key unwrapped")
+	}
+	if _, err := kr.unwrap(wrapped[:4]); err == nil {
+		t.Fatal("truncated wrapped key unwrapped")
+	}
+	if _, err := NewKeyring(randomBytes(t, 16)); err == nil {
+		t.Fatal("accepted a 16 byte master key")
+	}
+}
+
+// memDataKeys stands in for the rows holding wrapped keys. rewrapFails makes
+// the first rewrap of a row lose a race with a concurrent write.
+type memDataKeys struct {
+	keys        map[string][]byte
+	rewrapFails map[string]bool
+}
+
+func (m *memDataKeys) Stale(_ context.Context, id []byte, limit int) ([]*models.DataKey, error) {
+	var out []*models.DataKey
+	for row, w := range m.keys {
+		if !bytes.HasPrefix(w, id) && len(out) < limit {
+			out = append(out, &models.DataKey{Table: "files", RowID: row, Wrapped: w})
+		}
+	}
+	return out, nil
+}
+
+func (m *memDataKeys) Rewrap(_ context.Context, k *models.DataKey, wrapped []byte) (bool, error) {
+	if m.rewrapFails[k.RowID] {
+		delete(m.rewrapFails, k.RowID)
+		return false, nil
+	}
+	if !bytes.Equal(m.keys[k.RowID], k.Wrapped) {
+		return false, nil
+	}
+	m.keys[k.RowID] = wrapped
+	return true, nil
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	oldKey, newKey := randomBytes(t, dataKeySize), randomBytes(t, dataKeySize)
+	before, _ := NewKeyring(oldKey)
+	after, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memDataKeys{keys: map[string][]byte{}, rewrapFails: map[string]bool{"row-3": true}}
+	plain := map[string][]byte{}
+	for _, row := range []string{"row-1", "row-2", "row-3"} {
+		dk, wrapped, err := before.newDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain[row], store.keys[row] = dk, wrapped
+	}
+	// already on the new key, left alone
+	dk, wrapped, _ := after.newDataKey()
+	plain["row-4"], store.keys["row-4"] = dk, wrapped
+
+	n, err := RotateMasterKey(context.Background(), store, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("rewrapped %d keys, want 3", n)
+	}
+
+	current, _ := NewKeyring(newKey)
+	for row, w := range store.keys {
+		if !bytes.HasPrefix(w, after.ActiveID()) {
+			t.Fatalf("%s still wrapped with the old key", row)
+		}
+		dk, err := current.unwrap(w)
+		if err != nil || !bytes.Equal(dk, plain[row]) {
+			t.Fatalf("%s doesn't unwrap to its data key without the old master key: %v", row, err)
+		}
+	}
+
+	_, stale, _ := before.newDataKey()
+	if _, err := current.Rewrap(stale); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("rewrap under a dropped master key = %v, want ErrUnknownMasterKey", err)
+	}
+}
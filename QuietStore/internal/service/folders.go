@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var ErrInvalidName = errors.New("invalid name")
+
+// FolderService manages the folder tree. It only touches metadata, so a single
+// implementation serves every storage backend.
+type FolderService struct {
+	folders repo.Folders
+	files   repo.Files
+}
+
+func NewFolderService(folders repo.Folders, files repo.Files) *FolderService {
+	return &FolderService{folders: folders, files: files}
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// optionalID turns the API's "" (root) into the repo's nil parent.
+func optionalID(id string) *string {
+	if id == "" {
+		return nil
+	}
+	return &id
+}
+
+// folder returns a folder the user owns, or ErrFolderNotFound.
+func (s *FolderService) folder(ctx context.Context, userID, folderID string) (*models.Folder, error) {
+	f, err := s.folders.ByID(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil || f.OwnerUserID != userID {
+		return nil, repo.ErrFolderNotFound
+	}
+	return f, nil
+}
+
+func (s *FolderService) CreateFolder(ctx context.Context, userID, parentID, name string) (*models.Folder, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+	f := &models.Folder{
+		ID:          models.GenerateFolderID(),
+		OwnerUserID: userID,
+		ParentID:    optionalID(parentID),
+		Name:        name,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.folders.Create(ctx, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ListFolder returns what's inside a folder ("" for the root). With recursive
+// it returns everything below it instead of just the direct children.
+func (s *FolderService) ListFolder(ctx context.Context, userID, folderID string, recursive bool) (*models.FolderContents, error) {
+	out := &models.FolderContents{}
+	if folderID != "" {
+		f, err := s.folder(ctx, userID, folderID)
+		if err != nil {
+			return nil, err
+		}
+		out.Folder = f
+	}
+
+	parent := optionalID(folderID)
+	var err error
+	if recursive {
+		if out.Folders, err = s.folders.Subtree(ctx, userID, parent); err != nil {
+			return nil, err
+		}
+		out.Files, err = s.files.ListInSubtree(ctx, userID, parent)
+	} else {
+		if out.Folders, err = s.folders.Children(ctx, userID, parent); err != nil {
+			return nil, err
+		}
+		out.Files, err = s.files.ListInFolder(ctx, userID, parent)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Folders == nil {
+		out.Folders = []*models.Folder{}
+	}
+	if out.Files == nil {
+		out.Files = []*models.File{}
+	}
+	return out, nil
+}
+
+// UpdateFolder renames and/or moves a folder. A nil argument leaves that part
+// unchanged; a parentID of "" moves the folder to the root.
+func (s *FolderService) UpdateFolder(ctx context.Context, userID, folderID string, name, parentID *string) (*models.Folder, error) {
+	f, err := s.folder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	newName, newParent := f.Name, f.ParentID
+	if name != nil {
+		if !validName(*name) {
+			return nil, ErrInvalidName
+		}
+		newName = *name
+	}
+	if parentID != nil {
+		newParent = optionalID(*parentID)
+	}
+	return s.folders.Update(ctx, folderID, userID, newName, newParent)
+}
+
+func (s *FolderService) DeleteFolder(ctx context.Context, userID, folderID string, recursive bool) error {
+	return s.folders.Delete(ctx, folderID, userID, recursive)
+}
+
+// contains reports whether a live file of the user sits somewhere below folderID.
+func (s *FolderService) contains(ctx context.Context, userID, folderID, fileID string) (bool, error) {
+	f, err := s.files.ByID(ctx, fileID)
+	if err != nil || f == nil || f.OwnerUserID != userID || f.DeletedAt != nil {
+		return false, err
+	}
+	for parent := f.FolderID; parent != nil; {
+		if *parent == folderID {
+			return true, nil
+		}
+		p, err := s.folders.ByID(ctx, *parent)
+		if err != nil || p == nil {
+			return false, err
+		}
+		parent = p.ParentID
+	}
+	return false, nil
+}
+
+// MoveFile puts a file into a folder ("" for the root).
+func (s *FolderService) MoveFile(ctx context.Context, userID, fileID, folderID string) (*models.File, error) {
+	ok, err := s.files.Move(ctx, fileID, userID, optionalID(folderID))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return s.files.ByID(ctx, fileID)
+}
+
+// FileByPath resolves a slash separated path such as /reports/2025/q1.pdf to a file.
+func (s *FolderService) FileByPath(ctx context.Context, userID, path string) (*models.File, error) {
+	var segments []string
+	for _, seg := range strings.Split(path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	var parent *string
+	for _, name := range segments[:len(segments)-1] {
+		f, err := s.folders.Child(ctx, userID, parent, name)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, ErrFileNotFound
+		}
+		parent = &f.ID
+	}
+
+	f, err := s.files.ByName(ctx, userID, parent, segments[len(segments)-1])
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrFileNotFound
+	}
+	return f, nil
+}
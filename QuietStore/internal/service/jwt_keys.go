@@ -0,0 +1,206 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+const minRSAKeyBits = 2048
+
+// JWTKey is one access token signing key. Keys parsed from a public key only
+// verify tokens; they are what an old key is reduced to once it is retired.
+type JWTKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// ParseJWTKey reads an RSA (RS256) or Ed25519 (EdDSA) key from PEM. Private
+// keys may be PKCS#8 or PKCS#1, public keys PKIX.
+func ParseJWTKey(kid string, data []byte) (*JWTKey, error) {
+	if kid == "" {
+		return nil, errors.New("jwt key id is empty")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt key %s: no PEM block found", kid)
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt key %s: unsupported PEM block %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %s: %w", kid, err)
+	}
+
+	k := &JWTKey{kid: kid}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("jwt key %s: only RSA and Ed25519 keys are supported", kid)
+	}
+	if pub, ok := k.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("jwt key %s: RSA keys must be at least %d bits", kid, minRSAKeyBits)
+	}
+	return k, nil
+}
+
+// GenerateJWTKey makes a new Ed25519 signing key.
+func GenerateJWTKey(kid string) (*JWTKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTKey{kid: kid, method: jwt.SigningMethodEdDSA, private: priv, public: priv.Public()}, nil
+}
+
+func (k *JWTKey) KID() string {
+	return k.kid
+}
+
+// CanSign reports whether the key holds its private half.
+func (k *JWTKey) CanSign() bool {
+	return k.private != nil
+}
+
+func (k *JWTKey) jwk() models.JWK {
+	out := models.JWK{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		out.Kty = "RSA"
+		out.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		out.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		out.Kty, out.Crv = "OKP", "Ed25519"
+		out.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return out
+}
+
+// JWTKeys signs access tokens with its active key and verifies them against
+// every key it holds, identified by the kid header. Keeping the previous key in
+// the set after a rotation lets tokens it signed live out their lifetime.
+type JWTKeys struct {
+	active   *JWTKey
+	keys     map[string]*JWTKey
+	issuer   string
+	audience string
+}
+
+// NewJWTKeys builds a key set that signs with active and also verifies with
+// others. Tokens are issued for, and only accepted from, issuer and audience.
+func NewJWTKeys(issuer, audience string, active *JWTKey, others ...*JWTKey) (*JWTKeys, error) {
+	if !active.CanSign() {
+		return nil, fmt.Errorf("jwt key %s is a public key and can't sign", active.kid)
+	}
+	ks := &JWTKeys{
+		active:   active,
+		keys:     map[string]*JWTKey{active.kid: active},
+		issuer:   issuer,
+		audience: audience,
+	}
+	for _, k := range others {
+		if _, dup := ks.keys[k.kid]; dup {
+			return nil, fmt.Errorf("duplicate jwt key id %q", k.kid)
+		}
+		ks.keys[k.kid] = k
+	}
+	return ks, nil
+}
+
+// Sign stamps claims with the issuer and audience and signs them with the
+// active key.
+func (ks *JWTKeys) Sign(claims jwt.MapClaims) (string, error) {
+	return ks.SignFor(ks.audience, claims)
+}
+
+// SignFor signs a token for another audience than the API, so it can't be
+// used as an access token.
+func (ks *JWTKeys) SignFor(audience string, claims jwt.MapClaims) (string, error) {
+	claims["iss"] = ks.issuer
+	claims["aud"] = audience
+	tok := jwt.NewWithClaims(ks.active.method, claims)
+	tok.Header["kid"] = ks.active.kid
+	return tok.SignedString(ks.active.private)
+}
+
+// Verify checks a token's signature, lifetime, issuer and audience and returns
+// its claims.
+func (ks *JWTKeys) Verify(token string) (jwt.MapClaims, error) {
+	return ks.VerifyFor(ks.audience, token)
+}
+
+// VerifyFor is Verify for tokens made with SignFor.
+func (ks *JWTKeys) VerifyFor(audience, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		// a key only verifies the algorithm it was made for
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return k.public, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(ks.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAccessToken, err)
+	}
+	return claims, nil
+}
+
+// Audience is the audience of access tokens.
+func (ks *JWTKeys) Audience() string {
+	return ks.audience
+}
+
+// JWKS lists the public half of every key, so other services can verify
+// access tokens without holding a signing key.
+func (ks *JWTKeys) JWKS() models.JWKSet {
+	set := models.JWKSet{Keys: make([]models.JWK, 0, len(ks.keys))}
+	for _, k := range ks.keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
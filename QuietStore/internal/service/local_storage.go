@@ -0,0 +1,488 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+// FsyncMode controls how hard LocalStorageService tries to make a write durable.
+type FsyncMode string
+
+const (
+	FsyncNone FsyncMode = "none" // rely on the OS page cache
+	FsyncFile FsyncMode = "file" // fsync the object before rename
+	FsyncFull FsyncMode = "full" // fsync the object and its parent directory after rename
+)
+
+type LocalStorageService struct {
+	basePath    string
+	fsync       FsyncMode
+	files       repo.Files
+	versions    repo.FileVersions
+	users       repo.Users
+	blobs       repo.Blobs
+	maxVersions int
+	quota       *QuotaPolicy
+	dedup       bool
+}
+
+// LocalOptions holds the LocalStorageService settings that aren't repositories.
+type LocalOptions struct {
+	Fsync FsyncMode
+	// MaxVersions is the default number of versions kept per file; users can override it.
+	MaxVersions int
+	// Quota enforces file size limits and storage quotas; nil disables both.
+	Quota *QuotaPolicy
+	// Dedup stores identical content once under blobs/<sha256>.
+	Dedup bool
+}
+
+func NewLocalStorageService(
+	basePath string,
+	files repo.Files,
+	versions repo.FileVersions,
+	users repo.Users,
+	blobs repo.Blobs,
+	opts LocalOptions,
+) (*LocalStorageService, error) {
+	abs, err := filepath.Abs(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o750); err != nil {
+		return nil, fmt.Errorf("create storage base path: %w", err)
+	}
+	return &LocalStorageService{
+		basePath:    abs,
+		fsync:       opts.Fsync,
+		files:       files,
+		versions:    versions,
+		users:       users,
+		blobs:       blobs,
+		maxVersions: opts.MaxVersions,
+		quota:       opts.Quota,
+		dedup:       opts.Dedup,
+	}, nil
+}
+
+func (l *LocalStorageService) path(key string) string {
+	return filepath.Join(l.basePath, filepath.FromSlash(key))
+}
+
+func (l *LocalStorageService) SaveFile(
+	ctx context.Context,
+	userID, folderID, originalName, contentType string,
+	size int64,
+	r io.Reader,
+) (*models.File, error) {
+
+	now := time.Now()
+	id := models.GenerateFileID()
+	key := objectKey(userID, id, now)
+	dst := l.path(key)
+
+	quota, r, err := l.quota.admit(ctx, userID, size, r)
+	if err != nil {
+		return nil, err
+	}
+	n, sum, err := l.writeAtomic(dst, r)
+	if err != nil {
+		return nil, err
+	}
+	key, blob, err := l.intern(ctx, key, n, sum)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &models.File{
+		ID:           id,
+		OwnerUserID:  userID,
+		FolderID:     optionalID(folderID),
+		ObjectKey:    key,
+		OriginalName: originalName,
+		SizeBytes:    n,
+		ContentType:  contentType,
+		SHA256:       sum,
+		BlobSHA256:   blob,
+		CreatedAt:    now,
+	}
+	if err := l.files.Create(ctx, f, quota); err != nil {
+		_ = l.release(ctx, key, blob)
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeAtomic streams r into a temp file next to dst, hashing as it goes, and
+// renames it into place so readers never observe a partially written object.
+func (l *LocalStorageService) writeAtomic(dst string, r io.Reader) (int64, string, error) {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return 0, "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".qs-upload-*")
+	if err != nil {
+		return 0, "", err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return 0, "", fmt.Errorf("write upload: %w", err)
+	}
+	if l.fsync != FsyncNone {
+		if err := tmp.Sync(); err != nil {
+			return 0, "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, "", err
+	}
+	committed = true
+
+	if l.fsync == FsyncFull {
+		if err := syncDir(dir); err != nil {
+			_ = os.Remove(dst)
+			return 0, "", err
+		}
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (l *LocalStorageService) OpenFile(ctx context.Context, userID, fileID string) (*models.File, io.ReadCloser, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
+	}
+
+	f, err := os.Open(l.path(meta.ObjectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, f, nil
+}
+
+func (l *LocalStorageService) OpenFileRange(ctx context.Context, userID, fileID string, offset, length int64) (*models.File, io.ReadCloser, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
+	}
+
+	f, err := os.Open(l.path(meta.ObjectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, sectionReadCloser{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+func (l *LocalStorageService) StatFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	meta, err := l.files.ByID(ctx, fileID)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	if meta.OwnerUserID != userID || meta.DeletedAt != nil {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+func (l *LocalStorageService) ListFiles(ctx context.Context, userID string, limit, offset int) ([]*models.File, error) {
+	return l.files.ListByOwner(ctx, userID, limit, offset)
+}
+
+func (l *LocalStorageService) DeleteFile(ctx context.Context, userID, fileID string) error {
+	ok, err := l.files.SoftDelete(ctx, fileID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+func (l *LocalStorageService) ListTrash(ctx context.Context, userID string, limit, offset int) ([]*models.File, error) {
+	return l.files.ListTrash(ctx, userID, limit, offset)
+}
+
+func (l *LocalStorageService) RestoreFile(ctx context.Context, userID, fileID string) error {
+	ok, err := l.files.Restore(ctx, fileID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+func (l *LocalStorageService) EmptyTrash(ctx context.Context, userID string) (int, error) {
+	purged := 0
+	for {
+		trashed, err := l.files.ListTrash(ctx, userID, 100, 0)
+		if err != nil || len(trashed) == 0 {
+			return purged, err
+		}
+		for _, f := range trashed {
+			if err := l.purgeFile(ctx, f); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+func (l *LocalStorageService) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	for {
+		expired, err := l.files.ListDeletedBefore(ctx, before, 100)
+		if err != nil || len(expired) == 0 {
+			return purged, err
+		}
+		for _, f := range expired {
+			if err := l.purgeFile(ctx, f); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+// purgeFile permanently removes a trashed file: every version's object, then the row.
+func (l *LocalStorageService) purgeFile(ctx context.Context, f *models.File) error {
+	list, err := l.versions.List(ctx, f.ID)
+	if err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := l.release(ctx, v.ObjectKey, v.BlobSHA256); err != nil {
+			return err
+		}
+	}
+	if !hasVersionKey(list, f.ObjectKey) {
+		if err := l.release(ctx, f.ObjectKey, f.BlobSHA256); err != nil {
+			return err
+		}
+	}
+	return l.files.Delete(ctx, f.ID, f.OwnerUserID)
+}
+
+// intern moves a freshly written object into the content-addressed store when
+// dedup is on. It returns the key and blob the file version should point at.
+func (l *LocalStorageService) intern(ctx context.Context, key string, n int64, sum string) (string, *string, error) {
+	if !l.dedup {
+		return key, nil, nil
+	}
+	b := &models.Blob{SHA256: sum, ObjectKey: blobKey(sum), SizeBytes: n, CreatedAt: time.Now()}
+	err := l.blobs.Acquire(ctx, b, func(context.Context) error {
+		dst := l.path(b.ObjectKey)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+			return err
+		}
+		if err := os.Rename(l.path(key), dst); err != nil {
+			return err
+		}
+		if l.fsync == FsyncFull {
+			return syncDir(filepath.Dir(dst))
+		}
+		return nil
+	})
+	if errors.Is(err, repo.ErrBlobPending) {
+		// the same bytes are being stored right now, keep this copy unshared
+		return key, nil, nil
+	}
+	// either the blob already had these bytes or the upload was renamed into it
+	_ = l.removeObject(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return b.ObjectKey, &b.SHA256, nil
+}
+
+// release drops a stored object: a reference on its blob, or the object itself.
+func (l *LocalStorageService) release(ctx context.Context, key string, blob *string) error {
+	if blob != nil {
+		return l.blobs.Release(ctx, *blob, l.removeObject)
+	}
+	return l.removeObject(ctx, key)
+}
+
+func (l *LocalStorageService) removeObject(_ context.Context, key string) error {
+	if err := os.Remove(l.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *LocalStorageService) SearchFiles(
+	ctx context.Context,
+	userID, q, contentType string,
+	minSize, maxSize int64,
+	limit, offset int,
+) ([]*models.File, error) {
+	return l.files.ListByFilters(ctx, userID, q, contentType, minSize, maxSize, limit, offset)
+}
+
+func (l *LocalStorageService) RenameFile(ctx context.Context, userID, fileID, newName string) error {
+	return l.files.UpdateOriginalName(ctx, fileID, userID, newName)
+}
+
+func (l *LocalStorageService) SaveFileVersion(ctx context.Context, userID, fileID, contentType string, rd io.Reader) (*models.File, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	if contentType == "" {
+		contentType = meta.ContentType
+	}
+
+	now := time.Now()
+	v := &models.FileVersion{
+		ID:          models.GenerateVersionID(),
+		FileID:      fileID,
+		ContentType: contentType,
+		UploadedBy:  userID,
+		CreatedAt:   now,
+	}
+	v.ObjectKey = objectKey(userID, v.ID, now)
+
+	quota, rd, err := l.quota.admit(ctx, userID, -1, rd)
+	if err != nil {
+		return nil, err
+	}
+	n, sum, err := l.writeAtomic(l.path(v.ObjectKey), rd)
+	if err != nil {
+		return nil, err
+	}
+	v.SizeBytes, v.SHA256 = n, sum
+	if v.ObjectKey, v.BlobSHA256, err = l.intern(ctx, v.ObjectKey, n, sum); err != nil {
+		return nil, err
+	}
+
+	if err := l.versions.Add(ctx, v, quota); err != nil {
+		_ = l.release(ctx, v.ObjectKey, v.BlobSHA256)
+		return nil, err
+	}
+
+	if err := l.pruneVersions(ctx, userID, fileID, v.Version); err != nil {
+		log.Printf("[version-prune] file=%s: %v", fileID, err)
+	}
+	return l.StatFile(ctx, userID, fileID)
+}
+
+func (l *LocalStorageService) pruneVersions(ctx context.Context, userID, fileID string, current int) error {
+	keep, err := maxVersionsFor(ctx, l.users, l.maxVersions, userID)
+	if err != nil || keep <= 0 {
+		return err
+	}
+	list, err := l.versions.List(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versionsToPrune(list, current, keep) {
+		if err := l.release(ctx, v.ObjectKey, v.BlobSHA256); err != nil {
+			return err
+		}
+		if err := l.versions.Delete(ctx, v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LocalStorageService) ListVersions(ctx context.Context, userID, fileID string) ([]*models.FileVersion, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	return l.versions.List(ctx, fileID)
+}
+
+func (l *LocalStorageService) OpenFileVersion(ctx context.Context, userID, fileID string, version int) (*models.FileVersion, io.ReadCloser, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
+	}
+	v, err := l.versions.ByNumber(ctx, fileID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v == nil {
+		return nil, nil, ErrVersionNotFound
+	}
+
+	f, err := os.Open(l.path(v.ObjectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, f, nil
+}
+
+func (l *LocalStorageService) PromoteVersion(ctx context.Context, userID, fileID string, version int) (*models.File, error) {
+	meta, err := l.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	ok, err := l.versions.Promote(ctx, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrVersionNotFound
+	}
+	return l.StatFile(ctx, userID, fileID)
+}
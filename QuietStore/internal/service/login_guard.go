@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+const (
+	// failures before any delay, so a few typos cost nothing
+	freeLoginFailures = 3
+	// how long failures are remembered after the last one
+	loginFailureWindow = 24 * time.Hour
+)
+
+// LoginGuard slows down password guessing per account. It lives in the
+// database, so it holds across restarts and replicas and against guesses
+// spread over many IPs. After a few free failures each one doubles the wait
+// before the next attempt, and threshold failures lock the username for the
+// lockout period. Usernames that don't exist are treated just the same.
+type LoginGuard struct {
+	failures  repo.LoginFailures
+	passwords PasswordHasher
+	threshold int
+	lockout   time.Duration
+	dummyHash string
+}
+
+// NewLoginGuard checks passwords with passwords. formats are hashers for
+// every other kind of hash still stored, say bcrypt ones from before a move
+// to argon2id. Unknown usernames are checked against a dummy hash in the
+// slowest of them to verify, so they take no less time than a real account.
+func NewLoginGuard(failures repo.LoginFailures, passwords PasswordHasher, threshold int, lockout time.Duration, formats ...PasswordHasher) (*LoginGuard, error) {
+	var dummy string
+	var slowest time.Duration
+	for _, h := range append([]PasswordHasher{passwords}, formats...) {
+		hash, err := h.Hash("quietstore-dummy-password")
+		if err != nil {
+			return nil, err
+		}
+		// timed the way CheckPassword verifies it
+		start := time.Now()
+		_, _, _ = passwords.Verify(hash, "quietstore-wrong-password")
+		if took := time.Since(start); took > slowest {
+			dummy, slowest = hash, took
+		}
+	}
+	return &LoginGuard{failures: failures, passwords: passwords, threshold: threshold, lockout: lockout, dummyHash: dummy}, nil
+}
+
+// Wait returns how long username has to wait before it may try again, zero
+// if it may now.
+func (g *LoginGuard) Wait(ctx context.Context, username string) (time.Duration, error) {
+	f, err := g.failures.Get(ctx, username)
+	if err != nil || f == nil || f.LockedUntil == nil {
+		return 0, err
+	}
+	if wait := time.Until(*f.LockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// CheckPassword checks password against u, which may be nil when the
+// username doesn't exist. Either way it costs one hash. rehash reports that
+// the stored hash is outdated and should be replaced with a fresh one.
+func (g *LoginGuard) CheckPassword(u *models.User, password string) (ok, rehash bool, err error) {
+	if u == nil {
+		_, _, _ = g.passwords.Verify(g.dummyHash, password)
+		return false, false, nil
+	}
+	return g.passwords.Verify(u.Password, password)
+}
+
+// Fail records a failed login and reports whether it locked the username.
+func (g *LoginGuard) Fail(ctx context.Context, username string) (bool, error) {
+	now := time.Now()
+	n, err := g.failures.RecordFailure(ctx, username, now, now.Add(-loginFailureWindow))
+	if err != nil {
+		return false, err
+	}
+	if n >= g.threshold {
+		return n == g.threshold, g.failures.Lock(ctx, username, now.Add(g.lockout))
+	}
+	if n > freeLoginFailures {
+		delay := g.lockout
+		// past 2^30 seconds the shift would overflow, and the cap applies anyway
+		if shift := n - freeLoginFailures - 1; shift < 30 && time.Second<<shift < delay {
+			delay = time.Second << shift
+		}
+		return false, g.failures.Lock(ctx, username, now.Add(delay))
+	}
+	return false, nil
+}
+
+// Succeed forgets username's failures after a correct password.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) error {
+	return g.failures.Clear(ctx, username)
+}
+
+// Unlock lifts a lockout early, for admins.
+func (g *LoginGuard) Unlock(ctx context.Context, username string) error {
+	return g.failures.Clear(ctx, username)
+}
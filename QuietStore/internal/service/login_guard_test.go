@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginGuardDummyMatchesSlowestFormat(t *testing.T) {
+	fast := NewBcryptHasher(bcrypt.MinCost)
+	slow := NewBcryptHasher(bcrypt.DefaultCost)
+
+	// hashes made before the cost was lowered still cost the old amount
+	g, err := NewLoginGuard(nil, fast, 5, time.Minute, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(g.dummyHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+
+	ok, rehash, err := g.CheckPassword(nil, "quietstore-dummy-password")
+	if ok || rehash || err != nil {
+		t.Fatalf("CheckPassword for an unknown user = %v, %v, %v", ok, rehash, err)
+	}
+}
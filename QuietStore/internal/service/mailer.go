@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email to one recipient.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers account emails such as verification and password reset
+// links.
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+// formatMail renders m as an RFC 5322 message. Addresses are parsed so a
+// user-supplied one can't smuggle in extra headers.
+func formatMail(from string, m Mail) ([]byte, string, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid from address: %w", err)
+	}
+	toAddr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid recipient: %w", err)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return nil, "", fmt.Errorf("invalid subject")
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	domain := fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", fromAddr)
+	fmt.Fprintf(&b, "To: %s\r\n", toAddr)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String()), toAddr.Address, nil
+}
+
+// SMTPMailer sends through an SMTP relay, upgrading to TLS with STARTTLS when
+// the server offers it.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer logs in with username and password when a username is given.
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, strconv.Itoa(port)), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m Mail) error {
+	msg, to, err := formatMail(s.from, m)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(s.from)
+	return smtp.SendMail(s.addr, s.auth, from.Address, []string{to}, msg)
+}
+
+// LogMailer is for local development: it writes each message to a file in dir,
+// or to the log when dir is empty.
+type LogMailer struct {
+	dir  string
+	from string
+}
+
+func NewLogMailer(dir, from string) (*LogMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &LogMailer{dir: dir, from: from}, nil
+}
+
+func (l *LogMailer) Send(ctx context.Context, m Mail) error {
+	msg, _, err := formatMail(l.from, m)
+	if err != nil {
+		return err
+	}
+	if l.dir == "" {
+		log.Printf("[mail] not sent, no mailer configured:\n%s", msg)
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitizeFilename(m.To))
+	return os.WriteFile(filepath.Join(l.dir, name), msg, 0o600)
+}
+
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' ||
+			('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotPending       = errors.New("no two-factor enrollment to confirm")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa token")
+	ErrUserNotFound        = errors.New("user not found")
+)
+
+const (
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAAttempts caps guesses per challenge; after that the password has
+	// to be entered again.
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10
+)
+
+// MFAService handles TOTP enrollment, recovery codes and the second step of
+// logging in.
+type MFAService struct {
+	mfa    repo.MFA
+	users  repo.Users
+	issuer string
+}
+
+// NewMFAService names the account issuer in authenticator apps.
+func NewMFAService(mfa repo.MFA, users repo.Users, issuer string) *MFAService {
+	return &MFAService{mfa: mfa, users: users, issuer: issuer}
+}
+
+func hashSecretCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeMFACode accepts codes typed with spaces, dashes or capitals.
+func normalizeMFACode(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// newRecoveryCodes returns codes for the user, formatted xxxxx-xxxxx, and their
+// hashes for storage.
+func newRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeSize)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := make([]byte, recoveryCodeSize)
+		for j, b := range buf {
+			raw[j] = alphabet[b%byte(len(alphabet))]
+		}
+		codes[i] = string(raw[:5]) + "-" + string(raw[5:])
+		hashes[i] = hashSecretCode(string(raw))
+	}
+	return codes, hashes, nil
+}
+
+func (s *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	t, err := s.mfa.TOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return t.Enabled(), nil
+}
+
+func (s *MFAService) Status(ctx context.Context, userID string) (*models.MFAStatus, error) {
+	enabled, err := s.Enabled(ctx, userID)
+	if err != nil || !enabled {
+		return &models.MFAStatus{}, err
+	}
+	n, err := s.mfa.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.MFAStatus{Enabled: true, RecoveryCodesRemaining: n}, nil
+}
+
+// StartEnrollment generates a TOTP secret for the user to add to their
+// authenticator app. MFA stays off until ConfirmEnrollment.
+func (s *MFAService) StartEnrollment(ctx context.Context, userID string) (*models.TOTPEnrollmentResponse, error) {
+	u, err := s.users.ByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	ok, err := s.mfa.SetPendingTOTP(ctx, userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return &models.TOTPEnrollmentResponse{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(s.issuer, u.Username, secret),
+	}, nil
+}
+
+// ConfirmEnrollment turns MFA on once the user proves their app produces
+// codes, and returns their recovery codes.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error) {
+	t, err := s.mfa.TOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if t == nil {
+		return nil, ErrMFANotPending
+	}
+	step, ok := totpMatch(t.Secret, normalizeMFACode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	ok, err = s.mfa.EnableTOTP(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMFANotPending
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code or, failing that shape, a recovery code. Either
+// is accepted only once.
+func (s *MFAService) Verify(ctx context.Context, userID, code string) error {
+	t, err := s.mfa.TOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled() {
+		return ErrMFANotEnabled
+	}
+
+	code = normalizeMFACode(code)
+	if len(code) == totpDigits {
+		step, ok := totpMatch(t.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		fresh, err := s.mfa.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	ok, err := s.mfa.UseRecoveryCode(ctx, userID, hashSecretCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// Disable turns MFA off. It takes a current code so a hijacked session alone
+// can't remove the second factor.
+func (s *MFAService) Disable(ctx context.Context, userID, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	_, err := s.mfa.DeleteTOTP(ctx, userID)
+	return err
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Reset turns MFA off for a user who lost their device and recovery codes.
+// It is for admins and needs no code.
+func (s *MFAService) Reset(ctx context.Context, userID string) error {
+	ok, err := s.mfa.DeleteTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFANotEnabled
+	}
+	return nil
+}
+
+// StartChallenge is the end of the first login step for a user with MFA on.
+// The returned token stands in for the password in the second step.
+func (s *MFAService) StartChallenge(ctx context.Context, userID string) (string, time.Duration, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", 0, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	err := s.mfa.CreateChallenge(ctx, &models.MFAChallenge{
+		ID:        models.GenerateMFAChallengeID(),
+		UserID:    userID,
+		TokenHash: hashSecretCode(token),
+		ExpiresAt: now.Add(mfaChallengeTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return token, mfaChallengeTTL, nil
+}
+
+// CompleteChallenge redeems a challenge token with a code and returns the
+// user it was issued to.
+func (s *MFAService) CompleteChallenge(ctx context.Context, token, code string) (string, error) {
+	ch, err := s.mfa.ChallengeByHash(ctx, hashSecretCode(token), time.Now())
+	if err != nil {
+		return "", err
+	}
+	if ch == nil {
+		return "", ErrInvalidMFAChallenge
+	}
+	attempts, err := s.mfa.CountChallengeAttempt(ctx, ch.ID)
+	if err != nil {
+		return "", err
+	}
+	// zero means another request used the challenge up in the meantime
+	if attempts == 0 || attempts > maxMFAAttempts {
+		_ = s.mfa.DeleteChallenge(ctx, ch.ID)
+		return "", ErrInvalidMFAChallenge
+	}
+
+	if err := s.Verify(ctx, ch.UserID, code); err != nil {
+		if attempts == maxMFAAttempts {
+			_ = s.mfa.DeleteChallenge(ctx, ch.ID)
+		}
+		return "", err
+	}
+	if err := s.mfa.DeleteChallenge(ctx, ch.ID); err != nil {
+		return "", err
+	}
+	return ch.UserID, nil
+}
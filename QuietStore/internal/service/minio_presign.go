@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func (m *MinIOStorageService) PresignDownload(ctx context.Context, userID, fileID string) (*PresignedURL, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	if meta.WrappedKey != nil {
+		return nil, ErrEncrypted
+	}
+
+	in := &s3.GetObjectInput{
+		Bucket:                     aws.String(m.bucket),
+		Key:                        aws.String(meta.ObjectKey),
+		ResponseContentDisposition: aws.String(fmt.Sprintf(`attachment; filename=%q`, meta.OriginalName)),
+	}
+	if meta.ContentType != "" {
+		in.ResponseContentType = aws.String(meta.ContentType)
+	}
+	req, err := m.presign.PresignGetObject(ctx, in, s3.WithPresignExpires(m.presignTTL))
+	if err != nil {
+		return nil, err
+	}
+	return &PresignedURL{
+		Method:    req.Method,
+		URL:       req.URL,
+		ExpiresAt: time.Now().Add(m.presignTTL),
+	}, nil
+}
+
+func (m *MinIOStorageService) PresignUpload(
+	ctx context.Context,
+	userID, originalName, contentType string,
+	size int64,
+	sha256Hex string,
+) (*models.PresignedUpload, *PresignedURL, error) {
+
+	sum, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid sha256")
+	}
+
+	if _, _, err := m.quota.check(ctx, userID, size); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	id := models.GenerateFileID()
+	p := &models.PresignedUpload{
+		ID:           id,
+		OwnerUserID:  userID,
+		ObjectKey:    objectKey(userID, id, now),
+		OriginalName: originalName,
+		ContentType:  contentType,
+		SizeBytes:    size,
+		SHA256:       hex.EncodeToString(sum),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(m.presignTTL + m.uploadTTL),
+	}
+
+	// signing the length and checksum makes MinIO reject any other body
+	in := &s3.PutObjectInput{
+		Bucket:         aws.String(m.bucket),
+		Key:            aws.String(p.ObjectKey),
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	}
+	if contentType != "" {
+		in.ContentType = aws.String(contentType)
+	}
+	req, err := m.presign.PresignPutObject(ctx, in, s3.WithPresignExpires(m.presignTTL))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := m.presigned.Create(ctx, p); err != nil {
+		return nil, nil, err
+	}
+
+	headers := make(map[string]string, len(req.SignedHeader))
+	for k, v := range req.SignedHeader {
+		// Host is set by the HTTP client from the URL
+		if k == "Host" || len(v) == 0 {
+			continue
+		}
+		headers[k] = v[0]
+	}
+	return p, &PresignedURL{
+		Method:    req.Method,
+		URL:       req.URL,
+		Headers:   headers,
+		ExpiresAt: now.Add(m.presignTTL),
+	}, nil
+}
+
+func (m *MinIOStorageService) ConfirmUpload(ctx context.Context, userID, fileID string) (*models.File, error) {
+	p, err := m.presigned.ByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil || p.OwnerUserID != userID {
+		return nil, ErrFileNotFound
+	}
+	// the purge job may be about to delete the object
+	if time.Now().After(p.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+
+	head, err := m.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(m.bucket),
+		Key:          aws.String(p.ObjectKey),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+			return nil, ErrObjectNotUploaded
+		}
+		return nil, err
+	}
+
+	ok, err := m.verifyObject(ctx, p, head)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// let the client retry the PUT while its URL is still valid
+		_, _ = m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(m.bucket),
+			Key:    aws.String(p.ObjectKey),
+		})
+		return nil, ErrObjectMismatch
+	}
+
+	f := &models.File{
+		ID:           p.ID,
+		OwnerUserID:  p.OwnerUserID,
+		ObjectKey:    p.ObjectKey,
+		OriginalName: p.OriginalName,
+		SizeBytes:    p.SizeBytes,
+		ContentType:  p.ContentType,
+		SHA256:       p.SHA256,
+		CreatedAt:    time.Now(),
+	}
+	quota, err := m.quota.quotaFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.sealUploaded(ctx, f); err != nil {
+		return nil, err
+	}
+	if err := m.presigned.Complete(ctx, p.ID, f, quota); err != nil {
+		if f.ObjectKey != p.ObjectKey {
+			_ = m.removeObject(ctx, f.ObjectKey)
+		}
+		return nil, err
+	}
+	if f.ObjectKey != p.ObjectKey {
+		_ = m.removeObject(ctx, p.ObjectKey)
+	}
+	return f, nil
+}
+
+// sealUploaded encrypts an object a client uploaded in plaintext, when
+// encryption at rest is on. The ciphertext goes to a new key so the plaintext
+// stays in place until the file is committed.
+func (m *MinIOStorageService) sealUploaded(ctx context.Context, f *models.File) error {
+	dk, wrapped, err := m.dataKey()
+	if err != nil || dk == nil {
+		return err
+	}
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(f.ObjectKey),
+	})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	// a unique key, so a confirm racing this one can't overwrite or remove it
+	suffix, err := randomURLToken()
+	if err != nil {
+		return err
+	}
+	key := objectKey(f.OwnerUserID, f.ID+"."+suffix[:12]+".enc", f.CreatedAt)
+	n, sum, err := m.putStream(ctx, key, f.ContentType, obj.Body, dk)
+	if err != nil {
+		return err
+	}
+	if n != f.SizeBytes || sum != f.SHA256 {
+		_ = m.removeObject(ctx, key)
+		return ErrObjectMismatch
+	}
+	f.ObjectKey, f.WrappedKey = key, wrapped
+	return nil
+}
+
+// verifyObject checks size and SHA-256 of an uploaded object. MinIO returns the
+// checksum it verified on PUT; if it didn't store one we hash the object ourselves.
+func (m *MinIOStorageService) verifyObject(ctx context.Context, p *models.PresignedUpload, head *s3.HeadObjectOutput) (bool, error) {
+	if aws.ToInt64(head.ContentLength) != p.SizeBytes {
+		return false, nil
+	}
+	if cs := aws.ToString(head.ChecksumSHA256); cs != "" {
+		sum, err := base64.StdEncoding.DecodeString(cs)
+		return err == nil && hex.EncodeToString(sum) == p.SHA256, nil
+	}
+
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(p.ObjectKey),
+	})
+	if err != nil {
+		return false, err
+	}
+	defer obj.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj.Body); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == p.SHA256, nil
+}
+
+func (m *MinIOStorageService) PurgeExpiredPresignedUploads(ctx context.Context, now time.Time) (int, error) {
+	purged := 0
+	for {
+		expired, err := m.presigned.ListExpired(ctx, now, 100)
+		if err != nil {
+			return purged, err
+		}
+		if len(expired) == 0 {
+			return purged, nil
+		}
+		for _, p := range expired {
+			_, _ = m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(m.bucket),
+				Key:    aws.String(p.ObjectKey),
+			})
+			if err := m.presigned.Delete(ctx, p.ID); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
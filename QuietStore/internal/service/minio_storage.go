@@ -1,79 +1,118 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"log"
 	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type MinIOStorageService struct {
-	s3     *s3.Client
-	bucket string
-	files  repo.Files
+	s3          *s3.Client
+	presign     *s3.PresignClient
+	bucket      string
+	files       repo.Files
+	versions    repo.FileVersions
+	users       repo.Users
+	uploads     repo.Uploads
+	presigned   repo.PresignedUploads
+	blobs       repo.Blobs
+	uploadTTL   time.Duration
+	presignTTL  time.Duration
+	maxVersions int
+	quota       *QuotaPolicy
+	dedup       bool
+	keys        *Keyring
 }
 
-func NewMinIOStorageService(s3c *s3.Client, bucket string, files repo.Files) *MinIOStorageService {
-	return &MinIOStorageService{s3: s3c, bucket: bucket, files: files}
+// MinIOOptions holds the MinIOStorageService settings that aren't repositories.
+type MinIOOptions struct {
+	UploadTTL  time.Duration
+	PresignTTL time.Duration
+	// MaxVersions is the default number of versions kept per file; users can override it.
+	MaxVersions int
+	// PresignClient signs URLs that are handed to clients. It only needs to
+	// differ from the main client when MinIO is reachable at another address
+	// from outside (e.g. minio:9000 internally, a public hostname outside).
+	PresignClient *s3.Client
+	// Quota enforces file size limits and storage quotas; nil disables both.
+	Quota *QuotaPolicy
+	// Dedup stores identical content once under blobs/<sha256>.
+	Dedup bool
+	// Keyring turns on encryption at rest: every new object gets its own
+	// AES-256-GCM data key, wrapped with the keyring's master key.
+	Keyring *Keyring
 }
 
-func (m *MinIOStorageService) objectKey(userID, fileID string, t time.Time) string {
-	return fmt.Sprintf("user/%s/%04d/%02d/%s", userID, t.Year(), int(t.Month()), fileID)
+func NewMinIOStorageService(
+	s3c *s3.Client,
+	bucket string,
+	files repo.Files,
+	versions repo.FileVersions,
+	users repo.Users,
+	uploads repo.Uploads,
+	presigned repo.PresignedUploads,
+	blobs repo.Blobs,
+	opts MinIOOptions,
+) *MinIOStorageService {
+	pc := opts.PresignClient
+	if pc == nil {
+		pc = s3c
+	}
+	return &MinIOStorageService{
+		s3:          s3c,
+		presign:     s3.NewPresignClient(pc),
+		bucket:      bucket,
+		files:       files,
+		versions:    versions,
+		users:       users,
+		uploads:     uploads,
+		presigned:   presigned,
+		blobs:       blobs,
+		uploadTTL:   opts.UploadTTL,
+		presignTTL:  opts.PresignTTL,
+		maxVersions: opts.MaxVersions,
+		quota:       opts.Quota,
+		dedup:       opts.Dedup,
+		keys:        opts.Keyring,
+	}
 }
 
 func (m *MinIOStorageService) SaveFile(
 	ctx context.Context,
-	userID, originalName, contentType string,
+	userID, folderID, originalName, contentType string,
 	size int64,
 	r io.Reader,
 ) (*models.File, error) {
 
 	now := time.Now()
 	id := models.GenerateFileID()
-	key := m.objectKey(userID, id, now)
+	key := objectKey(userID, id, now)
 
-	tmp, err := os.CreateTemp("", "qs-upload-*")
+	quota, r, err := m.quota.admit(ctx, userID, size, r)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		tmp.Close()
-		_ = os.Remove(tmp.Name())
-	}()
-
-	n, err := io.Copy(tmp, r)
+	dk, wrapped, err := m.dataKey()
 	if err != nil {
-		return nil, fmt.Errorf("buffer upload: %w", err)
-	}
-
-	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-	h := sha256.New()
-	if _, err := io.Copy(h, tmp); err != nil {
 		return nil, err
 	}
-	sum := hex.EncodeToString(h.Sum(nil))
-
-	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+	n, sum, err := m.putStream(ctx, key, contentType, r, dk)
+	if err != nil {
 		return nil, err
 	}
-
-	_, err = m.s3.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(m.bucket),
-		Key:           aws.String(key),
-		Body:          tmp,
-		ContentType:   aws.String(contentType),
-		ContentLength: aws.Int64(n),
-	})
+	key, blob, wrapped, err := m.intern(ctx, key, n, sum, wrapped)
 	if err != nil {
 		return nil, err
 	}
@@ -81,40 +120,246 @@ func (m *MinIOStorageService) SaveFile(
 	f := &models.File{
 		ID:           id,
 		OwnerUserID:  userID,
+		FolderID:     optionalID(folderID),
 		ObjectKey:    key,
 		OriginalName: originalName,
 		SizeBytes:    n,
 		ContentType:  contentType,
 		SHA256:       sum,
+		BlobSHA256:   blob,
+		WrappedKey:   wrapped,
 		CreatedAt:    now,
 	}
-	if err := m.files.Create(ctx, f); err != nil {
-		_, _ = m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(m.bucket),
-			Key:    aws.String(key),
-		})
+	if err := m.files.Create(ctx, f, quota); err != nil {
+		_ = m.release(ctx, key, blob)
 		return nil, err
 	}
 	return f, nil
 }
 
+// putStream writes r to key without buffering it on disk, hashing as it goes.
+// Bodies that fit in a single part go through PutObject; anything larger is
+// streamed into a multipart upload that is aborted if any step fails, so a
+// failed upload never leaves a visible object behind. With a data key the
+// object is stored encrypted; the size and hash returned are the plaintext's.
+func (m *MinIOStorageService) putStream(ctx context.Context, key, contentType string, src io.Reader, dk []byte) (int64, string, error) {
+	h := sha256.New()
+	plain := &countingReader{r: io.TeeReader(src, h)}
+	r := io.Reader(plain)
+	if dk != nil {
+		sr, err := newSealReader(plain, dk)
+		if err != nil {
+			return 0, "", err
+		}
+		r = sr
+	}
+	buf := make([]byte, uploadPartSize)
+
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		in := &s3.PutObjectInput{
+			Bucket:        aws.String(m.bucket),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		}
+		if contentType != "" {
+			in.ContentType = aws.String(contentType)
+		}
+		if _, err := m.s3.PutObject(ctx, in); err != nil {
+			return 0, "", err
+		}
+		return plain.n, hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("read upload: %w", err)
+	}
+
+	in := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		in.ContentType = aws.String(contentType)
+	}
+	mp, err := m.s3.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return 0, "", err
+	}
+	abort := func() {
+		// the request context may already be cancelled; abort regardless
+		actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = m.s3.AbortMultipartUpload(actx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(m.bucket),
+			Key:      aws.String(key),
+			UploadId: mp.UploadId,
+		})
+	}
+
+	var (
+		parts []types.CompletedPart
+		last  bool
+	)
+	for partNum := int32(1); ; partNum++ {
+		out, err := m.s3.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(m.bucket),
+			Key:           aws.String(key),
+			UploadId:      mp.UploadId,
+			PartNumber:    aws.Int32(partNum),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		if err != nil {
+			abort()
+			return 0, "", fmt.Errorf("upload part %d: %w", partNum, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNum)})
+		if last {
+			break
+		}
+
+		n, err = io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// a short read means this is the final part
+		last = errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			abort()
+			return 0, "", fmt.Errorf("read upload: %w", err)
+		}
+	}
+
+	if _, err := m.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(key),
+		UploadId:        mp.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		abort()
+		return 0, "", fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return plain.n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// dataKey returns a new data key for an object and its wrapped form, or nils
+// when encryption at rest is off.
+func (m *MinIOStorageService) dataKey() ([]byte, []byte, error) {
+	if m.keys == nil {
+		return nil, nil, nil
+	}
+	return m.keys.newDataKey()
+}
+
+func (m *MinIOStorageService) unwrap(wrapped []byte) ([]byte, error) {
+	if m.keys == nil {
+		return nil, ErrUnknownMasterKey
+	}
+	return m.keys.unwrap(wrapped)
+}
+
+// openObject reads length bytes at offset from a stored object of size
+// plaintext bytes, decrypting it when it has a data key. A negative length
+// reads the whole object.
+func (m *MinIOStorageService) openObject(ctx context.Context, key string, wrapped []byte, size, offset, length int64) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	}
+	if wrapped == nil {
+		if length >= 0 {
+			in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		}
+		obj, err := m.s3.GetObject(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Body, nil
+	}
+
+	dk, err := m.unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	var first, skip int64
+	if length >= 0 {
+		var start, end int64
+		start, end, first, skip = sealedRange(size, offset, length)
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	obj, err := m.s3.GetObject(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	or, err := newOpenReader(obj.Body, dk, size, first)
+	if err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, or, skip); err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	var r io.Reader = or
+	if length >= 0 {
+		r = io.LimitReader(or, length)
+	}
+	return readCloser{Reader: r, Closer: obj.Body}, nil
+}
+
 func (m *MinIOStorageService) OpenFile(ctx context.Context, userID, fileID string) (*models.File, io.ReadCloser, error) {
-	meta, err := m.files.ByID(ctx, fileID)
-	if err != nil || meta == nil {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
 		return nil, nil, err
 	}
-	if meta.OwnerUserID != userID {
-		return nil, nil, fmt.Errorf("not found")
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
 	}
 
-	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(m.bucket),
-		Key:    aws.String(meta.ObjectKey),
-	})
+	rc, err := m.openObject(ctx, meta.ObjectKey, meta.WrappedKey, meta.SizeBytes, 0, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, rc, nil
+}
+
+func (m *MinIOStorageService) OpenFileRange(ctx context.Context, userID, fileID string, offset, length int64) (*models.File, io.ReadCloser, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
+	}
+
+	rc, err := m.openObject(ctx, meta.ObjectKey, meta.WrappedKey, meta.SizeBytes, offset, length)
 	if err != nil {
 		return nil, nil, err
 	}
-	return meta, obj.Body, nil
+	return meta, rc, nil
+}
+
+func (m *MinIOStorageService) StatFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	meta, err := m.files.ByID(ctx, fileID)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	if meta.OwnerUserID != userID || meta.DeletedAt != nil {
+		return nil, nil
+	}
+	return meta, nil
 }
 
 func (m *MinIOStorageService) ListFiles(ctx context.Context, userID string, limit, offset int) ([]*models.File, error) {
@@ -122,20 +367,191 @@ func (m *MinIOStorageService) ListFiles(ctx context.Context, userID string, limi
 }
 
 func (m *MinIOStorageService) DeleteFile(ctx context.Context, userID, fileID string) error {
-	meta, err := m.files.ByID(ctx, fileID)
-	if err != nil || meta == nil {
+	ok, err := m.files.SoftDelete(ctx, fileID, userID)
+	if err != nil {
 		return err
 	}
-	if meta.OwnerUserID != userID {
-		return fmt.Errorf("not found")
+	if !ok {
+		return ErrFileNotFound
 	}
+	return nil
+}
 
-	if _, err := m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(m.bucket), Key: aws.String(meta.ObjectKey),
-	}); err != nil {
+func (m *MinIOStorageService) ListTrash(ctx context.Context, userID string, limit, offset int) ([]*models.File, error) {
+	return m.files.ListTrash(ctx, userID, limit, offset)
+}
+
+func (m *MinIOStorageService) RestoreFile(ctx context.Context, userID, fileID string) error {
+	ok, err := m.files.Restore(ctx, fileID, userID)
+	if err != nil {
 		return err
 	}
-	return m.files.Delete(ctx, fileID, userID)
+	if !ok {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+func (m *MinIOStorageService) EmptyTrash(ctx context.Context, userID string) (int, error) {
+	purged := 0
+	for {
+		trashed, err := m.files.ListTrash(ctx, userID, 100, 0)
+		if err != nil || len(trashed) == 0 {
+			return purged, err
+		}
+		for _, f := range trashed {
+			if err := m.purgeFile(ctx, f); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+func (m *MinIOStorageService) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	for {
+		expired, err := m.files.ListDeletedBefore(ctx, before, 100)
+		if err != nil || len(expired) == 0 {
+			return purged, err
+		}
+		for _, f := range expired {
+			if err := m.purgeFile(ctx, f); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+// purgeFile permanently removes a trashed file: every version's object, then the row.
+func (m *MinIOStorageService) purgeFile(ctx context.Context, f *models.File) error {
+	list, err := m.versions.List(ctx, f.ID)
+	if err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := m.release(ctx, v.ObjectKey, v.BlobSHA256); err != nil {
+			return err
+		}
+	}
+	if !hasVersionKey(list, f.ObjectKey) {
+		if err := m.release(ctx, f.ObjectKey, f.BlobSHA256); err != nil {
+			return err
+		}
+	}
+	return m.files.Delete(ctx, f.ID, f.OwnerUserID)
+}
+
+// intern copies a freshly written object into the content-addressed store when
+// dedup is on and drops the upload key. It returns the key, blob and wrapped
+// data key the file version should point at; a blob that already existed
+// keeps the data key it was first stored with.
+func (m *MinIOStorageService) intern(ctx context.Context, key string, n int64, sum string, wrapped []byte) (string, *string, []byte, error) {
+	if !m.dedup {
+		return key, nil, wrapped, nil
+	}
+	stored := n
+	if wrapped != nil {
+		stored = sealedSize(n)
+	}
+	b := &models.Blob{SHA256: sum, ObjectKey: blobKey(sum), SizeBytes: n, WrappedKey: wrapped, CreatedAt: time.Now()}
+	err := m.blobs.Acquire(ctx, b, func(ctx context.Context) error {
+		return m.copyObject(ctx, key, b.ObjectKey, stored)
+	})
+	if errors.Is(err, repo.ErrBlobPending) {
+		// the same bytes are being stored right now, keep this copy unshared
+		return key, nil, wrapped, nil
+	}
+	_ = m.removeObject(ctx, key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return b.ObjectKey, &b.SHA256, b.WrappedKey, nil
+}
+
+// release drops a stored object: a reference on its blob, or the object itself.
+func (m *MinIOStorageService) release(ctx context.Context, key string, blob *string) error {
+	if blob != nil {
+		return m.blobs.Release(ctx, *blob, m.removeObject)
+	}
+	return m.removeObject(ctx, key)
+}
+
+// maxCopySize is the largest object a single CopyObject call accepts.
+const maxCopySize = 5 << 30
+
+// copyObject copies src to dst server side. Objects over 5 GiB are copied in
+// parts since S3 rejects a single copy that large.
+func (m *MinIOStorageService) copyObject(ctx context.Context, src, dst string, size int64) error {
+	source := aws.String(m.bucket + "/" + src)
+	if size <= maxCopySize {
+		_, err := m.s3.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(m.bucket),
+			Key:        aws.String(dst),
+			CopySource: source,
+		})
+		return err
+	}
+
+	head, err := m.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(m.bucket), Key: aws.String(src)})
+	if err != nil {
+		return err
+	}
+	mp, err := m.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(m.bucket),
+		Key:         aws.String(dst),
+		ContentType: head.ContentType,
+	})
+	if err != nil {
+		return err
+	}
+	abort := func() {
+		actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = m.s3.AbortMultipartUpload(actx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(m.bucket),
+			Key:      aws.String(dst),
+			UploadId: mp.UploadId,
+		})
+	}
+
+	var parts []types.CompletedPart
+	partNum := int32(1)
+	for off := int64(0); off < size; off += maxCopySize {
+		end := min(off+maxCopySize, size) - 1
+		out, err := m.s3.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(m.bucket),
+			Key:             aws.String(dst),
+			UploadId:        mp.UploadId,
+			PartNumber:      aws.Int32(partNum),
+			CopySource:      source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", off, end)),
+		})
+		if err != nil {
+			abort()
+			return fmt.Errorf("copy part %d: %w", partNum, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(partNum)})
+		partNum++
+	}
+	if _, err := m.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(dst),
+		UploadId:        mp.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		abort()
+		return fmt.Errorf("complete multipart copy: %w", err)
+	}
+	return nil
+}
+
+func (m *MinIOStorageService) removeObject(ctx context.Context, key string) error {
+	_, err := m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.bucket), Key: aws.String(key),
+	})
+	return err
 }
 
 func (m *MinIOStorageService) SearchFiles(
@@ -150,3 +566,125 @@ func (m *MinIOStorageService) SearchFiles(
 func (m *MinIOStorageService) RenameFile(ctx context.Context, userID, fileID, newName string) error {
 	return m.files.UpdateOriginalName(ctx, fileID, userID, newName)
 }
+
+func (m *MinIOStorageService) SaveFileVersion(ctx context.Context, userID, fileID, contentType string, rd io.Reader) (*models.File, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	if contentType == "" {
+		contentType = meta.ContentType
+	}
+
+	now := time.Now()
+	v := &models.FileVersion{
+		ID:          models.GenerateVersionID(),
+		FileID:      fileID,
+		ContentType: contentType,
+		UploadedBy:  userID,
+		CreatedAt:   now,
+	}
+	v.ObjectKey = objectKey(userID, v.ID, now)
+
+	quota, rd, err := m.quota.admit(ctx, userID, -1, rd)
+	if err != nil {
+		return nil, err
+	}
+	dk, wrapped, err := m.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	n, sum, err := m.putStream(ctx, v.ObjectKey, contentType, rd, dk)
+	if err != nil {
+		return nil, err
+	}
+	v.SizeBytes, v.SHA256 = n, sum
+	if v.ObjectKey, v.BlobSHA256, v.WrappedKey, err = m.intern(ctx, v.ObjectKey, n, sum, wrapped); err != nil {
+		return nil, err
+	}
+
+	if err := m.versions.Add(ctx, v, quota); err != nil {
+		_ = m.release(ctx, v.ObjectKey, v.BlobSHA256)
+		return nil, err
+	}
+
+	if err := m.pruneVersions(ctx, userID, fileID, v.Version); err != nil {
+		log.Printf("[version-prune] file=%s: %v", fileID, err)
+	}
+	return m.StatFile(ctx, userID, fileID)
+}
+
+func (m *MinIOStorageService) pruneVersions(ctx context.Context, userID, fileID string, current int) error {
+	keep, err := maxVersionsFor(ctx, m.users, m.maxVersions, userID)
+	if err != nil || keep <= 0 {
+		return err
+	}
+	list, err := m.versions.List(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versionsToPrune(list, current, keep) {
+		if err := m.release(ctx, v.ObjectKey, v.BlobSHA256); err != nil {
+			return err
+		}
+		if err := m.versions.Delete(ctx, v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MinIOStorageService) ListVersions(ctx context.Context, userID, fileID string) ([]*models.FileVersion, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	return m.versions.List(ctx, fileID)
+}
+
+func (m *MinIOStorageService) OpenFileVersion(ctx context.Context, userID, fileID string, version int) (*models.FileVersion, io.ReadCloser, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta == nil {
+		return nil, nil, ErrFileNotFound
+	}
+	v, err := m.versions.ByNumber(ctx, fileID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v == nil {
+		return nil, nil, ErrVersionNotFound
+	}
+
+	rc, err := m.openObject(ctx, v.ObjectKey, v.WrappedKey, v.SizeBytes, 0, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, rc, nil
+}
+
+func (m *MinIOStorageService) PromoteVersion(ctx context.Context, userID, fileID string, version int) (*models.File, error) {
+	meta, err := m.StatFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	ok, err := m.versions.Promote(ctx, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrVersionNotFound
+	}
+	return m.StatFile(ctx, userID, fileID)
+}
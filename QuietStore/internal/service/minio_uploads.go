@@ -0,0 +1,441 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// uploadPartSize is the S3 minimum for every part except the last one. Bytes
+// that do not fill a whole part yet are parked in a "<key>.part" object so the
+// upload survives a restart.
+const uploadPartSize = 5 << 20
+
+func pendingKey(objectKey string) string { return objectKey + ".part" }
+
+func (m *MinIOStorageService) CreateUpload(
+	ctx context.Context,
+	userID, originalName, contentType string,
+	length int64,
+	metadata string,
+) (*models.UploadSession, *models.File, error) {
+
+	now := time.Now()
+	fileID := models.GenerateFileID()
+	key := objectKey(userID, fileID, now)
+
+	if _, _, err := m.quota.check(ctx, userID, length); err != nil {
+		return nil, nil, err
+	}
+	if length == 0 {
+		f, err := m.SaveFile(ctx, userID, "", originalName, contentType, 0, bytes.NewReader(nil))
+		if err != nil {
+			return nil, nil, err
+		}
+		return &models.UploadSession{
+			ID:           models.GenerateUploadID(),
+			OwnerUserID:  userID,
+			FileID:       f.ID,
+			ObjectKey:    f.ObjectKey,
+			OriginalName: originalName,
+			ContentType:  contentType,
+			CreatedAt:    now,
+			ExpiresAt:    now,
+		}, f, nil
+	}
+
+	in := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		in.ContentType = aws.String(contentType)
+	}
+	mp, err := m.s3.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := hashState(sha256.New())
+	if err != nil {
+		return nil, nil, err
+	}
+	_, wrapped, err := m.dataKey()
+	if err != nil {
+		m.abortUpload(ctx, &models.UploadSession{ObjectKey: key, S3UploadID: aws.ToString(mp.UploadId)})
+		return nil, nil, err
+	}
+	u := &models.UploadSession{
+		ID:           models.GenerateUploadID(),
+		OwnerUserID:  userID,
+		FileID:       fileID,
+		ObjectKey:    key,
+		S3UploadID:   aws.ToString(mp.UploadId),
+		OriginalName: originalName,
+		ContentType:  contentType,
+		Length:       length,
+		HashState:    state,
+		WrappedKey:   wrapped,
+		Metadata:     metadata,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(m.uploadTTL),
+	}
+	if err := m.uploads.Create(ctx, u); err != nil {
+		m.abortUpload(ctx, u)
+		return nil, nil, err
+	}
+	return u, nil, nil
+}
+
+func (m *MinIOStorageService) GetUpload(ctx context.Context, userID, uploadID string) (*models.UploadSession, error) {
+	u, err := m.uploads.ByID(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || u.OwnerUserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return u, nil
+}
+
+func (m *MinIOStorageService) WriteUpload(
+	ctx context.Context,
+	userID, uploadID string,
+	offset int64,
+	r io.Reader,
+) (*models.UploadSession, *models.File, error) {
+
+	u, err := m.GetUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != u.Offset {
+		return u, nil, ErrUploadOffsetMismatch
+	}
+
+	parts, err := m.uploads.Parts(ctx, u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var committed int64
+	for _, p := range parts {
+		committed += p.SizeBytes
+	}
+	nextPart := int32(len(parts) + 1)
+
+	h, err := restoreHash(u.HashState)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := m.uploadCipher(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, 0, uploadPartSize)
+	if pending := u.Offset - committed; pending > 0 {
+		if buf, err = m.loadPending(ctx, u, aead, buf, pending); err != nil {
+			return nil, nil, fmt.Errorf("load pending part: %w", err)
+		}
+	}
+
+	// stored is what Postgres has acknowledged; read is what we have hashed.
+	stored, read := u.Offset, u.Offset
+	body := io.LimitReader(r, u.Length-u.Offset)
+
+	var readErr error
+	for {
+		n, err := io.ReadFull(body, buf[len(buf):cap(buf)])
+		h.Write(buf[len(buf) : len(buf)+n])
+		buf = buf[:len(buf)+n]
+		read += int64(n)
+
+		if len(buf) == cap(buf) {
+			part, err := m.uploadPart(ctx, u, aead, nextPart, buf)
+			if err != nil {
+				return u, nil, err
+			}
+			if err := m.checkpoint(ctx, u, stored, read, h, part); err != nil {
+				return u, nil, err
+			}
+			stored = read
+			nextPart++
+			buf = buf[:0]
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				readErr = err
+			}
+			break
+		}
+	}
+
+	if read > stored {
+		if read == u.Length {
+			part, err := m.uploadPart(ctx, u, aead, nextPart, buf)
+			if err != nil {
+				return u, nil, err
+			}
+			if err := m.checkpoint(ctx, u, stored, read, h, part); err != nil {
+				return u, nil, err
+			}
+		} else {
+			if err := m.storePending(ctx, u, aead, buf); err != nil {
+				return u, nil, fmt.Errorf("store pending part: %w", err)
+			}
+			if err := m.checkpoint(ctx, u, stored, read, h, nil); err != nil {
+				return u, nil, err
+			}
+		}
+	}
+	if readErr != nil {
+		return u, nil, readErr
+	}
+
+	if u.Offset < u.Length {
+		return u, nil, nil
+	}
+	f, err := m.finishUpload(ctx, u, h)
+	if err != nil {
+		return u, nil, err
+	}
+	return u, f, nil
+}
+
+// uploadCipher returns the cipher an upload's parts are encrypted with, or nil
+// for a plaintext upload.
+func (m *MinIOStorageService) uploadCipher(u *models.UploadSession) (cipher.AEAD, error) {
+	if u.WrappedKey == nil {
+		return nil, nil
+	}
+	dk, err := m.unwrap(u.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dk)
+}
+
+// loadPending appends the parked tail of an upload to buf.
+func (m *MinIOStorageService) loadPending(ctx context.Context, u *models.UploadSession, aead cipher.AEAD, buf []byte, pending int64) ([]byte, error) {
+	obj, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(pendingKey(u.ObjectKey)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	if aead == nil {
+		n, err := io.ReadFull(obj.Body, buf[len(buf):len(buf)+int(pending)])
+		return buf[:len(buf)+n], err
+	}
+	sealed, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openPending(aead, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(plain)) != pending {
+		return nil, fmt.Errorf("pending part has %d bytes, want %d", len(plain), pending)
+	}
+	return append(buf, plain...), nil
+}
+
+// storePending parks bytes that don't fill a whole part yet.
+func (m *MinIOStorageService) storePending(ctx context.Context, u *models.UploadSession, aead cipher.AEAD, data []byte) error {
+	if aead != nil {
+		var err error
+		if data, err = sealPending(aead, data); err != nil {
+			return err
+		}
+	}
+	_, err := m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(m.bucket),
+		Key:           aws.String(pendingKey(u.ObjectKey)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	return err
+}
+
+func (m *MinIOStorageService) uploadPart(ctx context.Context, u *models.UploadSession, aead cipher.AEAD, n int32, data []byte) (*models.UploadPart, error) {
+	body := data
+	if aead != nil {
+		// every part but the last holds exactly uploadPartSize bytes
+		offset := int64(n-1) * uploadPartSize
+		body = sealChunks(aead, data, offset/sealChunkSize, offset+int64(len(data)) == u.Length)
+	}
+	out, err := m.s3.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(m.bucket),
+		Key:           aws.String(u.ObjectKey),
+		UploadId:      aws.String(u.S3UploadID),
+		PartNumber:    aws.Int32(n),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("upload part %d: %w", n, err)
+	}
+	return &models.UploadPart{
+		UploadID:   u.ID,
+		PartNumber: n,
+		ETag:       aws.ToString(out.ETag),
+		SizeBytes:  int64(len(data)),
+	}, nil
+}
+
+// checkpoint persists progress and mirrors it onto u so the caller sees the new offset.
+func (m *MinIOStorageService) checkpoint(ctx context.Context, u *models.UploadSession, prev, next int64, h hash.Hash, part *models.UploadPart) error {
+	state, err := hashState(h)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(m.uploadTTL)
+	if err := m.uploads.Checkpoint(ctx, u.ID, prev, next, state, part, expires); err != nil {
+		return err
+	}
+	u.Offset = next
+	u.HashState = state
+	u.ExpiresAt = expires
+	return nil
+}
+
+func (m *MinIOStorageService) finishUpload(ctx context.Context, u *models.UploadSession, h hash.Hash) (*models.File, error) {
+	parts, err := m.uploads.Parts(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(p.PartNumber),
+		})
+	}
+
+	_, err = m.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(u.ObjectKey),
+		UploadId:        aws.String(u.S3UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		// a previous attempt may have completed the object but failed to record it
+		if _, headErr := m.s3.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(m.bucket),
+			Key:    aws.String(u.ObjectKey),
+		}); headErr != nil {
+			return nil, fmt.Errorf("complete multipart upload: %w", err)
+		}
+	}
+
+	f := &models.File{
+		ID:           u.FileID,
+		OwnerUserID:  u.OwnerUserID,
+		ObjectKey:    u.ObjectKey,
+		OriginalName: u.OriginalName,
+		SizeBytes:    u.Length,
+		ContentType:  u.ContentType,
+		SHA256:       hex.EncodeToString(h.Sum(nil)),
+		WrappedKey:   u.WrappedKey,
+		CreatedAt:    time.Now(),
+	}
+	quota, err := m.quota.quotaFor(ctx, u.OwnerUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.uploads.Complete(ctx, u.ID, f, quota); err != nil {
+		if errors.Is(err, repo.ErrQuotaExceeded) {
+			// space ran out while the upload was in flight; it can't be resumed
+			_ = m.removeObject(ctx, u.ObjectKey)
+			_ = m.removeObject(ctx, pendingKey(u.ObjectKey))
+			_ = m.uploads.Delete(ctx, u.ID)
+		}
+		return nil, err
+	}
+	_, _ = m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(pendingKey(u.ObjectKey)),
+	})
+	return f, nil
+}
+
+func (m *MinIOStorageService) TerminateUpload(ctx context.Context, userID, uploadID string) error {
+	u, err := m.uploads.ByID(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	if u == nil || u.OwnerUserID != userID {
+		return ErrUploadNotFound
+	}
+	m.abortUpload(ctx, u)
+	return m.uploads.Delete(ctx, u.ID)
+}
+
+func (m *MinIOStorageService) abortUpload(ctx context.Context, u *models.UploadSession) {
+	_, _ = m.s3.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(u.ObjectKey),
+		UploadId: aws.String(u.S3UploadID),
+	})
+	_, _ = m.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(pendingKey(u.ObjectKey)),
+	})
+}
+
+func (m *MinIOStorageService) PurgeExpiredUploads(ctx context.Context, now time.Time) (int, error) {
+	purged := 0
+	for {
+		expired, err := m.uploads.ListExpired(ctx, now, 100)
+		if err != nil {
+			return purged, err
+		}
+		if len(expired) == 0 {
+			return purged, nil
+		}
+		for _, u := range expired {
+			m.abortUpload(ctx, u)
+			if err := m.uploads.Delete(ctx, u.ID); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+func hashState(h hash.Hash) ([]byte, error) {
+	return h.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+func restoreHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if len(state) == 0 {
+		return h, nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("restore upload hash: %w", err)
+	}
+	return h, nil
+}
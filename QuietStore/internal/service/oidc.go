@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var (
+	ErrInvalidOIDCState     = errors.New("invalid or expired login state")
+	ErrOIDCEmailNotVerified = errors.New("identity provider did not report a verified email address")
+	ErrOIDCAccountConflict  = errors.New("an account with this email address exists, verify its address before logging in with the identity provider")
+	ErrOIDCNoAccount        = errors.New("no account is linked to this identity")
+)
+
+const (
+	oidcLoginTTL        = 10 * time.Minute
+	maxUsernameLen      = 32
+	usernameSuffixTries = 5
+)
+
+// OIDCService logs users in through an external OpenID Connect provider. A
+// provider identity maps to a user through linked_identities. An identity
+// seen for the first time is linked to the account with the same email
+// address, as long as both the provider and we have verified it, or gets a
+// new account if provisioning is on.
+type OIDCService struct {
+	provider      *OIDCProvider
+	oidc          repo.OIDC
+	users         repo.Users
+	passwords     PasswordHasher
+	autoProvision bool
+}
+
+func NewOIDCService(provider *OIDCProvider, oidc repo.OIDC, users repo.Users, passwords PasswordHasher, autoProvision bool) *OIDCService {
+	return &OIDCService{provider: provider, oidc: oidc, users: users, passwords: passwords, autoProvision: autoProvision}
+}
+
+func randomURLToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// StartLogin returns the provider URL to send the browser to and the state
+// the provider will hand back to the callback.
+func (s *OIDCService) StartLogin(ctx context.Context) (string, string, error) {
+	var secrets [3]string
+	for i := range secrets {
+		t, err := randomURLToken()
+		if err != nil {
+			return "", "", err
+		}
+		secrets[i] = t
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := s.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	err = s.oidc.CreateLogin(ctx, &models.OIDCLogin{
+		ID:           models.GenerateOIDCLoginID(),
+		StateHash:    hashSecretCode(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(oidcLoginTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// FinishLogin redeems the code the provider redirected back with and returns
+// the user it logs in.
+func (s *OIDCService) FinishLogin(ctx context.Context, state, code string) (*models.User, error) {
+	now := time.Now()
+	login, err := s.oidc.TakeLogin(ctx, hashSecretCode(state), now)
+	if err != nil {
+		return nil, err
+	}
+	if login == nil {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	email := models.NormalizeEmail(claims.Email)
+	if !claims.EmailVerified {
+		email = ""
+	}
+
+	identity, err := s.oidc.IdentityBySubject(ctx, s.provider.Issuer(), claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		u, err := s.users.ByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			return nil, ErrUserNotFound
+		}
+		if err := s.oidc.TouchIdentity(ctx, identity.ID, email, now); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	if email == "" {
+		return nil, ErrOIDCEmailNotVerified
+	}
+	u, err := s.users.ByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil && u.EmailVerifiedAt == nil {
+		// anyone can sign up with someone else's address, so linking to an
+		// unverified account would hand it to whoever created it
+		return nil, ErrOIDCAccountConflict
+	}
+	if u == nil {
+		if !s.autoProvision {
+			return nil, ErrOIDCNoAccount
+		}
+		if u, err = s.provision(ctx, claims, email, now); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.oidc.LinkIdentity(ctx, &models.LinkedIdentity{
+		ID:          models.GenerateLinkedIdentityID(),
+		UserID:      u.ID,
+		Provider:    s.provider.Issuer(),
+		Subject:     claims.Subject,
+		Email:       email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// provision creates a member account for a new identity. It gets a random
+// password nobody knows, a password reset sets a real one.
+func (s *OIDCService) provision(ctx context.Context, claims *OIDCClaims, email string, now time.Time) (*models.User, error) {
+	username, err := s.freeUsername(ctx, claims, email)
+	if err != nil {
+		return nil, err
+	}
+	password, err := randomURLToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &models.User{
+		ID:        models.GenerateUserID(),
+		Username:  username,
+		Email:     email,
+		Password:  hash,
+		Role:      models.RoleMember,
+		CreatedAt: now,
+	}
+	if err := s.users.Create(ctx, u); err != nil {
+		return nil, err
+	}
+	if _, err := s.users.MarkEmailVerified(ctx, u.ID, email); err != nil {
+		return nil, err
+	}
+	u.EmailVerifiedAt = &now
+	return u, nil
+}
+
+// freeUsername picks a username from the provider's preferred_username, or
+// the email's local part, adding a random suffix while it is taken.
+func (s *OIDCService) freeUsername(ctx context.Context, claims *OIDCClaims, email string) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = sanitizeUsername(base)
+
+	candidate := base
+	for i := 0; i < usernameSuffixTries; i++ {
+		existing, err := s.users.ByUsername(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidate = base + "-" + hex.EncodeToString(suffix)
+	}
+	return "", errors.New("no free username for new account")
+}
+
+func sanitizeUsername(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case 'A' <= r && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, s)
+	// room for a suffix
+	if len(s) > maxUsernameLen-7 {
+		s = s[:maxUsernameLen-7]
+	}
+	if s == "" {
+		s = "user"
+	}
+	return s
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrOIDCProvider   = errors.New("identity provider request failed")
+	ErrInvalidIDToken = errors.New("invalid id token")
+)
+
+const (
+	oidcHTTPTimeout  = 10 * time.Second
+	oidcMaxResponse  = 1 << 20
+	jwksRefetchAfter = time.Minute
+)
+
+// OIDCClaims is what a verified ID token says about the user.
+type OIDCClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+type oidcMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+type oidcKey struct {
+	alg    string
+	public any
+}
+
+// OIDCProvider runs the OpenID Connect authorization code flow with PKCE
+// against one provider. Its metadata is discovered on first use, so the server
+// starts while the provider is down, and its signing keys are refetched when
+// a token names a key it hasn't seen.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu     sync.Mutex
+	meta   *oidcMetadata
+	keys   map[string]oidcKey
+	keysAt time.Time
+}
+
+// NewOIDCProvider makes a confidential client when clientSecret is set and a
+// public one otherwise.
+func NewOIDCProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) *OIDCProvider {
+	return &OIDCProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: oidcHTTPTimeout},
+	}
+}
+
+// Issuer identifies the provider in linked identities.
+func (p *OIDCProvider) Issuer() string {
+	return p.issuer
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s: %s", ErrOIDCProvider, endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponse)).Decode(out); err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrOIDCProvider, endpoint, err)
+	}
+	return nil
+}
+
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta oidcMetadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, err
+	}
+	// OpenID Connect Discovery 1.0 section 4.3
+	if meta.Issuer != p.issuer {
+		return nil, fmt.Errorf("%w: discovery issuer %q doesn't match %q", ErrOIDCProvider, meta.Issuer, p.issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrOIDCProvider)
+	}
+	if len(meta.CodeChallengeMethods) > 0 && !slices.Contains(meta.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("%w: provider doesn't support S256 PKCE", ErrOIDCProvider)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where to send the browser to log in at the provider.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrOIDCProvider, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the ID
+// token that came with it, once verified against nonce.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic is the default unless the provider says otherwise
+	basic := p.clientSecret != "" &&
+		(len(meta.TokenAuthMethods) == 0 || slices.Contains(meta.TokenAuthMethods, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.clientID)
+		if p.clientSecret != "" {
+			form.Set("client_secret", p.clientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		// RFC 6749 section 2.3.1 wants both form encoded first
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponse)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%w: token response: %s", ErrOIDCProvider, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || out.Error != "" {
+		return nil, fmt.Errorf("%w: token endpoint: %s %s", ErrOIDCProvider, out.Error, out.ErrorDescription)
+	}
+	if out.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in token response", ErrOIDCProvider)
+	}
+	return p.verifyIDToken(ctx, meta, out.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, meta *oidcMetadata, raw, nonce string) (*OIDCClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, err := p.key(ctx, meta, kid)
+		if err != nil {
+			return nil, err
+		}
+		if k.alg != "" && k.alg != t.Method.Alg() {
+			return nil, fmt.Errorf("key %q is for %s, not %s", kid, k.alg, t.Method.Alg())
+		}
+		return k.public, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// OpenID Connect Core 1.0 section 3.1.3.7
+	aud, _ := claims.GetAudience()
+	azp, _ := claims["azp"].(string)
+	if (len(aud) > 1 || azp != "") && azp != p.clientID {
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, azp)
+	}
+
+	out := &OIDCClaims{}
+	out.Subject, _ = claims["sub"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.PreferredUsername, _ = claims["preferred_username"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		// some providers send it as a string
+		out.EmailVerified = v == "true"
+	}
+	if out.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return out, nil
+}
+
+// key finds the provider's signing key kid, refetching the key set when it's
+// unknown, at most once every jwksRefetchAfter so bogus kids can't make us
+// hammer the provider.
+func (p *OIDCProvider) key(ctx context.Context, meta *oidcMetadata, kid string) (oidcKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < jwksRefetchAfter {
+		return oidcKey{}, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set models.JWKSet
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return oidcKey{}, err
+	}
+	keys := make(map[string]oidcKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := parseJWK(jwk)
+		if err != nil {
+			// one odd key shouldn't lock everyone out
+			continue
+		}
+		keys[jwk.Kid] = oidcKey{alg: jwk.Alg, public: pub}
+	}
+	p.keys, p.keysAt = keys, time.Now()
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	return oidcKey{}, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupKey also takes a token without a kid if the provider has one key.
+func (p *OIDCProvider) lookupKey(kid string) (oidcKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func parseJWK(k models.JWK) (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits || pub.E < 3 {
+			return nil, errors.New("weak RSA key")
+		}
+		return pub, nil
+	case "EC":
+		var (
+			curve elliptic.Curve
+			check ecdh.Curve
+		)
+		switch k.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		// ecdh rejects points that aren't on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := check.NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
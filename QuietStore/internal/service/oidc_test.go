@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/oidctest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testClientID    = "quietstore"
+	testRedirectURL = "http://quietstore.test/api/v1/auth/oidc/callback"
+)
+
+type oidcFixture struct {
+	svc    *OIDCService
+	mock   *oidctest.Provider
+	store  *oidctest.Store
+	users  *oidctest.Users
+	issuer string
+}
+
+func newOIDCFixture(t *testing.T, user oidctest.User, autoProvision bool) *oidcFixture {
+	t.Helper()
+	mock, err := oidctest.NewServer(testClientID, "secret", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+
+	f := &oidcFixture{mock: mock, store: oidctest.NewStore(), users: &oidctest.Users{}, issuer: mock.Issuer}
+	provider := NewOIDCProvider(mock.Issuer, testClientID, "secret", testRedirectURL, []string{"openid", "email", "profile"})
+	f.svc = NewOIDCService(provider, f.store, f.users, NewBcryptHasher(bcrypt.MinCost), autoProvision)
+	return f
+}
+
+// login runs the whole flow, the provider approving straight away.
+func (f *oidcFixture) login(t *testing.T) (*models.User, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, state, err := f.svc.StartLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	callback, err := oidctest.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("provider returned state %q, want %q", got, state)
+	}
+	return f.svc.FinishLogin(ctx, state, callback.Query().Get("code"))
+}
+
+func (f *oidcFixture) addUser(t *testing.T, username, email string, verified bool) *models.User {
+	t.Helper()
+	u := &models.User{ID: models.GenerateUserID(), Username: username, Email: email, Role: models.RoleMember, CreatedAt: time.Now()}
+	if verified {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	if err := f.users.Create(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+var alice = oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "Alice"}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	f := newOIDCFixture(t, alice, true)
+	f.mock.DiscoveryIssuer = "https://evil.example"
+
+	if _, _, err := f.svc.StartLogin(context.Background()); !errors.Is(err, ErrOIDCProvider) {
+		t.Fatalf("StartLogin error = %v, want ErrOIDCProvider", err)
+	}
+}
+
+func TestOIDCIDTokenChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		ok     bool
+	}{
+		{"valid", nil, true},
+		{"wrong nonce", map[string]any{"nonce": "replayed"}, false},
+		{"no nonce", map[string]any{"nonce": nil}, false},
+		{"wrong audience", map[string]any{"aud": "someone-else"}, false},
+		{"wrong issuer", map[string]any{"iss": "https://evil.example"}, false},
+		{"expired", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{"several audiences without azp", map[string]any{"aud": []string{testClientID, "other"}}, false},
+		{"several audiences with our azp", map[string]any{"aud": []string{testClientID, "other"}, "azp": testClientID}, true},
+		{"azp of another client", map[string]any{"azp": "other"}, false},
+		{"azp of ours", map[string]any{"azp": testClientID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, alice, true)
+			f.mock.Claims = tt.claims
+
+			_, err := f.login(t)
+			switch {
+			case tt.ok && err != nil:
+				t.Fatalf("login failed: %v", err)
+			case !tt.ok && !errors.Is(err, ErrInvalidIDToken):
+				t.Fatalf("login error = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	f := newOIDCFixture(t, alice, true)
+	ctx := context.Background()
+
+	authURL, state, err := f.svc.StartLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := oidctest.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := callback.Query().Get("code")
+	if _, err := f.svc.FinishLogin(ctx, state, code); err != nil {
+		t.Fatalf("first callback failed: %v", err)
+	}
+	if _, err := f.svc.FinishLogin(ctx, state, code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("replayed callback error = %v, want ErrInvalidOIDCState", err)
+	}
+	if _, err := f.svc.FinishLogin(ctx, "made-up", code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("unknown state error = %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCLinksByVerifiedEmail(t *testing.T) {
+	f := newOIDCFixture(t, alice, false)
+	existing := f.addUser(t, "alice", "Alice@Example.com", true)
+
+	u, err := f.login(t)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if u.ID != existing.ID {
+		t.Fatalf("logged in as %s, want the existing account %s", u.ID, existing.ID)
+	}
+	ids := f.store.Identities()
+	if len(ids) != 1 || ids[0].UserID != existing.ID || ids[0].Subject != alice.Subject || ids[0].Provider != f.issuer {
+		t.Fatalf("linked identities = %+v", ids)
+	}
+
+	// once linked the subject decides, even if the email changes
+	f.mock.User.Email = "alice@elsewhere.example"
+	u, err = f.login(t)
+	if err != nil {
+		t.Fatalf("second login failed: %v", err)
+	}
+	if u.ID != existing.ID || len(f.store.Identities()) != 1 {
+		t.Fatalf("second login as %s with %d identities", u.ID, len(f.store.Identities()))
+	}
+}
+
+func TestOIDCLinksOneOfCaseVariantAccounts(t *testing.T) {
+	user := alice
+	user.Email = "ALICE@example.COM"
+	f := newOIDCFixture(t, user, false)
+	existing := f.addUser(t, "alice", "Alice@Example.com", true)
+
+	// a second account can't take the address in another case, so the
+	// provider email matches exactly one account
+	squatter := &models.User{ID: models.GenerateUserID(), Username: "squatter", Email: "alice@EXAMPLE.com", Role: models.RoleMember, CreatedAt: time.Now()}
+	if err := f.users.Create(context.Background(), squatter); err == nil {
+		t.Fatal("created a second account for the same address in another case")
+	}
+
+	u, err := f.login(t)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if u.ID != existing.ID {
+		t.Fatalf("logged in as %s, want the existing account %s", u.ID, existing.ID)
+	}
+	if ids := f.store.Identities(); len(ids) != 1 || ids[0].Email != "alice@example.com" {
+		t.Fatalf("linked identities = %+v", ids)
+	}
+}
+
+func TestOIDCRefusesUnverifiedLocalAccount(t *testing.T) {
+	f := newOIDCFixture(t, alice, true)
+	f.addUser(t, "squatter", alice.Email, false)
+
+	if _, err := f.login(t); !errors.Is(err, ErrOIDCAccountConflict) {
+		t.Fatalf("login error = %v, want ErrOIDCAccountConflict", err)
+	}
+	if ids := f.store.Identities(); len(ids) != 0 {
+		t.Fatalf("identity linked to an unverified account: %+v", ids)
+	}
+}
+
+func TestOIDCRequiresVerifiedProviderEmail(t *testing.T) {
+	user := alice
+	user.EmailVerified = false
+	f := newOIDCFixture(t, user, true)
+	f.addUser(t, "alice", alice.Email, true)
+
+	if _, err := f.login(t); !errors.Is(err, ErrOIDCEmailNotVerified) {
+		t.Fatalf("login error = %v, want ErrOIDCEmailNotVerified", err)
+	}
+}
+
+func TestOIDCProvisioning(t *testing.T) {
+	t.Run("off", func(t *testing.T) {
+		f := newOIDCFixture(t, alice, false)
+		if _, err := f.login(t); !errors.Is(err, ErrOIDCNoAccount) {
+			t.Fatalf("login error = %v, want ErrOIDCNoAccount", err)
+		}
+	})
+
+	t.Run("on", func(t *testing.T) {
+		f := newOIDCFixture(t, alice, true)
+		f.addUser(t, "alice", "other@example.com", true)
+
+		u, err := f.login(t)
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		if u.Email != alice.Email || u.EmailVerifiedAt == nil || u.Role != models.RoleMember {
+			t.Fatalf("provisioned %+v", u)
+		}
+		// "alice" is taken, so it gets a suffix
+		if len(u.Username) <= len("alice-") || u.Username[:len("alice-")] != "alice-" {
+			t.Fatalf("provisioned username %q", u.Username)
+		}
+		stored, _ := f.users.ByID(context.Background(), u.ID)
+		if stored == nil || stored.EmailVerifiedAt == nil {
+			t.Fatalf("stored account %+v isn't verified", stored)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+const (
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+// PasswordHasher hashes passwords into self-describing strings: PHC strings
+// for argon2id, the usual $2a$ form for bcrypt. Either hasher verifies hashes
+// made by the other, so switching algorithm or parameters only takes effect
+// as users log in and their hash is replaced.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify checks password against encoded. rehash reports that encoded
+	// isn't in the hasher's own algorithm and parameters, and should be
+	// replaced with a fresh Hash once the password is known to be right.
+	Verify(encoded, password string) (ok, rehash bool, err error)
+}
+
+// NewPasswordHasher returns the hasher for algorithm, "argon2id" or "bcrypt".
+func NewPasswordHasher(algorithm string, argon Argon2idParams, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case "argon2id":
+		return NewArgon2idHasher(argon), nil
+	case "bcrypt":
+		return NewBcryptHasher(bcryptCost), nil
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", algorithm)
+	}
+}
+
+// Argon2idParams are the RFC 9106 cost parameters. Memory is in KiB.
+type Argon2idParams struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) (bool, bool, error) {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		ok, err := verifyBcrypt(encoded, password)
+		return ok, true, err
+	}
+	ok, params, err := verifyArgon2id(encoded, password)
+	return ok, params != h.params, err
+}
+
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) (bool, bool, error) {
+	if strings.HasPrefix(encoded, argon2idPrefix) {
+		ok, _, err := verifyArgon2id(encoded, password)
+		return ok, true, err
+	}
+	ok, err := verifyBcrypt(encoded, password)
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return ok, err == nil && cost != h.cost, nil
+}
+
+func verifyBcrypt(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %v", ErrUnknownPasswordHash, err)
+	}
+}
+
+// verifyArgon2id checks a PHC string of the form
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash> and returns its parameters.
+func verifyArgon2id(encoded, password string) (bool, Argon2idParams, error) {
+	var p Argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, p, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, p, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return false, p, ErrUnknownPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, p, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 || p.Time == 0 || p.Threads == 0 {
+		return false, p, ErrUnknownPasswordHash
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, p, nil
+}
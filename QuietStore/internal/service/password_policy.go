@@ -0,0 +1,199 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+// Password policy violation codes.
+const (
+	PasswordTooShort      = "too_short"
+	PasswordTooLong       = "too_long"
+	PasswordTooFewClasses = "too_few_classes"
+	PasswordContainsName  = "contains_username"
+	PasswordContainsEmail = "contains_email"
+	PasswordBreached      = "breached"
+)
+
+// usernames and email local parts shorter than this are too likely to turn up
+// in a password by chance to reject it for that
+const minIdentifierInPassword = 3
+
+// PasswordPolicyError lists every rule a password breaks.
+type PasswordPolicyError struct {
+	Violations []models.FieldError
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy"
+}
+
+// PasswordPolicy decides which passwords users may choose. The minimum length
+// counts characters, the maximum bytes, since bytes are where bcrypt cuts
+// passwords off. minClasses is how many of lower case, upper case, digits and
+// other characters a password needs.
+type PasswordPolicy struct {
+	minLength  int
+	maxLength  int
+	minClasses int
+	breached   BreachedPasswords
+}
+
+// NewPasswordPolicy skips the breach check when breached is nil.
+func NewPasswordPolicy(minLength, maxLength, minClasses int, breached BreachedPasswords) *PasswordPolicy {
+	return &PasswordPolicy{minLength: minLength, maxLength: maxLength, minClasses: minClasses, breached: breached}
+}
+
+// Check returns a *PasswordPolicyError if password breaks any rule for the
+// account with username and email, and other errors if the breach list
+// can't be read.
+func (p *PasswordPolicy) Check(password, username, email string) error {
+	var violations []models.FieldError
+	add := func(code, format string, args ...any) {
+		violations = append(violations, models.FieldError{Field: "password", Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if utf8.RuneCountInString(password) < p.minLength {
+		add(PasswordTooShort, "must be at least %d characters", p.minLength)
+	}
+	if len(password) > p.maxLength {
+		add(PasswordTooLong, "must be at most %d bytes", p.maxLength)
+	}
+	if n := passwordClasses(password); n < p.minClasses {
+		add(PasswordTooFewClasses, "must mix at least %d of lower case letters, upper case letters, digits and symbols", p.minClasses)
+	}
+
+	lower := strings.ToLower(password)
+	if len(username) >= minIdentifierInPassword && strings.Contains(lower, strings.ToLower(username)) {
+		add(PasswordContainsName, "must not contain the username")
+	}
+	if email != "" {
+		local, _, _ := strings.Cut(strings.ToLower(email), "@")
+		if strings.Contains(lower, strings.ToLower(email)) ||
+			(len(local) >= minIdentifierInPassword && strings.Contains(lower, local)) {
+			add(PasswordContainsEmail, "must not contain the email address")
+		}
+	}
+
+	if p.breached != nil && password != "" {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			add(PasswordBreached, "appears in a list of breached passwords, choose another one")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// BreachedPasswords tells whether a password is known from a breach.
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// LoadBreachedPasswords opens a breached password list in the Have I Been
+// Pwned formats. A file holds one upper case SHA-1 hash per line, optionally
+// followed by ":count", and is loaded into memory. A directory holds the
+// k-anonymity range files instead, <first 5 hex digits>.txt with the other
+// 35 digits per line, which are read as needed, so the full list never has to
+// fit in memory.
+func LoadBreachedPasswords(path string) (BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return breachedRanges{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := breachedSet{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		var sum [sha1.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(hash)); err != nil || n != sha1.Size {
+			return nil, fmt.Errorf("%s line %d: not a SHA-1 hash", path, line)
+		}
+		set[sum] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+type breachedSet map[[sha1.Size]byte]struct{}
+
+func (s breachedSet) Contains(password string) (bool, error) {
+	_, ok := s[sha1.Sum([]byte(password))]
+	return ok, nil
+}
+
+type breachedRanges struct {
+	dir string
+}
+
+func (r breachedRanges) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(r.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
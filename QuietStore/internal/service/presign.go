@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+var (
+	ErrObjectNotUploaded = errors.New("object has not been uploaded yet")
+	ErrObjectMismatch    = errors.New("uploaded object does not match the declared size or checksum")
+)
+
+type PresignedURL struct {
+	Method    string
+	URL       string
+	Headers   map[string]string
+	ExpiresAt time.Time
+}
+
+// Presigner is implemented by backends that can hand clients short-lived URLs
+// so large transfers go straight to object storage instead of through the API.
+type Presigner interface {
+	PresignDownload(ctx context.Context, userID, fileID string) (*PresignedURL, error)
+	// PresignUpload reserves a file ID and returns a PUT URL for it. The file
+	// only becomes visible after ConfirmUpload checks what was uploaded.
+	PresignUpload(ctx context.Context, userID, originalName, contentType string, size int64, sha256Hex string) (*models.PresignedUpload, *PresignedURL, error)
+	ConfirmUpload(ctx context.Context, userID, fileID string) (*models.File, error)
+	PurgeExpiredPresignedUploads(ctx context.Context, now time.Time) (int, error)
+}
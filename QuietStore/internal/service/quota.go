@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var ErrFileTooLarge = errors.New("file exceeds the maximum file size")
+
+// QuotaPolicy enforces the per-file size limit and per-user storage quotas.
+// Zero limits mean unlimited. A nil *QuotaPolicy enforces nothing.
+type QuotaPolicy struct {
+	users        repo.Users
+	usage        repo.Usage
+	maxFileSize  int64
+	defaultQuota int64
+}
+
+func NewQuotaPolicy(users repo.Users, usage repo.Usage, maxFileSize, defaultQuota int64) *QuotaPolicy {
+	return &QuotaPolicy{users: users, usage: usage, maxFileSize: maxFileSize, defaultQuota: defaultQuota}
+}
+
+// quotaFor resolves a user's quota in bytes; zero or less means unlimited.
+func (q *QuotaPolicy) quotaFor(ctx context.Context, userID string) (int64, error) {
+	if q == nil {
+		return 0, nil
+	}
+	u, err := q.users.ByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if u != nil && u.QuotaBytes != nil {
+		return *u.QuotaBytes, nil
+	}
+	return q.defaultQuota, nil
+}
+
+// check tests an upload of size bytes (-1 when unknown) against the limits
+// before any bytes are stored. It returns the quota to commit against and the
+// room left under it (-1 for unlimited). The authoritative check happens when
+// the repo commits the usage.
+func (q *QuotaPolicy) check(ctx context.Context, userID string, size int64) (quota, remaining int64, err error) {
+	if q == nil {
+		return 0, -1, nil
+	}
+	if q.maxFileSize > 0 && size > q.maxFileSize {
+		return 0, 0, ErrFileTooLarge
+	}
+	quota, err = q.quotaFor(ctx, userID)
+	if err != nil || quota <= 0 {
+		return quota, -1, err
+	}
+	used, _, err := q.usage.ByUser(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	remaining = quota - used
+	if remaining < 0 || size > remaining {
+		return 0, 0, repo.ErrQuotaExceeded
+	}
+	return quota, remaining, nil
+}
+
+// admit runs check and wraps r so that a body turning out bigger than allowed
+// fails mid-stream instead of after it has been stored.
+func (q *QuotaPolicy) admit(ctx context.Context, userID string, size int64, r io.Reader) (int64, io.Reader, error) {
+	quota, remaining, err := q.check(ctx, userID, size)
+	if err != nil || q == nil {
+		return quota, r, err
+	}
+	if q.maxFileSize > 0 {
+		r = &capReader{r: r, left: q.maxFileSize, err: ErrFileTooLarge}
+	}
+	if remaining >= 0 {
+		r = &capReader{r: r, left: remaining, err: repo.ErrQuotaExceeded}
+	}
+	return quota, r, nil
+}
+
+// Usage reports a user's stored bytes and files against their quota.
+func (q *QuotaPolicy) Usage(ctx context.Context, userID string) (*models.Usage, error) {
+	used, count, err := q.usage.ByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := &models.Usage{UsedBytes: used, FileCount: count}
+
+	quota, err := q.quotaFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if quota > 0 {
+		remaining := max(quota-used, 0)
+		out.QuotaBytes, out.RemainingBytes = &quota, &remaining
+	}
+	return out, nil
+}
+
+// capReader passes through at most left bytes and fails with err as soon as
+// the underlying reader has more than that.
+type capReader struct {
+	r    io.Reader
+	left int64
+	err  error
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.left < 0 {
+		return 0, c.err
+	}
+	// read one byte past the limit so an exact fit still reaches EOF cleanly
+	if int64(len(p)) > c.left+1 {
+		p = p[:c.left+1]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n + int(c.left), c.err
+	}
+	return n, err
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+var (
+	ErrShareNotFound = errors.New("share not found")
+	// ErrShareGone is returned for shares that were revoked, have expired or
+	// have used up their downloads.
+	ErrShareGone          = errors.New("share is no longer available")
+	ErrSharePassword      = errors.New("share password required or incorrect")
+	ErrInvalidShareTarget = errors.New("exactly one of file_id and folder_id is required")
+)
+
+// ShareService hands out public links to files and folders and serves them
+// to unauthenticated clients on the owner's behalf.
+type ShareService struct {
+	shares    repo.Shares
+	storage   StorageService
+	folders   *FolderService
+	passwords PasswordHasher
+}
+
+func NewShareService(shares repo.Shares, storage StorageService, folders *FolderService, passwords PasswordHasher) *ShareService {
+	return &ShareService{shares: shares, storage: storage, folders: folders, passwords: passwords}
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateShare returns the new share and its token. Only a hash of the token is
+// stored, so this is the one time it can be shown to the owner.
+func (s *ShareService) CreateShare(ctx context.Context, userID string, req models.CreateShareRequest) (*models.Share, string, error) {
+	if (req.FileID == "") == (req.FolderID == "") {
+		return nil, "", ErrInvalidShareTarget
+	}
+	if req.FileID != "" {
+		meta, err := s.storage.StatFile(ctx, userID, req.FileID)
+		if err != nil {
+			return nil, "", err
+		}
+		if meta == nil {
+			return nil, "", ErrFileNotFound
+		}
+	} else if _, err := s.folders.folder(ctx, userID, req.FolderID); err != nil {
+		return nil, "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	sh := &models.Share{
+		ID:           models.GenerateShareID(),
+		OwnerUserID:  userID,
+		TokenHash:    hashShareToken(token),
+		FileID:       optionalID(req.FileID),
+		FolderID:     optionalID(req.FolderID),
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+		CreatedAt:    time.Now(),
+	}
+	if req.Password != "" {
+		hash, err := s.passwords.Hash(req.Password)
+		if err != nil {
+			return nil, "", err
+		}
+		sh.PasswordHash = hash
+		sh.HasPassword = true
+	}
+
+	if err := s.shares.Create(ctx, sh); err != nil {
+		return nil, "", err
+	}
+	return sh, token, nil
+}
+
+func (s *ShareService) ListShares(ctx context.Context, userID string) ([]*models.Share, error) {
+	return s.shares.ListByOwner(ctx, userID)
+}
+
+func (s *ShareService) RevokeShare(ctx context.Context, userID, shareID string) error {
+	ok, err := s.shares.Revoke(ctx, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// Resolve looks up a share by its public token and checks that it is still
+// usable and that password matches.
+func (s *ShareService) Resolve(ctx context.Context, token, password string) (*models.Share, error) {
+	sh, err := s.shares.ByTokenHash(ctx, hashShareToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if sh == nil {
+		return nil, ErrShareNotFound
+	}
+	if sh.RevokedAt != nil ||
+		(sh.ExpiresAt != nil && !time.Now().Before(*sh.ExpiresAt)) ||
+		(sh.MaxDownloads != nil && sh.DownloadCount >= *sh.MaxDownloads) {
+		return nil, ErrShareGone
+	}
+	if sh.HasPassword {
+		// share hashes aren't upgraded, old ones stay valid until the share ends
+		ok, _, err := s.passwords.Verify(sh.PasswordHash, password)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrSharePassword
+		}
+	}
+	return sh, nil
+}
+
+// ListShared returns the contents of a shared folder.
+func (s *ShareService) ListShared(ctx context.Context, sh *models.Share) (*models.SharedFolderContents, error) {
+	if sh.FolderID == nil {
+		return nil, ErrFileNotFound
+	}
+	contents, err := s.folders.ListFolder(ctx, sh.OwnerUserID, *sh.FolderID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &models.SharedFolderContents{
+		Folder: models.SharedFolder{
+			ID:        contents.Folder.ID,
+			Name:      contents.Folder.Name,
+			CreatedAt: contents.Folder.CreatedAt,
+		},
+		Folders: make([]*models.SharedFolder, 0, len(contents.Folders)),
+		Files:   make([]*models.SharedFile, 0, len(contents.Files)),
+	}
+	paths := map[string]string{}
+	for _, f := range contents.Folders {
+		paths[f.ID] = f.Path
+		out.Folders = append(out.Folders, &models.SharedFolder{ID: f.ID, Name: f.Name, Path: f.Path, CreatedAt: f.CreatedAt})
+	}
+	for _, f := range contents.Files {
+		path := f.OriginalName
+		if f.FolderID != nil && paths[*f.FolderID] != "" {
+			path = paths[*f.FolderID] + "/" + f.OriginalName
+		}
+		out.Files = append(out.Files, &models.SharedFile{
+			ID:          f.ID,
+			Name:        f.OriginalName,
+			Path:        path,
+			SizeBytes:   f.SizeBytes,
+			ContentType: f.ContentType,
+			CreatedAt:   f.CreatedAt,
+			UpdatedAt:   f.UpdatedAt,
+		})
+	}
+	return out, nil
+}
+
+// sharedFileID resolves which file a request against a share refers to: the
+// shared file itself, or fileID when it lies inside the shared folder.
+func (s *ShareService) sharedFileID(ctx context.Context, sh *models.Share, fileID string) (string, error) {
+	if sh.FileID != nil {
+		if fileID != "" && fileID != *sh.FileID {
+			return "", ErrFileNotFound
+		}
+		return *sh.FileID, nil
+	}
+	if fileID == "" {
+		return "", ErrFileNotFound
+	}
+	ok, err := s.folders.contains(ctx, sh.OwnerUserID, *sh.FolderID, fileID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrFileNotFound
+	}
+	return fileID, nil
+}
+
+// StatShared returns the metadata of a shared file without counting a download.
+func (s *ShareService) StatShared(ctx context.Context, sh *models.Share, fileID string) (*models.File, error) {
+	fileID, err := s.sharedFileID(ctx, sh, fileID)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := s.storage.StatFile(ctx, sh.OwnerUserID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, ErrFileNotFound
+	}
+	return meta, nil
+}
+
+// OpenShared opens a shared file (fileID selects one inside a shared folder)
+// and counts the download against the share.
+func (s *ShareService) OpenShared(ctx context.Context, sh *models.Share, fileID string) (*models.File, io.ReadCloser, error) {
+	fileID, err := s.sharedFileID(ctx, sh, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, rc, err := s.storage.OpenFile(ctx, sh.OwnerUserID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok, err := s.shares.ClaimDownload(ctx, sh.ID, time.Now())
+	if err == nil && !ok {
+		err = ErrShareGone
+	}
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return meta, rc, nil
+}
@@ -2,16 +2,53 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
 )
 
+var (
+	ErrFileNotFound    = errors.New("file not found")
+	ErrVersionNotFound = errors.New("version not found")
+)
+
 type StorageService interface {
-	SaveFile(ctx context.Context, userID, originalName, contentType string, size int64, r io.Reader) (*models.File, error)
+	// SaveFile consumes r until EOF into a new file in folderID ("" for the root);
+	// size is advisory and is -1 when the caller streams a body of unknown length.
+	SaveFile(ctx context.Context, userID, folderID, originalName, contentType string, size int64, r io.Reader) (*models.File, error)
 	OpenFile(ctx context.Context, userID, fileID string) (*models.File, io.ReadCloser, error)
+	// OpenFileRange returns length bytes starting at offset.
+	OpenFileRange(ctx context.Context, userID, fileID string, offset, length int64) (*models.File, io.ReadCloser, error)
+	// StatFile returns the metadata of a file the user owns, or nil if there is none.
+	StatFile(ctx context.Context, userID, fileID string) (*models.File, error)
 	ListFiles(ctx context.Context, userID string, limit, offset int) ([]*models.File, error)
+	// DeleteFile moves a file to the trash; it is removed for good by EmptyTrash or PurgeTrash.
 	DeleteFile(ctx context.Context, userID, fileID string) error
+	ListTrash(ctx context.Context, userID string, limit, offset int) ([]*models.File, error)
+	RestoreFile(ctx context.Context, userID, fileID string) error
+	EmptyTrash(ctx context.Context, userID string) (int, error)
+	// PurgeTrash permanently removes every file trashed before the given time.
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
 	SearchFiles(ctx context.Context, userID, q, contentType string, minSize, maxSize int64, limit, offset int) ([]*models.File, error)
 	RenameFile(ctx context.Context, userID, fileID, newName string) error
+
+	// SaveFileVersion stores r as a new current version of an existing file and
+	// prunes old versions beyond the owner's retention limit.
+	SaveFileVersion(ctx context.Context, userID, fileID, contentType string, r io.Reader) (*models.File, error)
+	ListVersions(ctx context.Context, userID, fileID string) ([]*models.FileVersion, error)
+	OpenFileVersion(ctx context.Context, userID, fileID string, version int) (*models.FileVersion, io.ReadCloser, error)
+	PromoteVersion(ctx context.Context, userID, fileID string, version int) (*models.File, error)
+}
+
+// objectKey is the storage key layout shared by every backend: user/<id>/YYYY/MM/<fileID>.
+func objectKey(userID, fileID string, t time.Time) string {
+	return fmt.Sprintf("user/%s/%04d/%02d/%s", userID, t.Year(), int(t.Month()), fileID)
+}
+
+// blobKey is where deduplicated content lives: blobs/<sha256>.
+func blobKey(sum string) string {
+	return "blobs/" + sum
 }
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// RFC 6238 parameters. These are the defaults every authenticator app
+// supports, so they are not configurable.
+const (
+	totpSecretSize = 20
+	totpDigits     = 6
+	totpPeriod     = 30
+	// totpSkew is how many steps either side of now are accepted, to allow for
+	// clock drift and slow typing.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode is the RFC 4226 HOTP value of secret at counter step.
+func totpCode(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", bin%1000000)
+}
+
+// totpMatch checks code against the steps around now and returns the step it
+// matched.
+func totpMatch(secret []byte, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	cur := totpStep(now)
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI authenticator apps read from a QR code.
+func totpURI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", totpEncoding.EncodeToString(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
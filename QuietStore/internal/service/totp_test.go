@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+// rfc6238Secret is the SHA-1 seed of RFC 6238 Appendix B.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Appendix B lists 8-digit codes, ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		want := tt.code[len(tt.code)-totpDigits:]
+		if got := totpCode(rfc6238Secret, totpStep(now)); got != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+		}
+		step, ok := totpMatch(rfc6238Secret, want, now)
+		if !ok || step != totpStep(now) {
+			t.Errorf("totpMatch at %d = %d, %v", tt.unix, step, ok)
+		}
+	}
+}
+
+func TestTOTPMatchWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	cur := totpStep(now)
+
+	tests := []struct {
+		name  string
+		step  int64
+		match bool
+	}{
+		{"current step", cur, true},
+		{"previous step", cur - 1, true},
+		{"next step", cur + 1, true},
+		{"two steps back", cur - 2, false},
+		{"two steps ahead", cur + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := totpMatch(rfc6238Secret, totpCode(rfc6238Secret, tt.step), now)
+			if ok != tt.match {
+				t.Fatalf("totpMatch = %v, want %v", ok, tt.match)
+			}
+			if ok && step != tt.step {
+				t.Fatalf("matched step %d, want %d", step, tt.step)
+			}
+		})
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := totpMatch(rfc6238Secret, code, now); ok {
+			t.Errorf("totpMatch accepted %q", code)
+		}
+	}
+}
+
+// memMFA keeps one user's TOTP state the way MFAPGX does.
+type memMFA struct {
+	repo.MFA
+	totp     *models.TOTP
+	recovery map[string]bool
+}
+
+func (m *memMFA) TOTP(context.Context, string) (*models.TOTP, error) {
+	return m.totp, nil
+}
+
+func (m *memMFA) UseTOTPStep(_ context.Context, _ string, step int64) (bool, error) {
+	if step <= m.totp.LastStep {
+		return false, nil
+	}
+	m.totp.LastStep = step
+	return true, nil
+}
+
+func (m *memMFA) UseRecoveryCode(_ context.Context, _ string, hash string) (bool, error) {
+	if !m.recovery[hash] {
+		return false, nil
+	}
+	delete(m.recovery, hash)
+	return true, nil
+}
+
+func TestMFAVerifyRejectsReplay(t *testing.T) {
+	enabled := time.Now()
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memMFA{
+		totp:     &models.TOTP{UserID: "u", Secret: rfc6238Secret, EnabledAt: &enabled},
+		recovery: map[string]bool{},
+	}
+	for _, h := range hashes {
+		store.recovery[h] = true
+	}
+	svc := NewMFAService(store, nil, "QuietStore")
+	ctx := context.Background()
+	// Verify reads the clock itself, so stay clear of a step change
+	if left := totpPeriod - time.Now().Unix()%totpPeriod; left < 2 {
+		time.Sleep(time.Duration(left) * time.Second)
+	}
+	cur := totpStep(time.Now())
+
+	if err := svc.Verify(ctx, "u", totpCode(rfc6238Secret, cur-1)); err != nil {
+		t.Fatalf("code of the previous step: %v", err)
+	}
+	if err := svc.Verify(ctx, "u", totpCode(rfc6238Secret, cur)); err != nil {
+		t.Fatalf("current code: %v", err)
+	}
+	if err := svc.Verify(ctx, "u", totpCode(rfc6238Secret, cur)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("replayed code: %v, want ErrInvalidMFACode", err)
+	}
+	// still inside the window, but older than the last code used
+	if err := svc.Verify(ctx, "u", totpCode(rfc6238Secret, cur-1)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("earlier code after a later one: %v, want ErrInvalidMFACode", err)
+	}
+	if err := svc.Verify(ctx, "u", totpCode(rfc6238Secret, cur-2)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("code outside the window: %v, want ErrInvalidMFACode", err)
+	}
+
+	// recovery codes are typed however the user likes, and work once
+	typed := " " + strings.ToUpper(codes[0]) + " "
+	if err := svc.Verify(ctx, "u", typed); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := svc.Verify(ctx, "u", codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("reused recovery code: %v, want ErrInvalidMFACode", err)
+	}
+
+	store.totp.EnabledAt = nil
+	if err := svc.Verify(ctx, "u", codes[1]); !errors.Is(err, ErrMFANotEnabled) {
+		t.Fatalf("verify with MFA off: %v, want ErrMFANotEnabled", err)
+	}
+}
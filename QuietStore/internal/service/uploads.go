@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadExpired        = errors.New("upload expired")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+)
+
+// ResumableUploads is implemented by backends that can accept an upload in
+// several requests and pick it up again after a restart (see the tus handlers).
+type ResumableUploads interface {
+	// CreateUpload starts a session. A zero-length upload is finished immediately
+	// and the resulting file is returned alongside the session.
+	CreateUpload(ctx context.Context, userID, originalName, contentType string, length int64, metadata string) (*models.UploadSession, *models.File, error)
+	GetUpload(ctx context.Context, userID, uploadID string) (*models.UploadSession, error)
+	// WriteUpload appends r at offset. The file is returned once the last byte lands.
+	WriteUpload(ctx context.Context, userID, uploadID string, offset int64, r io.Reader) (*models.UploadSession, *models.File, error)
+	TerminateUpload(ctx context.Context, userID, uploadID string) error
+	PurgeExpiredUploads(ctx context.Context, now time.Time) (int, error)
+}
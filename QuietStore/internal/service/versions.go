@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/models"
+	"github.com/alexfisher03/quietstore-service/QuietStore/internal/repo"
+)
+
+// maxVersionsFor resolves a user's version retention limit. Zero or less means unlimited.
+func maxVersionsFor(ctx context.Context, users repo.Users, def int, userID string) (int, error) {
+	u, err := users.ByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if u != nil && u.MaxVersions != nil {
+		return *u.MaxVersions, nil
+	}
+	return def, nil
+}
+
+// hasVersionKey reports whether any version stores its object at key.
+func hasVersionKey(list []*models.FileVersion, key string) bool {
+	for _, v := range list {
+		if v.ObjectKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// versionsToPrune picks the oldest versions beyond keep, never the current one.
+// list must be ordered oldest first.
+func versionsToPrune(list []*models.FileVersion, current, keep int) []*models.FileVersion {
+	excess := len(list) - keep
+	if keep <= 0 || excess <= 0 {
+		return nil
+	}
+	out := make([]*models.FileVersion, 0, excess)
+	for _, v := range list {
+		if len(out) == excess {
+			break
+		}
+		if v.Version != current {
+			out = append(out, v)
+		}
+	}
+	return out
+}